@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"net"
+
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+// errForbidden is the error sent to clients outside a route's ACL.
+var errForbidden = errors.New("forbidden.")
+
+// ACL lists the client networks allowed on the boot and admin endpoints,
+// and with -tls-client-ca the names of the client certificates allowed on
+// them. An empty list allows every client.
+type ACL struct {
+	Boot  []string `json:"boot,omitempty"`
+	Admin []string `json:"admin,omitempty"`
+
+	BootClients  []string `json:"boot-clients,omitempty"`
+	AdminClients []string `json:"admin-clients,omitempty"`
+
+	boot  []*net.IPNet
+	admin []*net.IPNet
+}
+
+// UnmarshalJSON decodes the ACL and parses its CIDRs, failing the config
+// load when one is invalid.
+func (a *ACL) UnmarshalJSON(data []byte) error {
+	type acl ACL
+	var decoded acl
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*a = ACL(decoded)
+	var err error
+	if a.boot, err = parseCIDRs(a.Boot); err != nil {
+		return err
+	}
+	a.admin, err = parseCIDRs(a.Admin)
+	return err
+}
+
+// Parses the CIDRs of an ACL.
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks[i] = network
+	}
+	return networks, nil
+}
+
+// Reports whether the client IP is within one of the networks, always true
+// when there are none.
+func aclAllows(networks []*net.IPNet, clientIP string) bool {
+	if len(networks) == 0 {
+		return true
+	}
+	client := net.ParseIP(clientIP)
+	if client == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(client) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the client, by its IP and TLS connection, is allowed on
+// the admin endpoints. A nil ACL allows every client.
+func (a *ACL) allowsAdmin(clientIP string, state *tls.ConnectionState) bool {
+	return a == nil || aclAllows(a.admin, clientIP) && clientCertAllows(a.AdminClients, state)
+}
+
+// Reports whether the client, by its IP and TLS connection, is allowed on
+// the boot endpoints. A nil ACL allows every client.
+func (a *ACL) allowsBoot(clientIP string, state *tls.ConnectionState) bool {
+	return a == nil || aclAllows(a.boot, clientIP) && clientCertAllows(a.BootClients, state)
+}
+
+// Reports whether the request's client is allowed on the admin endpoints.
+func (s *Spriteful) adminAllowed(req *restful.Request) bool {
+	return s.config().ACL.allowsAdmin(clientIP(req), req.Request.TLS)
+}
+
+// Answers boot requests from clients outside the boot ACL with a 403.
+func (s *Spriteful) bootACLFilter(req *restful.Request, res *restful.Response, chain *restful.FilterChain) {
+	if !s.config().ACL.allowsBoot(clientIP(req), req.Request.TLS) {
+		logrus.Warnf(`boot request for "%s" from "%s" denied by acl.`, req.Request.URL.Path, req.Request.RemoteAddr)
+		writeBootError(res, http.StatusForbidden, errForbidden)
+		return
+	}
+	chain.ProcessFilter(req, res)
+}
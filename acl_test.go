@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"net/http"
+)
+
+func TestACL(t *testing.T) {
+	// httptest requests come from 192.0.2.1.
+	config := `{"acl": {"boot": ["192.0.2.0/24"], "admin": ["10.9.0.0/24"]}}`
+	s, err := decodeConfig(strings.NewReader(config), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Servers = []Server{{MacAddress: validMac, Kernel: "http://images/vmlinuz"}}
+	if res := serve(s, "GET", "/api/v1/boot/"+validMac, nil); res.Code != http.StatusOK {
+		t.Errorf("boot requests within the boot acl should be allowed, status: %d", res.Code)
+	}
+	if res := serve(s, "GET", "/api/v1/macs", nil); res.Code != http.StatusForbidden {
+		t.Errorf("admin requests outside the admin acl should be forbidden, status: %d", res.Code)
+	}
+
+	s.ACL.boot, _ = parseCIDRs([]string{"10.1.0.0/16"})
+	if res := serve(s, "GET", "/api/v1/boot/"+validMac, nil); res.Code != http.StatusForbidden {
+		t.Errorf("boot requests outside the boot acl should be forbidden, status: %d", res.Code)
+	}
+	if res := serve(s, "GET", "/healthz", nil); res.Code != http.StatusOK {
+		t.Errorf("health checks should ignore the acl, status: %d", res.Code)
+	}
+
+	s.ACL = nil
+	for _, path := range []string{"/api/v1/boot/" + validMac, "/api/v1/macs"} {
+		if res := serve(s, "GET", path, nil); res.Code != http.StatusOK {
+			t.Errorf("%s should be allowed without an acl, status: %d", path, res.Code)
+		}
+	}
+
+	if _, err := decodeConfig(strings.NewReader(`{"acl": {"boot": ["bogus"]}}`), false); err == nil {
+		t.Error("an acl with an invalid cidr should not decode")
+	}
+}
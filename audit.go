@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+)
+
+type (
+	// auditLog keeps the most recent boot decisions in a fixed-size ring.
+	// A nil auditLog records nothing.
+	auditLog struct {
+		mu      sync.Mutex
+		entries []AuditEntry
+		next    int
+		count   int
+	}
+
+	// AuditEntry is a single boot decision.
+	AuditEntry struct {
+		Time       time.Time `json:"time"`
+		MacAddress string    `json:"mac"`
+		Kernel     string    `json:"kernel,omitempty"`
+		ClientIP   string    `json:"client-ip,omitempty"`
+		Match      string    `json:"match"`
+		Status     int       `json:"status"`
+	}
+)
+
+// These are the lookup layers a boot decision can be matched by.
+const (
+	MatchPin       = "pin"
+	MatchVLAN      = "vlan"
+	MatchExact     = "exact"
+	MatchSerial    = "serial"
+	MatchWildcard  = "wildcard"
+	MatchFallback  = "fallback"
+	MatchDiscovery = "discovery"
+	MatchNone      = "none"
+)
+
+// Creates an audit log keeping the last size decisions, or nil if size
+// isn't positive.
+func newAuditLog(size int) *auditLog {
+	if size <= 0 {
+		return nil
+	}
+	return &auditLog{entries: make([]AuditEntry, size)}
+}
+
+// Records a boot decision, overwriting the oldest once the log is full.
+func (a *auditLog) record(entry AuditEntry) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entry.Time = entry.Time.UTC()
+	a.entries[a.next] = entry
+	a.next = (a.next + 1) % len(a.entries)
+	if a.count < len(a.entries) {
+		a.count++
+	}
+}
+
+// Returns up to limit of the most recent decisions, newest first. A limit
+// that isn't positive returns all of them.
+func (a *auditLog) recent(limit int) []AuditEntry {
+	entries := []AuditEntry{}
+	if a == nil {
+		return entries
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if limit <= 0 || limit > a.count {
+		limit = a.count
+	}
+	for i := 1; i <= limit; i++ {
+		entries = append(entries, a.entries[(a.next-i+len(a.entries))%len(a.entries)])
+	}
+	return entries
+}
+
+// Returns a copy of the server recording the lookup layer that matched it.
+func withMatch(server *Server, match string) *Server {
+	matched := *server
+	matched.match = match
+	return &matched
+}
+
+// Handles the http request listing the recent boot decisions.
+func (s *Spriteful) handleAuditRequest(req *restful.Request, res *restful.Response) {
+	limit := 0
+	if value := req.QueryParameter("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			res.WriteErrorString(http.StatusBadRequest, "limit must be a non-negative integer.")
+			return
+		}
+	}
+	res.WriteAsJson(s.audit.recent(limit))
+}
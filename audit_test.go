@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"encoding/json"
+	"net/http"
+)
+
+func TestAuditLogRing(t *testing.T) {
+	audit := newAuditLog(3)
+	for _, mac := range []string{"1", "2", "3", "4", "5"} {
+		audit.record(AuditEntry{MacAddress: mac})
+	}
+	entries := audit.recent(0)
+	if len(entries) != 3 || entries[0].MacAddress != "5" || entries[2].MacAddress != "3" {
+		t.Errorf("the three newest entries are expected newest first, got %+v", entries)
+	}
+	if entries := audit.recent(2); len(entries) != 2 || entries[1].MacAddress != "4" {
+		t.Errorf("the limit should apply, got %+v", entries)
+	}
+	if entries := (*auditLog)(nil).recent(0); len(entries) != 0 {
+		t.Errorf("a disabled audit log should be empty, got %+v", entries)
+	}
+}
+
+func TestAuditRequest(t *testing.T) {
+	s := &Spriteful{
+		Servers:    []Server{{MacAddress: validMac, Kernel: "vmlinuz"}},
+		audit:      newAuditLog(10),
+		adminToken: "secret",
+	}
+	serve(s, "GET", "/api/v1/boot/"+validMac, nil)
+	serve(s, "GET", "/api/v1/boot/"+invalidMac, nil)
+
+	if res := serve(s, "GET", "/api/v1/audit", nil); res.Code != http.StatusUnauthorized {
+		t.Errorf("the audit log without a token should be unauthorized, status: %d", res.Code)
+	}
+	auth := http.Header{"Authorization": {"Bearer secret"}}
+	var entries []AuditEntry
+	json.Unmarshal(serve(s, "GET", "/api/v1/audit", auth).Body.Bytes(), &entries)
+	if len(entries) != 2 {
+		t.Fatalf("both decisions are expected, got %+v", entries)
+	}
+	if miss := entries[0]; miss.MacAddress != invalidMac || miss.Match != MatchNone || miss.Status != http.StatusNotFound {
+		t.Errorf("the miss should be recorded first, got %+v", miss)
+	}
+	if boot := entries[1]; boot.MacAddress != validMac || boot.Kernel != "vmlinuz" || boot.Match != MatchExact || boot.Status != http.StatusOK || boot.ClientIP == "" {
+		t.Errorf("the boot should be recorded with its kernel, match and client ip, got %+v", boot)
+	}
+	json.Unmarshal(serve(s, "GET", "/api/v1/audit?limit=1", auth).Body.Bytes(), &entries)
+	if len(entries) != 1 {
+		t.Errorf("only one decision is expected with limit=1, got %+v", entries)
+	}
+	if res := serve(s, "GET", "/api/v1/audit?limit=x", auth); res.Code != http.StatusBadRequest {
+		t.Errorf("an invalid limit should be a bad request, status: %d", res.Code)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+// Guards admin endpoints with the admin ACL and the configured bearer token.
+// Admin endpoints are open when no token is configured.
+func (s *Spriteful) adminFilter(req *restful.Request, res *restful.Response, chain *restful.FilterChain) {
+	if !s.adminAllowed(req) {
+		logrus.Warnf(`admin request for "%s" from "%s" denied by acl.`, req.Request.URL.Path, req.Request.RemoteAddr)
+		res.WriteErrorString(http.StatusForbidden, "forbidden.")
+		return
+	}
+	if !s.adminTokenValid(req.HeaderParameter("Authorization")) {
+		logrus.Warnf(`unauthorized request for "%s" from "%s".`, req.Request.URL.Path, req.Request.RemoteAddr)
+		res.WriteErrorString(http.StatusUnauthorized, "unauthorized.")
+		return
+	}
+	chain.ProcessFilter(req, res)
+}
+
+// Reports whether the Authorization value carries the admin token, always
+// true when no token is configured.
+func (s *Spriteful) adminTokenValid(authorization string) bool {
+	if s.adminToken == "" {
+		return true
+	}
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1
+}
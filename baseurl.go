@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"net/url"
+)
+
+// Parses the base URL relative kernels and initrds are resolved against. It
+// must be absolute, and its path is treated as a directory.
+func parseBaseURL(raw string) (*url.URL, error) {
+	base, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !base.IsAbs() || base.Host == "" {
+		return nil, errors.New("base url must be absolute")
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	return base, nil
+}
+
+// Returns the asset resolved against the base URL. Absolute, empty and local
+// boot assets, and every asset when there is no valid base URL, are returned
+// unchanged.
+func resolveAsset(base, asset string) string {
+	if base == "" || asset == "" || asset == LocalBoot {
+		return asset
+	}
+	ref, err := url.Parse(asset)
+	if err != nil || ref.IsAbs() {
+		return asset
+	}
+	parsed, err := parseBaseURL(base)
+	if err != nil {
+		return asset
+	}
+	return parsed.ResolveReference(ref).String()
+}
+
+// Returns copies of the servers with their kernels and initrds resolved
+// against the base URL, for the checks that fetch them.
+func withBaseURL(servers []Server, base string) []Server {
+	if base == "" {
+		return servers
+	}
+	resolved := make([]Server, len(servers))
+	for i, server := range servers {
+		server.Kernel = resolveAsset(base, server.Kernel)
+		initrds := make([]Initrd, len(server.Initrd))
+		for j, initrd := range server.Initrd {
+			initrd.URL = resolveAsset(base, initrd.URL)
+			initrds[j] = initrd
+		}
+		server.Initrd = initrds
+		resolved[i] = server
+	}
+	return resolved
+}
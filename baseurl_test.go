@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"encoding/json"
+)
+
+func TestBaseURL(t *testing.T) {
+	s := &Spriteful{
+		BaseURL: "http://images/boot",
+		Servers: []Server{{
+			MacAddress: validMac,
+			Kernel:     "vmlinuz",
+			Initrd:     []Initrd{{URL: "initrd/base.img"}, {URL: "https://mirror/extra.img"}, {URL: "/root.img"}},
+		}},
+	}
+	var response PixieResponse
+	json.Unmarshal(serve(s, "GET", "/api/v1/boot/"+validMac, nil).Body.Bytes(), &response)
+	want := []string{"http://images/boot/initrd/base.img", "https://mirror/extra.img", "http://images/root.img"}
+	if response.Kernel != "http://images/boot/vmlinuz" || strings.Join(response.Initrd, " ") != strings.Join(want, " ") {
+		t.Errorf("relative assets should resolve against the base url, got %+v", response)
+	}
+	if assets := remoteAssets(withBaseURL(s.Servers, s.BaseURL)); len(assets) != 4 {
+		t.Errorf("resolved assets should be fetchable, got %v", assets)
+	}
+
+	for _, invalid := range []string{`{"base-url": "images/boot"}`, `{"base-url": "/boot"}`, `{"base-url": "http://"}`} {
+		if _, err := decodeConfig(strings.NewReader(invalid), false); err == nil || !strings.Contains(err.Error(), "base-url") {
+			t.Errorf("%s should not load, got %v", invalid, err)
+		}
+	}
+}
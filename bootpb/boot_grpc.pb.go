@@ -0,0 +1,171 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package bootpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// BootClient is the client API for Boot service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type BootClient interface {
+	// Boot returns the boot configuration for a MAC address.
+	Boot(ctx context.Context, in *BootRequest, opts ...grpc.CallOption) (*BootResponse, error)
+	// WatchConfig streams the current config, then every config published
+	// after it, e.g. by a reload. Slow watchers skip to the latest config.
+	WatchConfig(ctx context.Context, in *WatchConfigRequest, opts ...grpc.CallOption) (Boot_WatchConfigClient, error)
+}
+
+type bootClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBootClient(cc grpc.ClientConnInterface) BootClient {
+	return &bootClient{cc}
+}
+
+func (c *bootClient) Boot(ctx context.Context, in *BootRequest, opts ...grpc.CallOption) (*BootResponse, error) {
+	out := new(BootResponse)
+	err := c.cc.Invoke(ctx, "/spriteful.v1.Boot/Boot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bootClient) WatchConfig(ctx context.Context, in *WatchConfigRequest, opts ...grpc.CallOption) (Boot_WatchConfigClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Boot_ServiceDesc.Streams[0], "/spriteful.v1.Boot/WatchConfig", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &bootWatchConfigClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Boot_WatchConfigClient interface {
+	Recv() (*ConfigUpdate, error)
+	grpc.ClientStream
+}
+
+type bootWatchConfigClient struct {
+	grpc.ClientStream
+}
+
+func (x *bootWatchConfigClient) Recv() (*ConfigUpdate, error) {
+	m := new(ConfigUpdate)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// BootServer is the server API for Boot service.
+// All implementations must embed UnimplementedBootServer
+// for forward compatibility
+type BootServer interface {
+	// Boot returns the boot configuration for a MAC address.
+	Boot(context.Context, *BootRequest) (*BootResponse, error)
+	// WatchConfig streams the current config, then every config published
+	// after it, e.g. by a reload. Slow watchers skip to the latest config.
+	WatchConfig(*WatchConfigRequest, Boot_WatchConfigServer) error
+	mustEmbedUnimplementedBootServer()
+}
+
+// UnimplementedBootServer must be embedded to have forward compatible implementations.
+type UnimplementedBootServer struct {
+}
+
+func (UnimplementedBootServer) Boot(context.Context, *BootRequest) (*BootResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Boot not implemented")
+}
+func (UnimplementedBootServer) WatchConfig(*WatchConfigRequest, Boot_WatchConfigServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchConfig not implemented")
+}
+func (UnimplementedBootServer) mustEmbedUnimplementedBootServer() {}
+
+// UnsafeBootServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BootServer will
+// result in compilation errors.
+type UnsafeBootServer interface {
+	mustEmbedUnimplementedBootServer()
+}
+
+func RegisterBootServer(s grpc.ServiceRegistrar, srv BootServer) {
+	s.RegisterService(&Boot_ServiceDesc, srv)
+}
+
+func _Boot_Boot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BootRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BootServer).Boot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/spriteful.v1.Boot/Boot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BootServer).Boot(ctx, req.(*BootRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Boot_WatchConfig_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchConfigRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BootServer).WatchConfig(m, &bootWatchConfigServer{stream})
+}
+
+type Boot_WatchConfigServer interface {
+	Send(*ConfigUpdate) error
+	grpc.ServerStream
+}
+
+type bootWatchConfigServer struct {
+	grpc.ServerStream
+}
+
+func (x *bootWatchConfigServer) Send(m *ConfigUpdate) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Boot_ServiceDesc is the grpc.ServiceDesc for Boot service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Boot_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "spriteful.v1.Boot",
+	HandlerType: (*BootServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Boot",
+			Handler:    _Boot_Boot_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchConfig",
+			Handler:       _Boot_WatchConfig_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "boot.proto",
+}
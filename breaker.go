@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// These are the states of a circuit breaker.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// breaker is a circuit breaker that opens after threshold consecutive
+// failures, rejects calls for the cooldown, then lets a single call through
+// to test recovery. A nil breaker allows every call.
+type breaker struct {
+	mu        sync.Mutex
+	name      string
+	threshold int
+	cooldown  time.Duration
+	clock     func() time.Time
+	state     string
+	failures  int
+	opened    time.Time
+}
+
+// Creates a closed breaker, or nil if threshold isn't positive.
+func newBreaker(name string, threshold int, cooldown time.Duration) *breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &breaker{name: name, threshold: threshold, cooldown: cooldown, clock: time.Now, state: BreakerClosed}
+}
+
+// Reports whether a call may be made. Once the cooldown has passed, an open
+// breaker half-opens and allows one call; further calls are rejected until
+// that call succeeds or fails.
+func (b *breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if b.clock().Sub(b.opened) < b.cooldown {
+			return false
+		}
+		b.transition(BreakerHalfOpen)
+		return true
+	case BreakerHalfOpen:
+		return false
+	}
+	return true
+}
+
+// Records a successful call, closing the breaker.
+func (b *breaker) success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	if b.state != BreakerClosed {
+		b.transition(BreakerClosed)
+	}
+}
+
+// Records a failed call, opening the breaker after threshold consecutive
+// failures or when the recovery call fails.
+func (b *breaker) failure() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		b.opened = b.clock()
+		b.transition(BreakerOpen)
+	}
+}
+
+// Moves the breaker to the state, logging the transition. Must be called
+// with the lock held.
+func (b *breaker) transition(state string) {
+	logrus.WithFields(logrus.Fields{
+		"breaker":  b.name,
+		"from":     b.state,
+		"to":       state,
+		"failures": b.failures,
+	}).Warnf(`%s circuit breaker is %s.`, b.name, state)
+	b.state = state
+}
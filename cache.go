@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+// cacheDownloadTimeout bounds a single download or re-validation of an
+// asset, so a stalled origin can't hold a warmer worker forever.
+const cacheDownloadTimeout = 10 * time.Minute
+
+type (
+	// assetCache keeps local copies of remote kernels and initrds so boots
+	// don't wait on the origin.
+	assetCache struct {
+		dir     string
+		ttl     time.Duration
+		client  *http.Client
+		mu      sync.Mutex
+		entries map[string]*cacheEntry
+	}
+
+	// cacheEntry is the metadata stored next to a cached asset, used to
+	// re-validate it against the origin.
+	cacheEntry struct {
+		URL          string `json:"url"`
+		ETag         string `json:"etag,omitempty"`
+		LastModified string `json:"last-modified,omitempty"`
+
+		mu      sync.Mutex
+		ready   bool
+		checked time.Time
+	}
+)
+
+// Creates the asset cache rooted at dir, picking up previously cached assets.
+// Cached assets are re-validated against the origin once they are older than
+// ttl.
+func newAssetCache(dir string, ttl time.Duration) (*assetCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &assetCache{
+		dir:     dir,
+		ttl:     ttl,
+		client:  &http.Client{Timeout: cacheDownloadTimeout},
+		entries: make(map[string]*cacheEntry),
+	}
+	metas, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	for _, meta := range metas {
+		data, err := ioutil.ReadFile(meta)
+		if err != nil {
+			continue
+		}
+		entry := &cacheEntry{}
+		if err := json.Unmarshal(data, entry); err != nil || entry.URL == "" {
+			continue
+		}
+		key := cacheKey(entry.URL)
+		if _, err := os.Stat(c.dataPath(key)); err == nil {
+			entry.ready = true
+		}
+		c.entries[key] = entry
+	}
+	return c, nil
+}
+
+// Returns the cache key for an asset URL.
+func cacheKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Returns the path of the cached asset data.
+func (c *assetCache) dataPath(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// Returns the entry for the URL, creating it if needed.
+func (c *assetCache) entry(url string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey(url)
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &cacheEntry{URL: url}
+		c.entries[key] = entry
+	}
+	return entry
+}
+
+// Fetches distinct asset URLs into the cache using at most workers
+// concurrent downloads. Failures are logged and skipped.
+func (c *assetCache) warm(urls []string, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	logrus.Infof("Warming asset cache with %d assets...", len(urls))
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for url := range jobs {
+				if err := c.refresh(context.Background(), c.entry(url)); err != nil {
+					logrus.WithField(logrus.ErrorKey, err).Warnf(`unable to cache "%s".`, url)
+				}
+			}
+		}()
+	}
+	for _, url := range urls {
+		jobs <- url
+	}
+	close(jobs)
+	wg.Wait()
+	logrus.Info("Asset cache warmed.")
+}
+
+// Downloads the asset, or re-validates the cached copy via ETag and
+// Last-Modified when one exists, giving up once the context is done. The
+// download goes to a temporary file without holding the entry's lock, which
+// is only taken to swap the file and metadata in, so boots rewriting their
+// URLs never wait on the origin.
+func (c *assetCache) refresh(ctx context.Context, entry *cacheEntry) error {
+	req, err := http.NewRequest(http.MethodGet, entry.URL, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	entry.mu.Lock()
+	ready := entry.ready
+	if ready {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+	entry.mu.Unlock()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && ready:
+		entry.mu.Lock()
+		entry.checked = time.Now()
+		entry.mu.Unlock()
+		return nil
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	key := cacheKey(entry.URL)
+	tmp, err := ioutil.TempFile(c.dir, key+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if err := os.Rename(tmp.Name(), c.dataPath(key)); err != nil {
+		return err
+	}
+	entry.ETag = resp.Header.Get("ETag")
+	entry.LastModified = resp.Header.Get("Last-Modified")
+	entry.ready = true
+	entry.checked = time.Now()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	logrus.Infof(`cached "%s".`, entry.URL)
+	return ioutil.WriteFile(c.dataPath(key)+".json", data, 0644)
+}
+
+// Re-validates the asset unless it was checked against the origin within
+// the ttl.
+func (c *assetCache) revalidate(ctx context.Context, entry *cacheEntry) error {
+	entry.mu.Lock()
+	fresh := entry.ready && time.Since(entry.checked) < c.ttl
+	entry.mu.Unlock()
+	if fresh {
+		return nil
+	}
+	return c.refresh(ctx, entry)
+}
+
+// Returns the URL clients should use for the asset, pointing at the cache
+// route on the scheme and host the client used when the asset is cached.
+func (c *assetCache) rewrite(url string, req *restful.Request) string {
+	c.mu.Lock()
+	entry, ok := c.entries[cacheKey(url)]
+	c.mu.Unlock()
+	if !ok {
+		return url
+	}
+	entry.mu.Lock()
+	ready := entry.ready
+	entry.mu.Unlock()
+	if !ready {
+		return url
+	}
+	return fmt.Sprintf("%s://%s/api/v1/cache/%s", requestScheme(req.Request), req.Request.Host, cacheKey(url))
+}
+
+// Returns the scheme the request was made over.
+func requestScheme(req *http.Request) string {
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Returns the distinct remote kernel and initrd URLs referenced by the
+// servers. Assets under the local static route are skipped.
+func remoteAssets(servers []Server) []string {
+	seen := make(map[string]bool)
+	var urls []string
+	add := func(url string) {
+		if seen[url] || strings.Contains(url, "/api/v1/static/") || isTemplate(url) {
+			return
+		}
+		if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	for _, server := range servers {
+		add(server.Kernel)
+		for _, initrd := range server.Initrd {
+			add(initrd.URL)
+		}
+	}
+	return urls
+}
+
+// Handles the http request for a cached asset.
+func (s *Spriteful) handleCacheRequest(req *restful.Request, res *restful.Response) {
+	key := req.PathParameter("key")
+	if s.cache == nil {
+		res.WriteErrorString(http.StatusNotFound, "asset cache is disabled.")
+		return
+	}
+	s.cache.mu.Lock()
+	entry, ok := s.cache.entries[key]
+	s.cache.mu.Unlock()
+	if !ok {
+		res.WriteErrorString(http.StatusNotFound, fmt.Sprintf("no cached asset %s.", key))
+		return
+	}
+	if err := s.cache.revalidate(req.Request.Context(), entry); err != nil {
+		logrus.WithField(logrus.ErrorKey, err).Warnf(`unable to re-validate "%s", serving cached copy.`, entry.URL)
+	}
+	entry.mu.Lock()
+	ready := entry.ready
+	entry.mu.Unlock()
+	if !ready {
+		res.WriteErrorString(http.StatusBadGateway, fmt.Sprintf("asset %s is not cached.", key))
+		return
+	}
+	http.ServeFile(res.ResponseWriter, req.Request, s.cache.dataPath(key))
+}
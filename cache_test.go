@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"crypto/tls"
+	"io/ioutil"
+	"net/http/httptest"
+
+	"github.com/emicklei/go-restful"
+)
+
+func TestAssetCacheWarm(t *testing.T) {
+	fetches, notModified := 0, 0
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("kernel"))
+	}))
+	defer origin.Close()
+
+	dir, err := ioutil.TempDir("", "spriteful-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache, err := newAssetCache(dir, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kernel := origin.URL + "/vmlinuz"
+	urls := remoteAssets([]Server{
+		{Kernel: kernel, Initrd: []Initrd{{URL: kernel}, {URL: "http://localhost/api/v1/static/initrd"}}},
+	})
+	if len(urls) != 1 {
+		t.Fatalf("only one distinct remote asset is expected, assets: %v", urls)
+	}
+	cache.warm(urls, 2)
+	if data, err := ioutil.ReadFile(cache.dataPath(cacheKey(kernel))); err != nil || string(data) != "kernel" {
+		t.Errorf("%s should be cached, but it's not", kernel)
+	}
+
+	req := restful.NewRequest(httptest.NewRequest(http.MethodGet, "http://spriteful:5000/api/v1/boot/x", nil))
+	if rewritten := cache.rewrite(kernel, req); !strings.HasPrefix(rewritten, "http://spriteful:5000/api/v1/cache/") {
+		t.Errorf("%s should be served from the cache, got %s", kernel, rewritten)
+	}
+	if other := origin.URL + "/other"; cache.rewrite(other, req) != other {
+		t.Errorf("uncached assets should not be rewritten")
+	}
+	req.Request.TLS = &tls.ConnectionState{}
+	if rewritten := cache.rewrite(kernel, req); !strings.HasPrefix(rewritten, "https://spriteful:5000/api/v1/cache/") {
+		t.Errorf("clients on TLS should be sent to the cache over https, got %s", rewritten)
+	}
+	before := fetches
+	if err := cache.revalidate(context.Background(), cache.entry(kernel)); err != nil || fetches != before {
+		t.Errorf("assets checked within the ttl should not be re-validated, fetches: %d", fetches-before)
+	}
+
+	reloaded, err := newAssetCache(dir, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.refresh(context.Background(), reloaded.entry(kernel)); err != nil {
+		t.Errorf("re-validation should succeed: %v", err)
+	}
+	if notModified != 1 {
+		t.Errorf("cached asset should be re-validated with its ETag, fetches: %d", fetches)
+	}
+}
+
+func TestAssetCacheStalledOrigin(t *testing.T) {
+	stall := make(chan struct{})
+	stalled := make(chan struct{}, 1)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" {
+			stalled <- struct{}{}
+			select {
+			case <-stall:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("kernel"))
+	}))
+	defer origin.Close()
+	defer close(stall)
+
+	dir, err := ioutil.TempDir("", "spriteful-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache, err := newAssetCache(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kernel := origin.URL + "/vmlinuz"
+	cache.warm([]string{kernel}, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	revalidated := make(chan error, 1)
+	go func() {
+		revalidated <- cache.revalidate(ctx, cache.entry(kernel))
+	}()
+	<-stalled
+	rewritten := make(chan string, 1)
+	go func() {
+		rewritten <- cache.rewrite(kernel, restful.NewRequest(httptest.NewRequest(http.MethodGet, "http://spriteful:5000/api/v1/boot/x", nil)))
+	}()
+	select {
+	case url := <-rewritten:
+		if !strings.HasPrefix(url, "http://spriteful:5000/api/v1/cache/") {
+			t.Errorf("the cached copy should still be served while re-validating, got %s", url)
+		}
+	case <-time.After(time.Second):
+		t.Error("rewriting should not wait on a stalled origin")
+	}
+	cancel()
+	select {
+	case err := <-revalidated:
+		if err == nil {
+			t.Error("a cancelled re-validation should fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("re-validation should give up once its context is done")
+	}
+}
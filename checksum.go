@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"encoding/hex"
+
+	"github.com/emicklei/go-restful"
+)
+
+// ExtendedContentType is the content type of extended boot responses, which
+// add asset checksums to the pixiecore response.
+const ExtendedContentType = "application/vnd.spriteful.boot+json"
+
+// ExtendedResponse is the pixiecore response with the SHA-256 checksums of
+// its kernel and initrds, for boot agents verifying what they download.
+type ExtendedResponse struct {
+	PixieResponse
+	KernelSHA256 string   `json:"kernel-sha256,omitempty"`
+	InitrdSHA256 []string `json:"initrd-sha256,omitempty"`
+}
+
+// Reports whether the request asks for an extended response with its
+// Accept header.
+func wantsExtended(req *restful.Request) bool {
+	return strings.Contains(req.HeaderParameter("Accept"), ExtendedContentType)
+}
+
+// Encodes the extended boot response, with the server's checksums, like
+// encodeResponse.
+func encodeExtendedResponse(response *PixieResponse, server *Server, raw bool) (string, error) {
+	if !raw {
+		var err error
+		if response, err = unescapeResponse(response); err != nil {
+			return "", err
+		}
+	}
+	return encodeJSON(&ExtendedResponse{
+		PixieResponse: *response,
+		KernelSHA256:  server.KernelSHA256,
+		InitrdSHA256:  server.InitrdSHA256,
+	})
+}
+
+// Returns an error unless the server's checksums are hex SHA-256 digests
+// and it has at most one initrd checksum per initrd.
+func (s *Server) checkChecksums() error {
+	if len(s.InitrdSHA256) > len(s.Initrd) {
+		return fmt.Errorf("%d initrd checksums for %d initrds", len(s.InitrdSHA256), len(s.Initrd))
+	}
+	for _, sum := range append([]string{s.KernelSHA256}, s.InitrdSHA256...) {
+		if sum == "" {
+			continue
+		}
+		if decoded, err := hex.DecodeString(sum); err != nil || len(decoded) != 32 {
+			return fmt.Errorf("checksum %q isn't a hex sha256 digest", sum)
+		}
+	}
+	return nil
+}
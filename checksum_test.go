@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"encoding/json"
+	"net/http"
+)
+
+func TestExtendedResponse(t *testing.T) {
+	kernelSum := strings.Repeat("ab", 32)
+	initrdSum := strings.Repeat("cd", 32)
+	s := &Spriteful{Servers: []Server{{
+		MacAddress:   validMac,
+		Kernel:       "http://images/vmlinuz",
+		Initrd:       []Initrd{{URL: "http://images/initrd"}},
+		KernelSHA256: kernelSum,
+		InitrdSHA256: []string{initrdSum},
+	}}}
+	accept := http.Header{"Accept": {ExtendedContentType}}
+	res := serve(s, "GET", "/api/v1/boot/"+validMac, accept)
+	var extended ExtendedResponse
+	json.Unmarshal(res.Body.Bytes(), &extended)
+	if res.Code != http.StatusOK || res.Header().Get("Content-Type") != ExtendedContentType {
+		t.Fatalf("extended responses should be negotiated with Accept, status: %d content type: %s", res.Code, res.Header().Get("Content-Type"))
+	}
+	if extended.Kernel != "http://images/vmlinuz" || extended.KernelSHA256 != kernelSum || len(extended.InitrdSHA256) != 1 || extended.InitrdSHA256[0] != initrdSum {
+		t.Errorf("the extended response should carry the checksums, got %+v", extended)
+	}
+
+	res = serve(s, "GET", "/api/v1/boot/"+validMac, nil)
+	if strings.Contains(res.Body.String(), "sha256") {
+		t.Errorf("the pixiecore response should stay unchanged, got %s", res.Body)
+	}
+
+	s.Servers[0].Windows = []WindowedEntry{{BootEntry: BootEntry{Kernel: "http://images/maintenance.vmlinuz"}, ActiveFrom: WindowTime{time.Unix(0, 0)}}}
+	extended = ExtendedResponse{}
+	json.Unmarshal(serve(s, "GET", "/api/v1/boot/"+validMac, accept).Body.Bytes(), &extended)
+	if extended.Kernel != "http://images/maintenance.vmlinuz" || extended.KernelSHA256 != "" || len(extended.InitrdSHA256) != 1 {
+		t.Errorf("a replaced kernel should drop only its checksum, got %+v", extended)
+	}
+}
+
+func TestChecksumValidation(t *testing.T) {
+	server := &Server{MacAddress: validMac, Kernel: "vmlinuz", KernelSHA256: "not-hex"}
+	if err := server.validate(""); err == nil {
+		t.Error("malformed checksums should be rejected")
+	}
+	server = &Server{MacAddress: validMac, Kernel: "vmlinuz", InitrdSHA256: []string{strings.Repeat("00", 32)}}
+	if err := server.validate(""); err == nil {
+		t.Error("more initrd checksums than initrds should be rejected")
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Cmdline is a kernel command line. In the config it is either a plain
+// string or an object of key/value pairs rendered as "key=value" tokens in
+// key order. A true or null value renders a bare "key" and false omits it.
+// Values containing whitespace are double quoted unless they already are;
+// the kernel has no escape for a double quote inside a value, so values
+// with any other double quote are rejected.
+type Cmdline string
+
+// UnmarshalJSON accepts both the string and the object form.
+func (c *Cmdline) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return fmt.Errorf("cmdline must be a string or an object: %v", err)
+		}
+		*c = Cmdline(str)
+		return nil
+	}
+
+	var args map[string]interface{}
+	if err := json.Unmarshal(data, &args); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var tokens []string
+	for _, key := range keys {
+		switch value := args[key].(type) {
+		case nil:
+			tokens = append(tokens, key)
+		case bool:
+			if value {
+				tokens = append(tokens, key)
+			}
+		case string:
+			quoted, err := quoteArg(value)
+			if err != nil {
+				return fmt.Errorf("cmdline value for %s %v", key, err)
+			}
+			tokens = append(tokens, key+"="+quoted)
+		case float64:
+			tokens = append(tokens, fmt.Sprintf("%s=%v", key, value))
+		default:
+			return fmt.Errorf("cmdline value for %s must be a string, number or boolean", key)
+		}
+	}
+	*c = Cmdline(strings.Join(tokens, " "))
+	return nil
+}
+
+// Returns the value double quoted if the kernel would otherwise split it,
+// and an error when it holds double quotes other than a pair around the
+// whole value.
+func quoteArg(value string) (string, error) {
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) && strings.Count(value, `"`) == 2 {
+		return value, nil
+	}
+	if strings.Contains(value, `"`) {
+		return "", errors.New("can't contain double quotes except around the whole value")
+	}
+	if !strings.ContainsAny(value, " \t\n") {
+		return value, nil
+	}
+	return `"` + value + `"`, nil
+}
+
+// Returns the cmdline split into tokens on whitespace outside double quotes
+// and template actions.
+func (c Cmdline) tokens() []string {
+	var tokens []string
+	var token strings.Builder
+	quoted := false
+	actions := 0
+	var prev rune
+	for _, r := range string(c) {
+		switch {
+		case r == '{' && prev == '{':
+			actions++
+		case r == '}' && prev == '}' && actions > 0:
+			actions--
+		}
+		prev = r
+		switch {
+		case r == '"':
+			quoted = !quoted
+			token.WriteRune(r)
+		case (r == ' ' || r == '\t' || r == '\n') && !quoted && actions == 0:
+			if token.Len() > 0 {
+				tokens = append(tokens, token.String())
+				token.Reset()
+			}
+		default:
+			token.WriteRune(r)
+		}
+	}
+	if token.Len() > 0 {
+		tokens = append(tokens, token.String())
+	}
+	return tokens
+}
+
+// Returns the key of a cmdline token, the part before any "=".
+func tokenKey(token string) string {
+	if i := strings.Index(token, "="); i >= 0 {
+		return token[:i]
+	}
+	return token
+}
+
+// Returns the base cmdline with the override's tokens applied: base tokens
+// whose key the override also sets are dropped and the override's tokens
+// follow the remaining base tokens.
+func (c Cmdline) merge(override Cmdline) Cmdline {
+	if c == "" {
+		return override
+	}
+	if override == "" {
+		return c
+	}
+	overridden := make(map[string]bool)
+	overrides := override.tokens()
+	for _, token := range overrides {
+		overridden[tokenKey(token)] = true
+	}
+	var tokens []string
+	for _, token := range c.tokens() {
+		if !overridden[tokenKey(token)] {
+			tokens = append(tokens, token)
+		}
+	}
+	return Cmdline(strings.Join(append(tokens, overrides...), " "))
+}
+
+// Logs a warning for every server whose resolved cmdline is longer than max
+// bytes, with no arch and with each configured arch, and returns an error
+// naming the first one. A max that isn't positive disables the check.
+func (s *Spriteful) checkCmdlineLengths(max int) error {
+	long := s.longCmdlines(max)
+	for _, err := range long {
+		logrus.WithField(logrus.ErrorKey, err).Warn("cmdline is longer than bootloaders may keep.")
+	}
+	if len(long) == 0 {
+		return nil
+	}
+	return long[0]
+}
+
+// Returns an error for every server and arch whose resolved cmdline is
+// longer than max bytes, none when max isn't positive.
+func (s *Spriteful) longCmdlines(max int) []error {
+	if max <= 0 {
+		return nil
+	}
+	var long []error
+	for i := range s.Servers {
+		for _, arch := range s.cmdlineArches() {
+			server := s.resolveFor(arch, "", &s.Servers[i])
+			if length := len(server.CommandLine); length > max {
+				long = append(long, fmt.Errorf(`cmdline of "%s" (arch "%s") is %d bytes, over the maximum of %d`, server.MacAddress, arch, length, max))
+			}
+		}
+	}
+	return long
+}
+
+// Returns the arches cmdlines are checked with: no arch, then every
+// configured arch in order.
+func (s *Spriteful) cmdlineArches() []string {
+	arches := []string{""}
+	for arch := range s.ArchDefaults {
+		arches = append(arches, arch)
+	}
+	sort.Strings(arches)
+	return arches
+}
+
+// These are the levels of cmdline syntax checking. Warn logs malformed
+// cmdlines and strict also fails loading configs with them.
+const (
+	CmdlineSyntaxOff    = "off"
+	CmdlineSyntaxWarn   = "warn"
+	CmdlineSyntaxStrict = "strict"
+)
+
+// templateAction matches template actions, skipped by syntax checks until
+// they are rendered.
+var templateAction = regexp.MustCompile(`{{.*?}}`)
+
+// Returns an error unless level is a known cmdline syntax level.
+func validCmdlineSyntax(level string) error {
+	switch level {
+	case "", CmdlineSyntaxOff, CmdlineSyntaxWarn, CmdlineSyntaxStrict:
+		return nil
+	}
+	return fmt.Errorf("unknown cmdline syntax level %s.", level)
+}
+
+// Returns an error for the first syntax error of the cmdline: unbalanced
+// double quotes, a trailing backslash, or a token without a key or with
+// quotes or whitespace in its key.
+func (c Cmdline) checkSyntax() error {
+	cmdline := templateAction.ReplaceAllString(string(c), "x")
+	if strings.Count(cmdline, `"`)%2 != 0 {
+		return errors.New("unbalanced double quotes")
+	}
+	if strings.HasSuffix(strings.TrimRight(cmdline, " \t\n"), `\`) {
+		return errors.New("trailing backslash")
+	}
+	for _, token := range Cmdline(cmdline).tokens() {
+		key := strings.TrimPrefix(tokenKey(token), `"`)
+		if key == "" {
+			return fmt.Errorf("token %s has no key", token)
+		}
+		if strings.ContainsAny(key, "\" \t\n") {
+			return fmt.Errorf("token %s has a malformed key", token)
+		}
+	}
+	return nil
+}
+
+// Returns an error for every server and arch whose resolved cmdline is
+// malformed, see checkSyntax.
+func (s *Spriteful) malformedCmdlines() []error {
+	var malformed []error
+	for i := range s.Servers {
+		for _, arch := range s.cmdlineArches() {
+			server := s.resolveFor(arch, "", &s.Servers[i])
+			if err := server.CommandLine.checkSyntax(); err != nil {
+				malformed = append(malformed, fmt.Errorf(`cmdline of "%s" (arch "%s") is malformed: %v`, server.MacAddress, arch, err))
+			}
+		}
+	}
+	return malformed
+}
+
+// Logs a warning for every malformed cmdline unless level is off, and with
+// the strict level returns an error naming the first one.
+func (s *Spriteful) checkCmdlineSyntax(level string) error {
+	if level == "" || level == CmdlineSyntaxOff {
+		return nil
+	}
+	malformed := s.malformedCmdlines()
+	for _, err := range malformed {
+		logrus.WithField(logrus.ErrorKey, err).Warn("cmdline is malformed.")
+	}
+	if len(malformed) == 0 || level != CmdlineSyntaxStrict {
+		return nil
+	}
+	return malformed[0]
+}
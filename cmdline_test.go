@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"encoding/json"
+)
+
+func TestCmdlineUnmarshal(t *testing.T) {
+	cases := map[string]Cmdline{
+		`"console=ttyS0 quiet"`: "console=ttyS0 quiet",
+		`{"quiet": true, "console": "ttyS0", "debug": false, "coreos.autologin": null, "mem": 512}`: "console=ttyS0 coreos.autologin mem=512 quiet",
+		`{}`: "",
+		`{"custom": "a b c", "quoted": "\"x y\"", "root": "LABEL=root"}`: `custom="a b c" quoted="x y" root=LABEL=root`,
+		`{"bare": "\"x\"", "tab": "a\tb"}`:                               "bare=\"x\" tab=\"a\tb\"",
+	}
+	for data, want := range cases {
+		var cmdline Cmdline
+		if err := json.Unmarshal([]byte(data), &cmdline); err != nil {
+			t.Errorf("%s should unmarshal, but it didn't: %v", data, err)
+			continue
+		}
+		if cmdline != want {
+			t.Errorf("%s should render %q, got %q", data, want, cmdline)
+		}
+	}
+	for _, data := range []string{`1`, `["a"]`, `{"a": ["b"]}`, `{"a": "say \"hi\" now"}`, `{"a": "x\"y"}`, `{"a": "\"x\" \"y\""}`} {
+		var cmdline Cmdline
+		if err := json.Unmarshal([]byte(data), &cmdline); err == nil {
+			t.Errorf("%s should not unmarshal, but it did", data)
+		}
+	}
+}
+
+func TestCmdlineMerge(t *testing.T) {
+	cases := []struct {
+		base, override, want Cmdline
+	}{
+		{"", "quiet", "quiet"},
+		{"console=ttyS0", "", "console=ttyS0"},
+		{"console=ttyS0 earlyprintk", "console=tty1 quiet", "earlyprintk console=tty1 quiet"},
+		{`custom="a b" x=1`, `custom="c d"`, `x=1 custom="c d"`},
+	}
+	for _, c := range cases {
+		if got := c.base.merge(c.override); got != c.want {
+			t.Errorf("%q merged with %q should be %q, got %q", c.base, c.override, c.want, got)
+		}
+	}
+}
+
+func TestCmdlineLength(t *testing.T) {
+	s := &Spriteful{
+		ArchDefaults: map[string]BootEntry{"arm64": {CommandLine: "console=ttyAMA0 earlyprintk"}},
+		Servers: []Server{
+			{MacAddress: validMac, Kernel: "vmlinuz", CommandLine: "quiet"},
+			{MacAddress: invalidMac, Kernel: "vmlinuz", CommandLine: "root=/dev/sda1 quiet"},
+		},
+	}
+	if err := s.checkCmdlineLengths(0); err != nil {
+		t.Errorf("a zero maximum should disable the check, got %v", err)
+	}
+	if err := s.checkCmdlineLengths(48); err != nil {
+		t.Errorf("cmdlines within the maximum should pass, got %v", err)
+	}
+	if err := s.checkCmdlineLengths(40); err == nil || !strings.Contains(err.Error(), invalidMac) {
+		t.Errorf("the merged arm64 cmdline of %s should be over the maximum, got %v", invalidMac, err)
+	}
+}
+
+func TestCmdlineSyntax(t *testing.T) {
+	cases := map[Cmdline]bool{
+		`console=ttyS0 quiet`:                  true,
+		`custom="a b" -- init=/bin/sh`:         true,
+		`"quoted=value" ip={{ .IP }}`:          true,
+		`hostname={{ if .Hostname }}{{ end }}`: true,
+		`custom="a b`:                          false,
+		`console=ttyS0 \`:                      false,
+		`=value quiet`:                         false,
+		`"a b"=c`:                              false,
+	}
+	for cmdline, valid := range cases {
+		if err := cmdline.checkSyntax(); (err == nil) != valid {
+			t.Errorf("syntax check of %q should pass: %v, got %v", cmdline, valid, err)
+		}
+	}
+
+	s := &Spriteful{
+		ArchDefaults: map[string]BootEntry{"arm64": {CommandLine: `console="ttyAMA0`}},
+		Servers:      []Server{{MacAddress: validMac, Kernel: "vmlinuz", CommandLine: "quiet"}},
+	}
+	if err := s.checkCmdlineSyntax(CmdlineSyntaxOff); err != nil {
+		t.Errorf("the off level should skip the check, got %v", err)
+	}
+	if err := s.checkCmdlineSyntax(CmdlineSyntaxWarn); err != nil {
+		t.Errorf("the warn level should only warn, got %v", err)
+	}
+	if err := s.checkCmdlineSyntax(CmdlineSyntaxStrict); err == nil || !strings.Contains(err.Error(), `arch "arm64"`) {
+		t.Errorf("the merged arm64 cmdline should fail the strict level, got %v", err)
+	}
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+// defaultConfig is served with -allow-embedded-default when the config file
+// doesn't exist: no servers, listening on every interface.
+//
+//go:embed config.default.json
+var defaultConfig []byte
+
+// Opens the config at path, falling back to the embedded default config
+// when allowed and the file doesn't exist.
+func openConfigOrDefault(path string, retries int, interval time.Duration, allowDefault bool) (io.ReadCloser, error) {
+	file, err := openConfig(path, retries, interval)
+	if err != nil && allowDefault && os.IsNotExist(err) {
+		logrus.Warnf(`!!! config "%s" not found, serving the embedded default config without any servers. !!!`, path)
+		return ioutil.NopCloser(bytes.NewReader(defaultConfig)), nil
+	}
+	return file, err
+}
+
+// Opens the config at path, a local file or an http(s) URL. Local files fail
+// fast. Remote configs are retried up to retries more times, waiting interval
+// before the first retry and doubling the wait after each one.
+func openConfig(path string, retries int, interval time.Duration) (io.ReadCloser, error) {
+	if !isRemoteConfig(path) {
+		return os.Open(path)
+	}
+	for attempt := 0; ; attempt++ {
+		body, err := fetchConfig(path)
+		if err == nil || attempt >= retries {
+			return body, err
+		}
+		logrus.WithField(logrus.ErrorKey, err).Warnf("unable to fetch config, retrying in %s (%d/%d).", interval, attempt+1, retries)
+		time.Sleep(interval)
+		interval *= 2
+	}
+}
+
+// limitedConfig fails reads once more than max bytes of the config at path
+// have been read.
+type limitedConfig struct {
+	io.ReadCloser
+	path string
+	max  int64
+	read int64
+}
+
+// Returns the config limited to max bytes, or as is if max isn't positive.
+func limitConfig(config io.ReadCloser, path string, max int64) io.ReadCloser {
+	if max <= 0 {
+		return config
+	}
+	return &limitedConfig{ReadCloser: config, path: path, max: max}
+}
+
+// Read fails once the config exceeds its maximum size.
+func (l *limitedConfig) Read(p []byte) (int, error) {
+	n, err := l.ReadCloser.Read(p)
+	l.read += int64(n)
+	if l.read > l.max {
+		return n, fmt.Errorf("config %s is larger than the maximum of %d bytes", l.path, l.max)
+	}
+	return n, err
+}
+
+// Reports whether the config path is an http(s) URL.
+func isRemoteConfig(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// configClient fetches remote configs. Its timeout covers reading the body,
+// so a stalled config server fails the attempt instead of hanging startup
+// or a reload.
+var configClient = &http.Client{Timeout: 30 * time.Second}
+
+// Fetches a remote config, failing on any status but 200.
+func fetchConfig(url string) (io.ReadCloser, error) {
+	resp, err := configClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// These are the config formats. Auto detects the format from the content.
+const (
+	FormatAuto = "auto"
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// Returns an error unless format is a known config format.
+func validConfigFormat(format string) error {
+	switch format {
+	case "", FormatAuto, FormatJSON, FormatYAML:
+		return nil
+	}
+	return fmt.Errorf("unknown config format %q", format)
+}
+
+// Reads a config in the format from r. Auto treats content whose first
+// non-whitespace character is `{` as JSON and anything else as YAML, so the
+// file extension never matters. JSON is streamed by decodeConfig; YAML is
+// converted to JSON first and decoded the same way, strict mode included,
+// keeping the fingerprint of the YAML itself.
+func readConfig(r io.Reader, format string, strict bool) (*Spriteful, error) {
+	buffered := bufio.NewReader(r)
+	if format == "" || format == FormatAuto {
+		format = sniffConfigFormat(buffered)
+	}
+	if format == FormatJSON {
+		return decodeConfig(buffered, strict)
+	}
+	data, err := ioutil.ReadAll(buffered)
+	if err != nil {
+		return nil, err
+	}
+	converted, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	sprite, err := decodeConfig(bytes.NewReader(converted), strict)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.New()
+	digest.Write(data)
+	sprite.configHash = configHash(digest)
+	return sprite, nil
+}
+
+// Returns the format of the buffered config, peeking at its first
+// non-whitespace character.
+func sniffConfigFormat(r *bufio.Reader) string {
+	for n := 1; ; n++ {
+		peeked, err := r.Peek(n)
+		if err != nil {
+			return FormatYAML
+		}
+		switch peeked[n-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '{':
+			return FormatJSON
+		}
+		return FormatYAML
+	}
+}
+
+// ConfigHashHeader carries the fingerprint of the config that served a boot
+// response.
+const ConfigHashHeader = "X-Spriteful-Config-Hash"
+
+// Decodes a config from r. The servers array is decoded one entry at a time
+// and indexed as it goes, so large configs are never held in memory twice.
+// When strict is set, unknown fields fail the decode instead of being
+// ignored. DisallowUnknownFields still matches keys case-insensitively, so
+// checkFields also rejects keys that only differ in case. The config's
+// fingerprint is computed from the bytes read along the way.
+func decodeConfig(r io.Reader, strict bool) (*Spriteful, error) {
+	digest := sha256.New()
+	r = io.TeeReader(r, digest)
+	dec := json.NewDecoder(r)
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	sprite := &Spriteful{serials: make(map[string]int)}
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := token.(string)
+		if strings.EqualFold(key, "servers") && (!strict || key == "servers") {
+			if err := sprite.decodeServers(dec, strict); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		field, err := json.Marshal(map[string]json.RawMessage{key: value})
+		if err != nil {
+			return nil, err
+		}
+		if strict {
+			if err := checkFields(field, reflect.TypeOf(sprite).Elem()); err != nil {
+				return nil, err
+			}
+		}
+		if err := json.Unmarshal(field, sprite); err != nil {
+			return nil, err
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return nil, err
+	}
+	if sprite.BaseURL != "" {
+		if _, err := parseBaseURL(sprite.BaseURL); err != nil {
+			return nil, fmt.Errorf("base-url: %v", err)
+		}
+	}
+	if sprite.usesRawCmdline() {
+		logrus.Warn(`"raw-cmdline" is deprecated, it only skips URL-unescaping the kernel and initrd URLs: use "raw-urls" instead.`)
+	}
+	sprite.configHash = configHash(digest)
+	return sprite, nil
+}
+
+// Reports whether the config or any of its servers sets the deprecated
+// raw-cmdline.
+func (s *Spriteful) usesRawCmdline() bool {
+	if s.RawCmdline {
+		return true
+	}
+	for _, server := range s.Servers {
+		if server.RawCmdline {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the short fingerprint of a config from the digest of its bytes:
+// the first 12 hex digits of its SHA-256.
+func configHash(digest hash.Hash) string {
+	return hex.EncodeToString(digest.Sum(nil))[:12]
+}
+
+// Decodes the servers array element by element, indexing each server.
+func (s *Spriteful) decodeServers(dec *json.Decoder, strict bool) error {
+	s.Servers = nil
+	s.serials = make(map[string]int)
+	s.vlans = make(map[string]int)
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("servers must be an array, got %v", token)
+	}
+	for dec.More() {
+		var server Server
+		if err := decodeServer(dec, &server, strict); err != nil {
+			return fmt.Errorf("servers[%d]: %v", len(s.Servers), err)
+		}
+		s.Servers = append(s.Servers, server)
+		s.indexServer(len(s.Servers) - 1)
+	}
+	return expectDelim(dec, ']')
+}
+
+// Decodes the next server. In strict mode its raw JSON is checked with
+// checkFields first.
+func decodeServer(dec *json.Decoder, server *Server, strict bool) error {
+	if !strict {
+		return dec.Decode(server)
+	}
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	if err := checkFields(raw, reflect.TypeOf(server).Elem()); err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, server)
+}
+
+// Fails if a JSON object in data, at any depth, has a key that isn't exactly
+// the json name of a field of the struct type it decodes into. Values that
+// aren't objects, or decode into non-struct types, are left to the decoder.
+func checkFields(data []byte, t reflect.Type) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		var items []json.RawMessage
+		if json.Unmarshal(data, &items) != nil {
+			return nil
+		}
+		for _, item := range items {
+			if err := checkFields(item, t.Elem()); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		var values map[string]json.RawMessage
+		if json.Unmarshal(data, &values) != nil {
+			return nil
+		}
+		for _, value := range values {
+			if err := checkFields(value, t.Elem()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		var object map[string]json.RawMessage
+		if json.Unmarshal(data, &object) != nil {
+			return nil
+		}
+		fields := jsonFields(t)
+		for key, value := range object {
+			field, ok := fields[key]
+			if !ok {
+				return fmt.Errorf("unknown field %q", key)
+			}
+			if err := checkFields(value, field); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the types of the struct's fields by json name, including the
+// fields of embedded structs.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			for name, typ := range jsonFields(field.Type) {
+				fields[name] = typ
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// Reads the next token and fails unless it is the expected delimiter.
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != want {
+		return fmt.Errorf("expected %v, got %v", want, token)
+	}
+	return nil
+}
+
+// Opens and reads the config file at path, see openConfig and readConfig.
+func readConfigFile(path string, retries int, interval time.Duration, maxSize int64, format string, strict bool) (*Spriteful, error) {
+	file, err := openConfig(path, retries, interval)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readConfig(limitConfig(file, path, maxSize), format, strict)
+}
+
+// Returns the main config merged over the base config. Settings set in main
+// win; arch and group defaults are merged by key, main's rewrite rules are
+// tried before base's, and the servers of both are kept, base's first. A
+// MAC configured in both fails the merge. Main's own settings are kept for
+// saveConfig, so persisting never writes base settings into the main file.
+func mergeConfig(base, main *Spriteful) (*Spriteful, error) {
+	macs := make(map[string]bool)
+	for _, server := range base.Servers {
+		macs[server.key()] = true
+	}
+	for _, server := range main.Servers {
+		if macs[server.key()] {
+			return nil, fmt.Errorf(`mac "%s" is configured in both the base and the main config`, server.MacAddress)
+		}
+	}
+
+	own := *main
+	merged := *main
+	merged.own = &own
+	if merged.BindHost == "" {
+		merged.BindHost = base.BindHost
+	}
+	if merged.BindPort == 0 {
+		merged.BindPort = base.BindPort
+	}
+	merged.RawURLs = main.RawURLs || base.RawURLs
+	merged.RawCmdline = main.RawCmdline || base.RawCmdline
+	if merged.BaseURL == "" {
+		merged.BaseURL = base.BaseURL
+	}
+	if merged.DefaultKernel == "" {
+		merged.DefaultKernel = base.DefaultKernel
+	}
+	if merged.DefaultInitrd == nil {
+		merged.DefaultInitrd = base.DefaultInitrd
+	}
+	if merged.Fallback == nil {
+		merged.Fallback = base.Fallback
+	}
+	if merged.LeaseDefaults == nil {
+		merged.LeaseDefaults = base.LeaseDefaults
+	}
+	if merged.AllowedTags == nil {
+		merged.AllowedTags = base.AllowedTags
+	}
+	if merged.ACL == nil {
+		merged.ACL = base.ACL
+	}
+	if merged.RetryCount == 0 {
+		merged.RetryCount = base.RetryCount
+	}
+	if merged.RetryDelay == "" {
+		merged.RetryDelay = base.RetryDelay
+	}
+	if merged.Banner == "" {
+		merged.Banner = base.Banner
+	}
+	merged.Headers = mergeHeaders(base.Headers, main.Headers)
+	merged.ArchDefaults = mergeEntries(base.ArchDefaults, main.ArchDefaults)
+	merged.GroupDefaults = mergeEntries(base.GroupDefaults, main.GroupDefaults)
+	merged.RewriteRules = append(append([]RewriteRule{}, main.RewriteRules...), base.RewriteRules...)
+	merged.UserAgents = append(append([]UserAgentRule{}, main.UserAgents...), base.UserAgents...)
+
+	merged.Servers = make([]Server, 0, len(base.Servers)+len(main.Servers))
+	for _, server := range base.Servers {
+		server.inherited = true
+		merged.Servers = append(merged.Servers, server)
+	}
+	merged.Servers = append(merged.Servers, main.Servers...)
+	merged.buildIndex()
+	digest := sha256.New()
+	digest.Write([]byte(base.configHash + main.configHash))
+	merged.configHash = configHash(digest)
+	return &merged, nil
+}
+
+// Returns the entries of base and main by key, main's winning.
+func mergeEntries(base, main map[string]BootEntry) map[string]BootEntry {
+	if base == nil {
+		return main
+	}
+	merged := make(map[string]BootEntry, len(base)+len(main))
+	for key, entry := range base {
+		merged[key] = entry
+	}
+	for key, entry := range main {
+		merged[key] = entry
+	}
+	return merged
+}
+
+// ConfigLoadFailedEvent is the event field of the entries logged when a
+// config can't be loaded, so alerts can match on it.
+const ConfigLoadFailedEvent = "config_load_failed"
+
+// Returns the entry to log that the config at path couldn't be loaded
+// during phase, "startup" or "reload".
+func configLoadFailed(path, phase string, err error) *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{
+		"event":         ConfigLoadFailedEvent,
+		"path":          path,
+		"phase":         phase,
+		logrus.ErrorKey: err,
+	})
+}
+
+// Reloads the config file and publishes it as the live snapshot. Requests
+// in flight keep the snapshot they started with.
+func (s *Spriteful) reload() error {
+	logrus.Infof(`Reloading config "%s"...`, s.configPath)
+	defer s.quiesce()()
+	file, err := openConfig(s.configPath, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	next, err := readConfig(limitConfig(file, s.configPath, s.maxConfigSize), s.configFormat, s.strictConfig)
+	if err != nil {
+		return err
+	}
+	if s.baseConfigPath != "" {
+		base, err := readConfigFile(s.baseConfigPath, 0, 0, s.maxConfigSize, s.configFormat, s.strictConfig)
+		if err != nil {
+			return fmt.Errorf("base config: %v", err)
+		}
+		if next, err = mergeConfig(base, next); err != nil {
+			return err
+		}
+	}
+	if s.leasesPath != "" {
+		if err := next.loadLeases(s.leasesPath); err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Error("unable to read dhcp leases, using the config only.")
+		}
+	}
+	if err := next.checkCmdlineLengths(s.maxCmdline); err != nil && s.strictConfig {
+		return err
+	}
+	if err := next.checkCmdlineSyntax(s.cmdlineSyntax); err != nil {
+		return err
+	}
+	if err := s.update(func(*Spriteful) (*Spriteful, error) { return next, nil }); err != nil {
+		return err
+	}
+	logrus.Infof(`Config "%s" reloaded, %d servers.`, s.configPath, len(next.Servers))
+	if s.shadowPath != "" {
+		if err := s.loadShadow(); err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Error("unable to reload shadow config, keeping the current one.")
+		}
+	}
+	s.warnIfEmpty()
+	if s.cache != nil {
+		go s.cache.warm(remoteAssets(withBaseURL(next.Servers, next.BaseURL)), s.cacheWorkers)
+	}
+	return nil
+}
+
+// Reloads the config on SIGHUP. A config that can't be read, e.g. a file
+// briefly missing while a deploy tool replaces it, or parsed is logged as a
+// failed reload and the current config keeps serving.
+func (s *Spriteful) reloadOrKeep() {
+	if err := s.reload(); err != nil {
+		configLoadFailed(s.configPath, "reload", err).Error("unable to reload config, keeping the current one.")
+	}
+}
+
+// Writes the config to path with its servers in order (see sortedServers),
+// replacing the file atomically. Servers created from DHCP leases and servers
+// and settings of the base config are left out.
+func (s *Spriteful) saveConfig(path, order string) error {
+	saved := *s
+	if s.own != nil {
+		saved = *s.own
+	}
+	saved.Servers = make([]Server, 0, len(s.Servers))
+	for _, server := range sortedServers(s.Servers, order) {
+		if server.leased || server.inherited {
+			continue
+		}
+		if server.leaseHostname {
+			server.Hostname = ""
+		}
+		if server.leaseIP {
+			server.IP = ""
+		}
+		saved.Servers = append(saved.Servers, server)
+	}
+	data, err := json.MarshalIndent(&saved, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestDecodeConfig(t *testing.T) {
+	config := []byte(`{
+		"bind-host": "0.0.0.0",
+		"bind-port": 5000,
+		"servers": [
+			{"mac": "00:00:00:00:00:00", "kernel": "vmlinuz", "initrd": ["initrd"], "cmdline": "quiet", "serial": "SN-1"}
+		]
+	}`)
+	var want Spriteful
+	if err := json.Unmarshal(config, &want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeConfig(bytes.NewReader(config), false)
+	if err != nil {
+		t.Fatalf("config should decode, but it didn't: %v", err)
+	}
+	if got.BindHost != want.BindHost || got.BindPort != want.BindPort || !reflect.DeepEqual(got.Servers, want.Servers) {
+		t.Errorf("streamed config %+v should match unmarshalled config %+v", got, &want)
+	}
+	if _, err := got.findServerBySerial("SN-1"); err != nil {
+		t.Errorf("serial index should be built while decoding")
+	}
+
+	for _, invalid := range []string{``, `[]`, `{"servers": {}}`, `{"servers": [{"mac": 1}]}`, `{"bind-port": "x"}`} {
+		if _, err := decodeConfig(bytes.NewReader([]byte(invalid)), false); err == nil {
+			t.Errorf("%q should not decode, but it did", invalid)
+		}
+	}
+}
+
+func TestDecodeStrictConfig(t *testing.T) {
+	typos := map[string]string{
+		`{"servers": [{"mac": "00:00:00:00:00:00", "cmdLine": "quiet"}]}`: `unknown field "cmdLine"`,
+		`{"bind-prot": 5000}`: `unknown field "bind-prot"`,
+	}
+	for config, want := range typos {
+		if _, err := decodeConfig(strings.NewReader(config), false); err != nil {
+			t.Errorf("%s should decode leniently, but it didn't: %v", config, err)
+		}
+		if _, err := decodeConfig(strings.NewReader(config), true); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s should fail with %s, got %v", config, want, err)
+		}
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	config := `{"servers": [{"mac": "00:00:00:00:00:00", "kernel": "vmlinuz"}]}`
+	first, _ := decodeConfig(strings.NewReader(config), false)
+	second, _ := decodeConfig(strings.NewReader(config), false)
+	changed, _ := decodeConfig(strings.NewReader(strings.Replace(config, "vmlinuz", "bzImage", 1)), false)
+	if len(first.configHash) != 12 || first.configHash != second.configHash {
+		t.Errorf("the same config should have the same short hash, got %q and %q", first.configHash, second.configHash)
+	}
+	if changed.configHash == first.configHash {
+		t.Errorf("a changed config should have a different hash")
+	}
+
+	res := serve(first, "GET", "/api/v1/boot/"+validMac, nil)
+	if got := res.Header().Get(ConfigHashHeader); got != first.configHash {
+		t.Errorf("boot responses should carry the config hash, got %q", got)
+	}
+	var status HealthStatus
+	json.Unmarshal(serve(first, "GET", "/healthz", nil).Body.Bytes(), &status)
+	if status.ConfigHash != first.configHash {
+		t.Errorf("healthz should report the config hash, got %q", status.ConfigHash)
+	}
+}
+
+func TestConfigLoadFailedEvent(t *testing.T) {
+	hook := test.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	configLoadFailed("/etc/spriteful.json", "reload", io.ErrUnexpectedEOF).Error("unable to reload config, keeping the current one.")
+	entry := hook.LastEntry()
+	if entry == nil || entry.Level != logrus.ErrorLevel || entry.Message != "unable to reload config, keeping the current one." {
+		t.Fatalf("an error with the readable message should be logged, got %+v", entry)
+	}
+	want := logrus.Fields{"event": ConfigLoadFailedEvent, "path": "/etc/spriteful.json", "phase": "reload", logrus.ErrorKey: io.ErrUnexpectedEOF}
+	if !reflect.DeepEqual(entry.Data, want) {
+		t.Errorf("the event fields %v are expected, got %v", want, entry.Data)
+	}
+}
+
+func TestReadConfigFormats(t *testing.T) {
+	jsonConfig := "\n\t{\"servers\": [{\"mac\": \"00:00:00:00:00:00\", \"kernel\": \"vmlinuz\", \"initrd\": [\"initrd\"]}]}"
+	yamlConfig := `
+# a .conf file
+servers:
+  - mac: "00:00:00:00:00:00"
+    kernel: vmlinuz
+    initrd:
+      - initrd
+`
+	for name, config := range map[string]string{"json": jsonConfig, "yaml": yamlConfig} {
+		for _, format := range []string{FormatAuto, name} {
+			s, err := readConfig(strings.NewReader(config), format, true)
+			if err != nil {
+				t.Errorf("%s should read as %s, but it didn't: %v", name, format, err)
+				continue
+			}
+			if len(s.Servers) != 1 || s.Servers[0].Kernel != "vmlinuz" || len(s.Servers[0].Initrd) != 1 || s.configHash == "" {
+				t.Errorf("%s read as %s should hold the server, got %+v", name, format, s.Servers)
+			}
+		}
+	}
+	if _, err := readConfig(strings.NewReader(yamlConfig), FormatJSON, false); err == nil {
+		t.Errorf("yaml forced to json should not read, but it did")
+	}
+	if _, err := readConfig(strings.NewReader("servers:\n  - mac: [\n"), FormatAuto, false); err == nil {
+		t.Errorf("unparseable yaml should not read, but it did")
+	}
+	if _, err := readConfig(strings.NewReader("servers:\n  - mac: \"00:00:00:00:00:00\"\n    cmdLine: quiet\n"), FormatAuto, true); err == nil || !strings.Contains(err.Error(), `unknown field "cmdLine"`) {
+		t.Errorf("strict yaml should reject unknown fields, got %v", err)
+	}
+}
+
+func TestMergeConfig(t *testing.T) {
+	base, _ := decodeConfig(strings.NewReader(`{
+		"bind-host": "0.0.0.0",
+		"bind-port": 5000,
+		"arch-defaults": {"arm64": {"kernel": "base-arm64"}, "x86_64": {"kernel": "base-x86"}},
+		"servers": [{"mac": "00:00:00:00:00:00", "kernel": "base", "serial": "SN-1"}]
+	}`), false)
+	main, _ := decodeConfig(strings.NewReader(`{
+		"bind-port": 6000,
+		"arch-defaults": {"arm64": {"kernel": "main-arm64"}},
+		"servers": [{"mac": "00:00:00:00:00:01", "kernel": "main"}]
+	}`), false)
+	merged, err := mergeConfig(base, main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.BindHost != "0.0.0.0" || merged.BindPort != 6000 {
+		t.Errorf("main settings should win over base settings, got %s:%d", merged.BindHost, merged.BindPort)
+	}
+	if merged.ArchDefaults["arm64"].Kernel != "main-arm64" || merged.ArchDefaults["x86_64"].Kernel != "base-x86" {
+		t.Errorf("arch defaults should merge by key, got %+v", merged.ArchDefaults)
+	}
+	if len(merged.Servers) != 2 {
+		t.Errorf("the servers of both configs are expected, got %+v", merged.Servers)
+	}
+	if _, err := merged.findServerBySerial("SN-1"); err != nil {
+		t.Errorf("base servers should be indexed")
+	}
+	if merged.configHash == main.configHash || merged.configHash == base.configHash {
+		t.Errorf("the merged config should have its own fingerprint")
+	}
+
+	saved, err := ioutil.TempFile("", "spriteful-merged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved.Close()
+	defer os.Remove(saved.Name())
+	if err := merged.saveConfig(saved.Name(), ""); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(saved.Name())
+	var persisted Spriteful
+	json.Unmarshal(data, &persisted)
+	if persisted.BindHost != "" || len(persisted.ArchDefaults) != 1 || len(persisted.Servers) != 1 || persisted.Servers[0].Kernel != "main" {
+		t.Errorf("only main settings and servers should be saved, got %s", data)
+	}
+
+	conflict, _ := decodeConfig(strings.NewReader(`{"servers": [{"mac": "00-00-00-00-00-00", "kernel": "main"}]}`), false)
+	if _, err := mergeConfig(base, conflict); err == nil || !strings.Contains(err.Error(), "both") {
+		t.Errorf("a mac in both configs should fail the merge, got %v", err)
+	}
+}
+
+func TestDecodeLargeConfig(t *testing.T) {
+	const count = 50000
+	r, w := io.Pipe()
+	go func() {
+		fmt.Fprint(w, `{"servers": [`)
+		for i := 0; i < count; i++ {
+			if i > 0 {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintf(w, `{"mac": "%s", "kernel": "vmlinuz-%d", "serial": "SN-%d"}`, testMac(i), i, i)
+		}
+		fmt.Fprint(w, `], "bind-port": 5000}`)
+		w.Close()
+	}()
+	sprite, err := decodeConfig(r, false)
+	if err != nil {
+		t.Fatalf("large config should decode, but it didn't: %v", err)
+	}
+	if len(sprite.Servers) != count || sprite.BindPort != 5000 {
+		t.Fatalf("%d servers are expected, servers: %d", count, len(sprite.Servers))
+	}
+	server, err := sprite.findServerBySerial(fmt.Sprintf("SN-%d", count-1))
+	if err != nil || server.Kernel != fmt.Sprintf("vmlinuz-%d", count-1) {
+		t.Errorf("last server should be indexed, got %+v", server)
+	}
+}
+
+func TestMaxConfigSize(t *testing.T) {
+	path := writeTestConfig(t, 100, "vmlinuz")
+	defer os.Remove(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readConfigFile(path, 0, 0, info.Size()-1, FormatAuto, false); err == nil || !strings.Contains(err.Error(), "larger than the maximum") {
+		t.Errorf("a config over the maximum size should fail, got %v", err)
+	}
+	if _, err := readConfigFile(path, 0, 0, info.Size(), FormatAuto, false); err != nil {
+		t.Errorf("a config at the maximum size should load, got %v", err)
+	}
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, path)
+	}))
+	defer origin.Close()
+	if _, err := readConfigFile(origin.URL, 0, 0, 64, FormatAuto, false); err == nil {
+		t.Error("a remote config over the maximum size should fail")
+	}
+	s := &Spriteful{configPath: path, maxConfigSize: 64}
+	if err := s.reload(); err == nil {
+		t.Error("reloading a config over the maximum size should fail")
+	}
+}
+
+// Returns a distinct MAC address for i.
+func testMac(i int) string {
+	return fmt.Sprintf("02:00:00:%02x:%02x:%02x", (i>>16)&0xff, (i>>8)&0xff, i&0xff)
+}
+
+func TestOpenRemoteConfig(t *testing.T) {
+	attempts := 0
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"servers": [{"mac": "00:00:00:00:00:00", "kernel": "vmlinuz"}]}`)
+	}))
+	defer origin.Close()
+
+	if _, err := openConfig(origin.URL, 1, time.Millisecond); err == nil || attempts != 2 {
+		t.Fatalf("config should fail after one retry, attempts: %d", attempts)
+	}
+	attempts = 0
+	body, err := openConfig(origin.URL, 3, time.Millisecond)
+	if err != nil || attempts != 3 {
+		t.Fatalf("config should load on the third attempt, attempts: %d err: %v", attempts, err)
+	}
+	defer body.Close()
+	if sprite, err := decodeConfig(body, false); err != nil || len(sprite.Servers) != 1 {
+		t.Errorf("fetched config should decode, err: %v", err)
+	}
+	if _, err := openConfig("/nonexistent/config.json", 3, time.Hour); err == nil {
+		t.Errorf("a missing local config should fail fast")
+	}
+}
+
+func TestOpenStalledConfig(t *testing.T) {
+	stall := make(chan struct{})
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stall
+	}))
+	defer origin.Close()
+	defer close(stall)
+	defer func(timeout time.Duration) { configClient.Timeout = timeout }(configClient.Timeout)
+	configClient.Timeout = 50 * time.Millisecond
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := openConfig(origin.URL, 0, time.Millisecond)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("a stalled config server should fail the fetch")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a stalled config server should not hang the fetch")
+	}
+}
+
+func TestConfigExitStatus(t *testing.T) {
+	// Re-run as the spriteful binary with the flags in the environment.
+	if args := os.Getenv("SPRITEFUL_TEST_MAIN"); args != "" {
+		os.Args = append([]string{"spriteful"}, strings.Fields(args)...)
+		main()
+		os.Exit(0)
+	}
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	for _, config := range []string{"/nonexistent/config.json", down.URL + "/config.json"} {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestConfigExitStatus$")
+		cmd.Env = append(os.Environ(), "SPRITEFUL_TEST_MAIN=-config "+config+" -config-retries 1 -config-retry-interval 1ms")
+		err := cmd.Run()
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != ExitLoadConfigError {
+			t.Errorf("an unreadable config at %s should exit %d, got %v", config, ExitLoadConfigError, err)
+		}
+	}
+}
+
+func TestEmbeddedDefaultConfig(t *testing.T) {
+	if _, err := openConfigOrDefault("/nonexistent/config.json", 0, 0, false); err == nil {
+		t.Errorf("a missing config should fail without -allow-embedded-default")
+	}
+	body, err := openConfigOrDefault("/nonexistent/config.json", 0, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	sprite, err := readConfig(body, FormatAuto, true)
+	if err != nil || sprite.BindPort != 5000 || len(sprite.Servers) != 0 {
+		t.Errorf("the embedded default config should load, got %+v (%v)", sprite, err)
+	}
+
+	file, err := ioutil.TempFile("", "spriteful")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	if _, err := openConfigOrDefault(filepath.Join(file.Name(), "config.json"), 0, 0, true); err == nil {
+		t.Errorf("an unreadable config should fail even with -allow-embedded-default")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"net/http"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// deepChecker spot-checks that the origins serving configured kernels are
+	// reachable. Results are cached for interval so probes don't hammer the
+	// origins.
+	deepChecker struct {
+		interval time.Duration
+		client   *http.Client
+
+		mu      sync.Mutex
+		checked time.Time
+		origins map[string]OriginStatus
+	}
+
+	// OriginStatus is the result of checking one origin.
+	OriginStatus struct {
+		URL     string    `json:"url"`
+		OK      bool      `json:"ok"`
+		Error   string    `json:"error,omitempty"`
+		Checked time.Time `json:"checked"`
+	}
+)
+
+// Creates a deep checker caching results for interval.
+func newDeepChecker(interval time.Duration) *deepChecker {
+	return &deepChecker{
+		interval: interval,
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Returns the status of every origin, checking them again when the cached
+// results are older than the interval.
+func (d *deepChecker) check(servers []Server, now time.Time) map[string]OriginStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.origins != nil && now.Sub(d.checked) < d.interval {
+		return d.origins
+	}
+
+	samples := make(map[string]string)
+	for _, server := range servers {
+		parsed, err := url.Parse(server.Kernel)
+		if err != nil || isTemplate(server.Kernel) || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			continue
+		}
+		origin := parsed.Scheme + "://" + parsed.Host
+		if _, ok := samples[origin]; !ok {
+			samples[origin] = server.Kernel
+		}
+	}
+
+	results := make(map[string]OriginStatus)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for origin, sample := range samples {
+		wg.Add(1)
+		go func(origin, sample string) {
+			defer wg.Done()
+			status := OriginStatus{URL: sample, Checked: now}
+			if err := probeAsset(context.Background(), d.client, sample); err != nil {
+				status.Error = err.Error()
+				logrus.WithField(logrus.ErrorKey, err).Warnf(`origin "%s" is unreachable.`, origin)
+			} else {
+				status.OK = true
+			}
+			mu.Lock()
+			results[origin] = status
+			mu.Unlock()
+		}(origin, sample)
+	}
+	wg.Wait()
+	d.origins = results
+	d.checked = now
+	return results
+}
+
+// Sends a HEAD request for the asset, failing on errors and error statuses.
+// Origins that don't allow HEAD count as reachable.
+func probeAsset(ctx context.Context, client *http.Client, asset string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, asset, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 && resp.StatusCode != http.StatusMethodNotAllowed {
+		return &statusError{resp.Status}
+	}
+	return nil
+}
+
+// statusError reports an unexpected http status.
+type statusError struct {
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "unexpected status " + e.status
+}
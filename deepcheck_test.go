@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+)
+
+func TestDeepHealthRequest(t *testing.T) {
+	up := true
+	probes := 0
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes++
+		if !up {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer origin.Close()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &Spriteful{
+		Servers: []Server{
+			{MacAddress: validMac, Kernel: origin.URL + "/a.vmlinuz"},
+			{MacAddress: invalidMac, Kernel: origin.URL + "/b.vmlinuz"},
+		},
+		deepCheck: newDeepChecker(time.Minute),
+		clock:     func() time.Time { return now },
+	}
+	var status HealthStatus
+	res := serve(s, "GET", "/healthz?deep=true", nil)
+	json.Unmarshal(res.Body.Bytes(), &status)
+	if res.Code != http.StatusOK || len(status.Origins) != 1 || probes != 1 {
+		t.Fatalf("one reachable origin is expected, status: %d origins: %v probes: %d", res.Code, status.Origins, probes)
+	}
+
+	up = false
+	if res := serve(s, "GET", "/healthz?deep=true", nil); res.Code != http.StatusOK || probes != 1 {
+		t.Errorf("deep checks should be cached, status: %d probes: %d", res.Code, probes)
+	}
+	now = now.Add(time.Minute)
+	if res := serve(s, "GET", "/healthz?deep=true", nil); res.Code != http.StatusServiceUnavailable {
+		t.Errorf("an unreachable origin should 503, status: %d", res.Code)
+	}
+	if res := serve(s, "GET", "/healthz", nil); res.Code != http.StatusOK {
+		t.Errorf("shallow checks should not check origins, status: %d", res.Code)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"net/http"
+	"net/url"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+// Returns the URL a boot request for the server is delegated to. Delegate
+// URLs that are templates are rendered with the context, others get the
+// MAC appended as a path segment. The request's query parameters, except
+// override tokens, are passed on unless the delegate URL has its own.
+func delegateTarget(delegate string, ctx *TemplateContext, query url.Values) string {
+	target := delegate
+	if isTemplate(delegate) {
+		target = renderTemplate(delegate, ctx)
+	} else {
+		target = strings.TrimSuffix(delegate, "/") + "/" + ctx.MAC
+	}
+	forwarded := url.Values{}
+	for key, values := range query {
+		if key != "override" {
+			forwarded[key] = values
+		}
+	}
+	if len(forwarded) > 0 && !strings.Contains(target, "?") {
+		target += "?" + forwarded.Encode()
+	}
+	return target
+}
+
+// Returns an error unless the delegate URL is an absolute http(s) URL.
+// Templates are only checked once rendered.
+func checkDelegateURL(delegate string) error {
+	if isTemplate(delegate) {
+		return nil
+	}
+	parsed, err := url.Parse(delegate)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("delegate url %q isn't an absolute http(s) url", delegate)
+	}
+	return nil
+}
+
+// Answers the boot request with a 302 to the server's delegate URL.
+func (s *Spriteful) writeBootDelegate(req *restful.Request, res *restful.Response, server *Server) {
+	ctx := templateContext(server, req.QueryParameter("arch"), clientIP(req))
+	target := delegateTarget(server.DelegateURL, ctx, req.Request.URL.Query())
+	logrus.Infof(`delegating "%s" to "%s".`, server.MacAddress, target)
+	res.Header().Set("Location", target)
+	logBootResponse(http.StatusFound, "")
+	res.WriteHeader(http.StatusFound)
+	s.recordBoot(AuditEntry{Time: s.now(), MacAddress: macKey(server.MacAddress), ClientIP: clientIP(req), Match: server.match, Status: http.StatusFound})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"net/http"
+)
+
+func TestDelegateURL(t *testing.T) {
+	s := &Spriteful{Servers: []Server{
+		{MacAddress: validMac, DelegateURL: "http://boot2:5000/api/v1/boot/"},
+		{MacAddress: invalidMac, DelegateURL: `http://boot3/boot/{{ mac "dash" .MAC }}.json`},
+	}}
+	tests := map[string]string{
+		"/api/v1/boot/" + validMac:                               "http://boot2:5000/api/v1/boot/" + validMac,
+		"/api/v1/boot/" + validMac + "?format=ipxe&override=tok": "http://boot2:5000/api/v1/boot/" + validMac + "?format=ipxe",
+		"/api/v1/boot/" + invalidMac:                             "http://boot3/boot/00-00-00-00-00-01.json",
+	}
+	for path, want := range tests {
+		res := serve(s, "GET", path, nil)
+		if res.Code != http.StatusFound || res.Header().Get("Location") != want {
+			t.Errorf("%s should be delegated to %s, status: %d location: %s", path, want, res.Code, res.Header().Get("Location"))
+		}
+	}
+
+	if err := (&Server{MacAddress: validMac, DelegateURL: "http://boot2/api/v1/boot"}).validate(""); err != nil {
+		t.Errorf("delegated servers shouldn't need a kernel, got %v", err)
+	}
+	if err := (&Server{MacAddress: validMac, DelegateURL: "boot2/api"}).validate(""); err == nil {
+		t.Error("relative delegate urls should be rejected")
+	}
+	if err := (&Server{MacAddress: validMac, DelegateURL: "http://boot2", ResponseStatus: 404}).validate(""); err == nil {
+		t.Error("delegate urls and response statuses should be exclusive")
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+
+	"encoding/json"
+)
+
+// These are the exit codes of spriteful diff, as with diff(1).
+const (
+	DiffSame = iota
+	DiffChanged
+	DiffError
+)
+
+// Runs `spriteful diff old new`: loads both configs, and prints one line
+// per added (+), removed (-) or changed (~) server and top-level setting to
+// out, servers keyed by normalized MAC and VLAN. Returns DiffChanged when
+// they differ and DiffError when either can't be loaded or lists a server
+// twice.
+func runDiff(args []string, out, errOut io.Writer) int {
+	flags := flag.NewFlagSet("spriteful diff", flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	format := flags.String("format", FormatAuto, "format of both configs (auto, json, yaml)")
+	strict := flags.Bool("strict", false, "fail to load configs with unknown fields")
+	flags.Usage = func() {
+		fmt.Fprintln(errOut, "Usage: spriteful diff [-format auto|json|yaml] [-strict] old new")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return DiffError
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return DiffError
+	}
+	if err := validConfigFormat(*format); err != nil {
+		fmt.Fprintln(errOut, err)
+		return DiffError
+	}
+	configs := make([]*Spriteful, 2)
+	for i, path := range flags.Args() {
+		config, err := readConfigFile(path, 0, 0, 0, *format, *strict)
+		if err != nil {
+			fmt.Fprintf(errOut, "%s: %v\n", path, err)
+			return DiffError
+		}
+		configs[i] = config
+	}
+	changes, err := diffConfigs(configs[0], configs[1])
+	if err != nil {
+		fmt.Fprintln(errOut, err)
+		return DiffError
+	}
+	for _, change := range changes {
+		fmt.Fprintln(out, change)
+	}
+	if len(changes) > 0 {
+		return DiffChanged
+	}
+	return DiffSame
+}
+
+// Returns the changes from the old config to the new one, servers first,
+// each sorted by key.
+func diffConfigs(from, to *Spriteful) ([]string, error) {
+	oldServers, err := normalizedServers(from.Servers)
+	if err != nil {
+		return nil, fmt.Errorf("old config: %v", err)
+	}
+	newServers, err := normalizedServers(to.Servers)
+	if err != nil {
+		return nil, fmt.Errorf("new config: %v", err)
+	}
+	keys := make(map[string]bool, len(oldServers)+len(newServers))
+	for key := range oldServers {
+		keys[key] = true
+	}
+	for key := range newServers {
+		keys[key] = true
+	}
+	var changes []string
+	for _, key := range sortedKeys(keys) {
+		before, inOld := oldServers[key]
+		after, inNew := newServers[key]
+		switch {
+		case !inOld:
+			changes = append(changes, "+ server "+key)
+		case !inNew:
+			changes = append(changes, "- server "+key)
+		default:
+			for _, field := range unionKeys(before, after) {
+				if change := diffValue("server "+key+" "+field, before[field], after[field]); change != "" {
+					changes = append(changes, change)
+				}
+			}
+		}
+	}
+
+	oldSettings, err := normalizedSettings(from)
+	if err != nil {
+		return nil, err
+	}
+	newSettings, err := normalizedSettings(to)
+	if err != nil {
+		return nil, err
+	}
+	for _, setting := range unionKeys(oldSettings, newSettings) {
+		if change := diffValue("setting "+setting, oldSettings[setting], newSettings[setting]); change != "" {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// Returns the change line for the value named name, "" if it's unchanged.
+func diffValue(name string, before, after json.RawMessage) string {
+	switch {
+	case bytes.Equal(before, after):
+		return ""
+	case before == nil:
+		return fmt.Sprintf("+ %s: %s", name, after)
+	case after == nil:
+		return fmt.Sprintf("- %s: %s", name, before)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", name, before, after)
+}
+
+// Returns the fields of every server as compact JSON by server key, with
+// the MAC normalized. Two servers with the same key are an error, as
+// either would hide the other from the diff.
+func normalizedServers(servers []Server) (map[string]map[string]json.RawMessage, error) {
+	normalized := make(map[string]map[string]json.RawMessage, len(servers))
+	for _, server := range servers {
+		server.MacAddress = macKey(server.MacAddress)
+		if _, ok := normalized[server.key()]; ok {
+			return nil, fmt.Errorf("duplicate server %s", server.key())
+		}
+		fields, err := jsonObject(&server)
+		if err != nil {
+			return nil, err
+		}
+		normalized[server.key()] = fields
+	}
+	return normalized, nil
+}
+
+// Returns the top-level settings of the config as compact JSON by name.
+func normalizedSettings(config *Spriteful) (map[string]json.RawMessage, error) {
+	settings := *config
+	settings.Servers = nil
+	fields, err := jsonObject(&settings)
+	if err != nil {
+		return nil, err
+	}
+	delete(fields, "servers")
+	return fields, nil
+}
+
+// Returns the fields of v's JSON object, each re-encoded compactly with
+// sorted keys so equal values compare equal.
+func jsonObject(v interface{}) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]json.RawMessage, len(decoded))
+	for name, value := range decoded {
+		if fields[name], err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// Returns the keys of both maps, sorted.
+func unionKeys(a, b map[string]json.RawMessage) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	for key := range a {
+		seen[key] = true
+	}
+	for key := range b {
+		seen[key] = true
+	}
+	return sortedKeys(seen)
+}
+
+// Returns the keys of the set, sorted.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"io/ioutil"
+	"path/filepath"
+)
+
+func TestDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spriteful")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	old := write("old.json", `{"default-kernel": "a", "servers": [
+		{"mac": "00-00-00-00-00-01", "kernel": "k1", "cmdline": "quiet"},
+		{"mac": "00:00:00:00:00:02", "kernel": "k2"}]}`)
+	same := write("same.yaml", "default-kernel: a\nservers:\n- mac: 00:00:00:00:00:02\n  kernel: k2\n- mac: 00:00:00:00:00:01\n  cmdline: quiet\n  kernel: k1\n")
+	changed := write("new.json", `{"default-kernel": "b", "banner": "hello", "servers": [
+		{"mac": "00:00:00:00:00:01", "kernel": "k1", "cmdline": "quiet splash"},
+		{"mac": "00:00:00:00:00:03", "kernel": "k3"}]}`)
+
+	run := func(args ...string) (int, string) {
+		var out, errOut bytes.Buffer
+		code := runDiff(args, &out, &errOut)
+		return code, out.String()
+	}
+	if code, out := run(old, same); code != DiffSame || out != "" {
+		t.Errorf("configs differing only in format, MAC notation and order should be the same, got %d: %s", code, out)
+	}
+	code, out := run(old, changed)
+	if code != DiffChanged {
+		t.Errorf("changed configs should exit %d, got %d", DiffChanged, code)
+	}
+	want := []string{
+		`~ server 00:00:00:00:00:01 cmdline: "quiet" -> "quiet splash"`,
+		`- server 00:00:00:00:00:02`,
+		`+ server 00:00:00:00:00:03`,
+		`+ setting banner: "hello"`,
+		`~ setting default-kernel: "a" -> "b"`,
+	}
+	if got := strings.Split(strings.TrimSpace(out), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("diff should list each change, got:\n%s", out)
+	}
+
+	if code, _ := run(old, filepath.Join(dir, "missing.json")); code != DiffError {
+		t.Errorf("a missing config should exit %d, got %d", DiffError, code)
+	}
+	if code, _ := run("-format", "json", old, same); code != DiffError {
+		t.Errorf("a config not in -format should exit %d, got %d", DiffError, code)
+	}
+	duplicate := write("duplicate.json", `{"servers": [
+		{"mac": "00:00:00:00:00:01", "kernel": "k1"},
+		{"mac": "00-00-00-00-00-01", "kernel": "k2"}]}`)
+	var errOut bytes.Buffer
+	if code := runDiff([]string{old, duplicate}, &bytes.Buffer{}, &errOut); code != DiffError || !strings.Contains(errOut.String(), "duplicate server 00:00:00:00:00:01") {
+		t.Errorf("a config listing a server twice should exit %d, got %d: %s", DiffError, code, errOut.String())
+	}
+	if code, _ := run(old); code != DiffError {
+		t.Errorf("a single config should exit %d, got %d", DiffError, code)
+	}
+}
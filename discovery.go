@@ -0,0 +1,69 @@
+package main
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// discovery boots machines missing from the config into a discovery
+	// image and reports them through a bounded event queue, usually to a
+	// webhook, so they can be registered.
+	discovery struct {
+		image  string
+		events *eventQueue
+	}
+
+	// DiscoveryEvent is posted to the discovery webhook for every unknown machine.
+	DiscoveryEvent struct {
+		MacAddress string    `json:"mac,omitempty"`
+		Serial     string    `json:"serial,omitempty"`
+		Time       time.Time `json:"time"`
+	}
+)
+
+// Creates the discovery mode, returning nil when neither an image nor an
+// event queue is configured.
+func newDiscovery(image string, events *eventQueue) *discovery {
+	if image == "" && events == nil {
+		return nil
+	}
+	return &discovery{image: image, events: events}
+}
+
+// Handles an unknown machine, returning the discovery server config or nil
+// when no discovery image is configured.
+func (d *discovery) boot(macAddress, serial string, now time.Time) *Server {
+	if d == nil {
+		return nil
+	}
+	d.events.publish(&DiscoveryEvent{
+		MacAddress: macAddress,
+		Serial:     serial,
+		Time:       now.UTC(),
+	})
+	server := d.server(macAddress, serial)
+	if server != nil {
+		logrus.Infof(`booting unknown machine "%s%s" into discovery image.`, macAddress, serial)
+	}
+	return server
+}
+
+// Returns the discovery server config for an unknown machine without
+// reporting it, or nil when no discovery image is configured.
+func (d *discovery) server(macAddress, serial string) *Server {
+	if d == nil || d.image == "" {
+		return nil
+	}
+	return &Server{
+		MacAddress: macAddress,
+		Serial:     serial,
+		Kernel:     d.image,
+	}
+}
+
+// Returns the MAC address or serial number of the discovered machine.
+func (e *DiscoveryEvent) machine() string {
+	return e.MacAddress + e.Serial
+}
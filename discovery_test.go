@@ -0,0 +1,163 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+)
+
+func TestDiscoveryBoot(t *testing.T) {
+	events := make(chan DiscoveryEvent, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event DiscoveryEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer webhook.Close()
+
+	s := &Spriteful{discovery: newDiscovery("http://images/discovery.vmlinuz", newEventQueue("discovery event", newWebhookPublisher(webhook.URL), 8))}
+	res := serve(s, "GET", "/api/v1/boot/"+invalidMac, nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("unknown mac should boot the discovery image, status: %d", res.Code)
+	}
+	var response PixieResponse
+	json.Unmarshal(res.Body.Bytes(), &response)
+	if response.Kernel != "http://images/discovery.vmlinuz" {
+		t.Errorf("discovery kernel is expected, kernel: %s", response.Kernel)
+	}
+	select {
+	case event := <-events:
+		if event.MacAddress != invalidMac {
+			t.Errorf("webhook should report %s, got %s", invalidMac, event.MacAddress)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("webhook should be notified, but it wasn't")
+	}
+
+	s.discovery = newDiscovery("", nil)
+	if res := serve(s, "GET", "/api/v1/boot/"+invalidMac, nil); res.Code != http.StatusNotFound {
+		t.Errorf("unknown mac should 404 without discovery, status: %d", res.Code)
+	}
+}
+
+func TestFallbackChain(t *testing.T) {
+	config := []byte(`{
+		"default-kernel": "default.vmlinuz",
+		"group-defaults": {"rack1": {"kernel": "rack1.vmlinuz", "cmdline": "console=ttyS0"}},
+		"fallback": {"kernel": "fallback.vmlinuz"},
+		"servers": [
+			{"mac": "00:00:00:00:00:00", "kernel": "exact.vmlinuz", "group": "rack1"},
+			{"mac": "00:00:00:00:00:01", "group": "rack1", "cmdline": "quiet"},
+			{"mac": "00:00:00:00:00:02", "cmdline": "quiet"},
+			{"mac": "aa:bb:*", "kernel": "wildcard.vmlinuz"}
+		]
+	}`)
+	var s Spriteful
+	if err := json.Unmarshal(config, &s); err != nil {
+		t.Fatal(err)
+	}
+	s.discovery = newDiscovery("discovery.vmlinuz", nil)
+	boot := func(mac string) (int, PixieResponse) {
+		var response PixieResponse
+		res := serve(&s, "GET", "/api/v1/boot/"+mac, nil)
+		json.Unmarshal(res.Body.Bytes(), &response)
+		return res.Code, response
+	}
+	cases := []struct {
+		mac     string
+		kernel  string
+		cmdline string
+	}{
+		{"00:00:00:00:00:00", "exact.vmlinuz", "console=ttyS0"},
+		{"aa:bb:00:00:00:01", "wildcard.vmlinuz", ""},
+		{"00:00:00:00:00:01", "rack1.vmlinuz", "console=ttyS0 quiet"},
+		{"00:00:00:00:00:02", "default.vmlinuz", "quiet"},
+		{"cc:00:00:00:00:00", "fallback.vmlinuz", ""},
+	}
+	for _, c := range cases {
+		if code, got := boot(c.mac); code != http.StatusOK || got.Kernel != c.kernel || got.CommandLine != c.cmdline {
+			t.Errorf("%s should boot %s with %q, status: %d got %+v", c.mac, c.kernel, c.cmdline, code, got)
+		}
+	}
+
+	s.Fallback = nil
+	if _, got := boot("cc:00:00:00:00:00"); got.Kernel != "discovery.vmlinuz" {
+		t.Errorf("without a fallback, unknown macs should boot discovery, got %+v", got)
+	}
+	s.discovery = nil
+	if code, _ := boot("cc:00:00:00:00:00"); code != http.StatusNotFound {
+		t.Errorf("when every layer misses, unknown macs should not be found, status: %d", code)
+	}
+}
+
+func TestWebhookBreaker(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newBreaker("webhook", 2, time.Minute)
+	b.clock = func() time.Time { return now }
+
+	b.failure()
+	if !b.allow() {
+		t.Fatal("the breaker should stay closed below the threshold")
+	}
+	b.failure()
+	if b.allow() || b.state != BreakerOpen {
+		t.Fatalf("the breaker should open at the threshold, state: %s", b.state)
+	}
+	now = now.Add(time.Minute)
+	if !b.allow() || b.state != BreakerHalfOpen {
+		t.Fatalf("the breaker should half-open after the cooldown, state: %s", b.state)
+	}
+	if b.allow() {
+		t.Error("only one call should be let through while half-open")
+	}
+	b.failure()
+	if b.allow() || b.state != BreakerOpen {
+		t.Fatalf("a failed recovery call should reopen the breaker, state: %s", b.state)
+	}
+	now = now.Add(time.Minute)
+	b.allow()
+	b.success()
+	if !b.allow() || b.state != BreakerClosed {
+		t.Errorf("a successful recovery call should close the breaker, state: %s", b.state)
+	}
+	if newBreaker("webhook", 0, time.Minute) != nil {
+		t.Error("a zero threshold should disable the breaker")
+	}
+}
+
+func TestWebhookBreakerOpen(t *testing.T) {
+	calls := make(chan struct{}, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls <- struct{}{}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer webhook.Close()
+
+	publisher := newGuardedPublisher(newWebhookPublisher(webhook.URL), newBreaker("discovery webhook", 1, time.Hour))
+	publisher.Publish(&DiscoveryEvent{MacAddress: invalidMac})
+	publisher.Publish(&DiscoveryEvent{MacAddress: invalidMac})
+	if len(calls) != 1 {
+		t.Errorf("an open breaker should drop events, webhook calls: %d", len(calls))
+	}
+}
+
+func TestDiscoveryQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer webhook.Close()
+	defer close(release)
+
+	d := newDiscovery("", newEventQueue("discovery event", newWebhookPublisher(webhook.URL), 2))
+	for i := 0; i < 10; i++ {
+		d.boot(testMac(i), "", time.Now())
+	}
+	if dropped := atomic.LoadInt64(&d.events.dropped); dropped < 7 {
+		t.Errorf("discovery events should be dropped while a slow webhook fills the queue, dropped: %d", dropped)
+	}
+}
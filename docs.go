@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/emicklei/go-restful"
+	restfulspec "github.com/emicklei/go-restful-openapi"
+	"github.com/go-openapi/spec"
+	"github.com/sirupsen/logrus"
+)
+
+// Registers the OpenAPI spec describing every registered endpoint.
+func (s *Spriteful) registerDocs(container *restful.Container) {
+	config := restfulspec.Config{
+		WebServices: container.RegisteredWebServices(),
+		APIPath:     "/apidocs.json",
+		PostBuildSwaggerObjectHandler: func(swagger *spec.Swagger) {
+			swagger.Info = &spec.Info{
+				InfoProps: spec.InfoProps{
+					Title:       "Spriteful",
+					Description: "Server boot configuration for pixiecore.",
+					Version:     "v1",
+				},
+			}
+		},
+	}
+	container.Add(restfulspec.NewOpenAPIService(config))
+	logrus.Info(`OpenAPI spec created at "apidocs.json".`)
+}
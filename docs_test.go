@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"encoding/json"
+	"net/http"
+)
+
+func TestDocsRequest(t *testing.T) {
+	s := &Spriteful{docs: true}
+	res := serve(s, "GET", "/apidocs.json", nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("apidocs.json should be served, status: %d", res.Code)
+	}
+	var swagger struct {
+		Paths       map[string]interface{} `json:"paths"`
+		Definitions map[string]interface{} `json:"definitions"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &swagger); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := swagger.Paths["/api/v1/boot/{mac-addr}"]; !ok {
+		t.Errorf("boot endpoint should be documented, paths: %v", swagger.Paths)
+	}
+	found := false
+	for name := range swagger.Definitions {
+		found = found || strings.HasSuffix(name, "PixieResponse")
+	}
+	if !found {
+		t.Errorf("PixieResponse should be described, definitions: %v", swagger.Definitions)
+	}
+
+	if res := serve(&Spriteful{}, "GET", "/apidocs.json", nil); res.Code != http.StatusNotFound {
+		t.Errorf("apidocs.json should be off by default, status: %d", res.Code)
+	}
+}
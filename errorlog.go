@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Low-level http.Server errors caused by misbehaving clients rather than the
+// server, logged at debug level so they don't drown out real problems.
+var quietServerErrors = []string{
+	"TLS handshake error",
+	"URL query contains semicolon",
+	"http2: server: error reading preface",
+}
+
+// serverErrorWriter bridges the http.Server error log into logrus.
+type serverErrorWriter struct{}
+
+// Logs the message at debug level if it is one of quietServerErrors, at warn
+// level otherwise.
+func (serverErrorWriter) Write(p []byte) (int, error) {
+	message := strings.TrimSpace(string(p))
+	for _, quiet := range quietServerErrors {
+		if strings.Contains(message, quiet) {
+			logrus.WithField("source", "http").Debug(message)
+			return len(p), nil
+		}
+	}
+	logrus.WithField("source", "http").Warn(message)
+	return len(p), nil
+}
+
+// Returns the logger http.Server errors are written to.
+func serverErrorLog() *log.Logger {
+	return log.New(serverErrorWriter{}, "", 0)
+}
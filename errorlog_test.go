@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestServerErrorLog(t *testing.T) {
+	hook := test.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	level := logrus.GetLevel()
+	logrus.SetLevel(logrus.DebugLevel)
+	defer logrus.SetLevel(level)
+
+	logger := serverErrorLog()
+	logger.Printf("http: TLS handshake error from 10.0.0.1:4242: EOF")
+	if entry := hook.LastEntry(); entry == nil || entry.Level != logrus.DebugLevel || entry.Message != "http: TLS handshake error from 10.0.0.1:4242: EOF" {
+		t.Errorf("handshake errors should be logged at debug level, got %+v", entry)
+	}
+	logger.Printf("http: panic serving 10.0.0.1:4242: boom")
+	if entry := hook.LastEntry(); entry == nil || entry.Level != logrus.WarnLevel || entry.Data["source"] != "http" {
+		t.Errorf("other server errors should be logged at warn level, got %+v", entry)
+	}
+}
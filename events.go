@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+)
+
+// These are the results a boot event can report.
+const (
+	ResultBooted     = "booted"
+	ResultRedirected = "redirected"
+	ResultNotFound   = "not-found"
+	ResultRefused    = "refused"
+)
+
+// dropWarnInterval bounds how often dropped boot events are logged.
+const dropWarnInterval = time.Minute
+
+// errCircuitOpen is returned by guarded publishers while their breaker is open.
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+type (
+	// EventPublisher sends events, such as boot and discovery events, as JSON
+	// to an event pipeline.
+	EventPublisher interface {
+		Publish(event interface{}) error
+	}
+
+	// BootEvent is published for every boot decision.
+	BootEvent struct {
+		Time       time.Time `json:"time"`
+		MacAddress string    `json:"mac"`
+		Kernel     string    `json:"kernel,omitempty"`
+		ClientIP   string    `json:"client-ip,omitempty"`
+		Match      string    `json:"match"`
+		Status     int       `json:"status"`
+		Result     string    `json:"result"`
+	}
+
+	// queuedEvent is an event published through an eventQueue, which names
+	// it in its logs by the machine it is about.
+	queuedEvent interface {
+		machine() string
+	}
+
+	// eventQueue publishes events, such as boot or discovery events, in the
+	// background so boot requests never wait on the publisher. Events are
+	// dropped while the queue is full. A nil eventQueue publishes nothing.
+	eventQueue struct {
+		name      string
+		publisher EventPublisher
+		events    chan queuedEvent
+		dropped   int64
+		warned    int64
+	}
+
+	// webhookPublisher posts events as JSON to a URL.
+	webhookPublisher struct {
+		url    string
+		client *http.Client
+	}
+
+	// guardedPublisher publishes through a circuit breaker, failing events
+	// with errCircuitOpen while it is open.
+	guardedPublisher struct {
+		publisher EventPublisher
+		breaker   *breaker
+	}
+)
+
+// Creates the publisher for the events URL: a webhook for http and https
+// URLs, or the subject of a NATS server for nats URLs.
+func newEventPublisher(eventsURL, subject string) (EventPublisher, error) {
+	parsed, err := url.Parse(eventsURL)
+	if err != nil {
+		return nil, err
+	}
+	switch parsed.Scheme {
+	case "http", "https":
+		logrus.Infof(`publishing boot events to the webhook at "%s".`, parsed.Host)
+		return newWebhookPublisher(eventsURL), nil
+	case "nats":
+		if err := validSubject(subject); err != nil {
+			return nil, err
+		}
+		logrus.Infof(`publishing boot events to "%s" on the NATS server at "%s".`, subject, parsed.Host)
+		return newNATSPublisher(parsed, subject), nil
+	}
+	return nil, fmt.Errorf("unsupported events url scheme %q", parsed.Scheme)
+}
+
+// Creates the publisher posting events to the webhook URL.
+func newWebhookPublisher(url string) *webhookPublisher {
+	return &webhookPublisher{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Wraps the publisher in the breaker, returning it as is without a breaker.
+func newGuardedPublisher(publisher EventPublisher, b *breaker) EventPublisher {
+	if b == nil {
+		return publisher
+	}
+	return &guardedPublisher{publisher: publisher, breaker: b}
+}
+
+// Returns an error unless subject is a NATS subject events can be published
+// to: dot separated tokens without whitespace, control characters or the
+// wildcards * and >, which would also break the PUB line.
+func validSubject(subject string) error {
+	for _, token := range strings.Split(subject, ".") {
+		if token == "" || strings.ContainsAny(token, "*>") || strings.IndexFunc(token, func(r rune) bool { return r <= ' ' || r == 0x7f }) >= 0 {
+			return fmt.Errorf("invalid nats subject %q", subject)
+		}
+	}
+	return nil
+}
+
+// Creates the queue publishing up to size pending events, named name in
+// its logs, with the publisher, returning nil without a publisher.
+func newEventQueue(name string, publisher EventPublisher, size int) *eventQueue {
+	if publisher == nil {
+		return nil
+	}
+	if size <= 0 {
+		size = 1
+	}
+	q := &eventQueue{name: name, publisher: publisher, events: make(chan queuedEvent, size)}
+	go q.run()
+	return q
+}
+
+// Queues the event without blocking, dropping it when the queue is full.
+func (q *eventQueue) publish(event queuedEvent) {
+	if q == nil {
+		return
+	}
+	select {
+	case q.events <- event:
+	default:
+		dropped := atomic.AddInt64(&q.dropped, 1)
+		now := time.Now().UnixNano()
+		if last := atomic.LoadInt64(&q.warned); now-last >= int64(dropWarnInterval) && atomic.CompareAndSwapInt64(&q.warned, last, now) {
+			logrus.Warnf("%s queue is full, %d events dropped so far.", q.name, dropped)
+		}
+	}
+}
+
+// Publishes queued events one at a time.
+func (q *eventQueue) run() {
+	for event := range q.events {
+		if err := q.publisher.Publish(event); errors.Is(err, errCircuitOpen) {
+			logrus.Debugf(`%s circuit is open, dropping event for "%s".`, q.name, event.machine())
+		} else if err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Warnf(`unable to publish %s for "%s".`, q.name, event.machine())
+		}
+	}
+}
+
+// Returns the MAC address the boot event is about.
+func (e *BootEvent) machine() string {
+	return e.MacAddress
+}
+
+// Publish posts the event to the webhook.
+func (w *webhookPublisher) Publish(event interface{}) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Publish publishes the event unless the breaker is open, recording the
+// outcome with the breaker.
+func (g *guardedPublisher) Publish(event interface{}) error {
+	if !g.breaker.allow() {
+		return errCircuitOpen
+	}
+	if err := g.publisher.Publish(event); err != nil {
+		g.breaker.failure()
+		return err
+	}
+	g.breaker.success()
+	return nil
+}
+
+// Returns the result of a boot decision's status.
+func bootResult(status int) string {
+	switch {
+	case status == http.StatusOK:
+		return ResultBooted
+	case isRedirect(status):
+		return ResultRedirected
+	case status == http.StatusNotFound:
+		return ResultNotFound
+	}
+	return ResultRefused
+}
+
+// Records the boot decision in the audit log and publishes it as a boot
+// event.
+func (s *Spriteful) recordBoot(entry AuditEntry) {
+	s.audit.record(entry)
+	s.events.publish(&BootEvent{
+		Time:       entry.Time.UTC(),
+		MacAddress: entry.MacAddress,
+		Kernel:     entry.Kernel,
+		ClientIP:   entry.ClientIP,
+		Match:      entry.Match,
+		Status:     entry.Status,
+		Result:     bootResult(entry.Status),
+	})
+}
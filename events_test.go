@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+// blockingPublisher records events and blocks until release is closed.
+type blockingPublisher struct {
+	release chan struct{}
+	events  chan *BootEvent
+}
+
+func (p *blockingPublisher) Publish(event interface{}) error {
+	<-p.release
+	p.events <- event.(*BootEvent)
+	return nil
+}
+
+func TestBootEvents(t *testing.T) {
+	events := make(chan BootEvent, 2)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event BootEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer webhook.Close()
+	publisher, err := newEventPublisher(webhook.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Spriteful{Servers: []Server{{MacAddress: validMac, Kernel: "http://images/vmlinuz"}}, events: newEventQueue("boot event", publisher, 8)}
+	serve(s, "GET", "/api/v1/boot/"+validMac, nil)
+	serve(s, "GET", "/api/v1/boot/"+invalidMac, nil)
+	for _, want := range []BootEvent{
+		{MacAddress: validMac, Kernel: "http://images/vmlinuz", Match: MatchExact, Status: http.StatusOK, Result: ResultBooted},
+		{MacAddress: invalidMac, Match: MatchNone, Status: http.StatusNotFound, Result: ResultNotFound},
+	} {
+		select {
+		case event := <-events:
+			if event.MacAddress != want.MacAddress || event.Kernel != want.Kernel || event.Match != want.Match || event.Status != want.Status || event.Result != want.Result || event.Time.IsZero() {
+				t.Errorf("boot event should be %+v, got %+v", want, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("boot event for %s should be published", want.MacAddress)
+		}
+	}
+
+	if _, err := newEventPublisher("kafka://broker:9092", ""); err == nil {
+		t.Error("unsupported events urls should be rejected")
+	}
+	for _, subject := range []string{"", "spriteful boot", "spriteful.boot\r\nPUB other 2", "spriteful.*", "spriteful.>", "spriteful..boot", ".spriteful"} {
+		if _, err := newEventPublisher("nats://localhost", subject); err == nil {
+			t.Errorf("invalid nats subject %q should be rejected", subject)
+		}
+	}
+}
+
+func TestEventQueueFull(t *testing.T) {
+	publisher := &blockingPublisher{release: make(chan struct{}), events: make(chan *BootEvent, 8)}
+	q := newEventQueue("boot event", publisher, 1)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			q.publish(&BootEvent{MacAddress: testMac(i)})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing should never block")
+	}
+	close(publisher.release)
+	if q.dropped < 3 {
+		t.Errorf("events should be dropped while the queue is full, dropped: %d", q.dropped)
+	}
+
+	var nilQueue *eventQueue
+	nilQueue.publish(&BootEvent{})
+}
+
+func TestNATSPublisher(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan string, 3)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "INFO {\"server_id\":\"test\"}\r\n")
+		r := bufio.NewReader(conn)
+		for i := 0; i < 2; i++ {
+			line, _ := r.ReadString('\n')
+			received <- strings.TrimSpace(line)
+		}
+		payload, _ := r.ReadString('\n')
+		received <- strings.TrimSpace(payload)
+		fmt.Fprint(conn, "PING\r\n")
+		pong, _ := r.ReadString('\n')
+		received <- strings.TrimSpace(pong)
+	}()
+
+	u, _ := url.Parse("nats://user:secret@" + listener.Addr().String())
+	publisher := newNATSPublisher(u, "spriteful.boot")
+	if err := publisher.Publish(&BootEvent{MacAddress: validMac, Result: ResultBooted}); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	for i := 0; i < 4; i++ {
+		select {
+		case line := <-received:
+			lines = append(lines, line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("nats server should receive 4 lines, got %q", lines)
+		}
+	}
+	var connect natsConnect
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[0], "CONNECT ")), &connect); err != nil || connect.User != "user" || connect.Password != "secret" {
+		t.Errorf("the publisher should connect with the url credentials, got %s", lines[0])
+	}
+	var event BootEvent
+	if err := json.Unmarshal([]byte(lines[2]), &event); err != nil || lines[1] != fmt.Sprintf("PUB spriteful.boot %d", len(lines[2])) || event.MacAddress != validMac {
+		t.Errorf("the event should be published to the subject, got %q", lines[1:3])
+	}
+	if lines[3] != "PONG" {
+		t.Errorf("pings should be answered, got %s", lines[3])
+	}
+}
+
+func TestNATSServerRequirements(t *testing.T) {
+	for _, test := range []struct {
+		info, url string
+	}{
+		{`{"tls_required":true}`, "nats://user:secret@"},
+		{`{"auth_required":true}`, "nats://"},
+	} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			fmt.Fprintf(conn, "INFO %s\r\n", test.info)
+			ioutil.ReadAll(conn)
+		}()
+		u, _ := url.Parse(test.url + listener.Addr().String())
+		if err := newNATSPublisher(u, "spriteful.boot").Publish(&BootEvent{MacAddress: validMac}); err == nil {
+			t.Errorf("a server sending INFO %s should be refused for %s", test.info, test.url)
+		}
+		listener.Close()
+	}
+}
+
+func TestGuardedPublisher(t *testing.T) {
+	calls := 0
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer webhook.Close()
+
+	publisher := newGuardedPublisher(newWebhookPublisher(webhook.URL), newBreaker("boot events", 1, time.Hour))
+	if err := publisher.Publish(&BootEvent{}); err == nil || errors.Is(err, errCircuitOpen) {
+		t.Errorf("the first failure should be returned, got %v", err)
+	}
+	if err := publisher.Publish(&BootEvent{}); !errors.Is(err, errCircuitOpen) || calls != 1 {
+		t.Errorf("an open breaker should fail events without publishing them, error: %v calls: %d", err, calls)
+	}
+	if plain := newWebhookPublisher(webhook.URL); newGuardedPublisher(plain, nil) != EventPublisher(plain) {
+		t.Error("without a breaker the publisher should be used as is")
+	}
+}
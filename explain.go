@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+// These are the outcomes of an explained resolution step.
+const (
+	StepMatched = "matched"
+	StepMissed  = "missed"
+	StepApplied = "applied"
+	StepSkipped = "skipped"
+)
+
+type (
+	// Explanation describes how a boot request was resolved, answered to
+	// ?explain=true with -debug instead of the boot response.
+	Explanation struct {
+		MacAddress string         `json:"mac"`
+		Match      string         `json:"match"`
+		Steps      []ExplainStep  `json:"steps"`
+		Response   *PixieResponse `json:"response,omitempty"`
+	}
+
+	// ExplainStep is a lookup layer or resolution step that was considered.
+	ExplainStep struct {
+		Step    string `json:"step"`
+		Outcome string `json:"outcome"`
+		Detail  string `json:"detail,omitempty"`
+	}
+)
+
+// explanationKey is the request context key of the explanation being
+// recorded.
+type explanationKey struct{}
+
+// Returns the context recording the resolution in the explanation.
+func withExplanation(ctx context.Context, explanation *Explanation) context.Context {
+	return context.WithValue(ctx, explanationKey{}, explanation)
+}
+
+// Returns the explanation recorded in the context, or nil when the request
+// isn't explained.
+func explanationFrom(ctx context.Context) *Explanation {
+	explanation, _ := ctx.Value(explanationKey{}).(*Explanation)
+	return explanation
+}
+
+// Records a step of the explanation. A nil explanation records nothing.
+func (e *Explanation) step(step, outcome, detail string) {
+	if e == nil {
+		return
+	}
+	e.Steps = append(e.Steps, ExplainStep{Step: step, Outcome: outcome, Detail: detail})
+}
+
+// Records a resolution step, applied when it replaced the server, and
+// returns the server it resulted in.
+func (e *Explanation) resolved(step string, before, after *Server, detail string) *Server {
+	outcome := StepSkipped
+	if after != before {
+		outcome = StepApplied
+	}
+	e.step(step, outcome, detail)
+	return after
+}
+
+// Returns how the boot request for the MAC on the VLAN, 0 for none, is
+// resolved, by looking it up and resolving it through lookupServer and
+// resolveServer with an explanation recording each step. Explained requests
+// have no side effects: nothing is recorded, counted as a boot or sent to
+// the discovery webhook.
+func (s *Spriteful) explain(req *restful.Request, macAddress string, vlan int) (*Explanation, error) {
+	explanation := &Explanation{MacAddress: macKey(macAddress), Match: MatchNone, Steps: []ExplainStep{}}
+	req.Request = req.Request.WithContext(withExplanation(req.Request.Context(), explanation))
+	server, err := s.lookupServer(req.Request.Context(), macAddress, vlan, clientIP(req))
+	if server == nil {
+		if errors.Is(err, ErrStoreUnavailable) || req.Request.Context().Err() != nil {
+			return nil, err
+		}
+		return explanation, nil
+	}
+	explanation.Match = server.match
+
+	server = s.resolveServer(req, server)
+	server = explanation.resolved("header-override", server, s.applyHeaderOverride(req, server), "")
+	if server.DelegateURL != "" {
+		explanation.step("delegate", StepApplied, server.DelegateURL)
+		return explanation, nil
+	}
+	explanation.Response = s.bootResponse(server, req.QueryParameter("arch"), clientIP(req))
+	return explanation, nil
+}
+
+// Answers the boot request with how it would be resolved.
+func (s *Spriteful) writeExplanation(req *restful.Request, res *restful.Response, macAddress string, vlan int) {
+	logrus.Infof(`explaining the boot of "%s".`, macAddress)
+	explanation, err := s.explain(req, macAddress, vlan)
+	if budgetExceeded(req.Request.Context(), err) {
+		writeBootError(res, http.StatusGatewayTimeout, errBudgetExceeded)
+		return
+	}
+	if err != nil {
+		writeBootError(res, http.StatusServiceUnavailable, err)
+		return
+	}
+	res.WriteAsJson(explanation)
+}
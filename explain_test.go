@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+)
+
+func TestExplain(t *testing.T) {
+	s := &Spriteful{
+		Servers:       []Server{{MacAddress: validMac, Group: "rack1", CommandLine: "quiet"}},
+		GroupDefaults: map[string]BootEntry{"rack1": {Kernel: "http://images/rack1.vmlinuz"}},
+		Fallback:      &BootEntry{Kernel: "http://images/rescue.vmlinuz"},
+		stats:         newStats(deterministicTime),
+	}
+	path := "/api/v1/boot/" + validMac + "?explain=true"
+	if res := serve(s, "GET", path, nil); res.Code != http.StatusOK || strings.Contains(res.Body.String(), `"steps"`) {
+		t.Fatalf("explain should be ignored without -debug, status: %d, body: %s", res.Code, res.Body.String())
+	}
+
+	s.debug = true
+	var explanation Explanation
+	res := serve(s, "GET", path, nil)
+	if err := json.Unmarshal(res.Body.Bytes(), &explanation); err != nil {
+		t.Fatal(err)
+	}
+	if explanation.Match != MatchExact || explanation.Response == nil || explanation.Response.Kernel != "http://images/rack1.vmlinuz" {
+		t.Errorf("explanation should report the exact match and the response, got %+v", explanation)
+	}
+	outcomes := map[string]string{}
+	for _, step := range explanation.Steps {
+		outcomes[step.Step] = step.Outcome
+	}
+	if outcomes[MatchPin] != StepMissed || outcomes[MatchExact] != StepMatched || outcomes["group-defaults"] != StepApplied || outcomes["arch-defaults"] != StepSkipped {
+		t.Errorf("explanation should list the steps considered, got %+v", explanation.Steps)
+	}
+	if _, ok := outcomes[MatchFallback]; ok {
+		t.Errorf("layers after the match should not be considered, got %+v", explanation.Steps)
+	}
+
+	res = serve(s, "GET", "/api/v1/boot/"+invalidMac+"?explain=true", nil)
+	json.Unmarshal(res.Body.Bytes(), &explanation)
+	if explanation.Match != MatchFallback || explanation.Response.Kernel != "http://images/rescue.vmlinuz" {
+		t.Errorf("unknown macs should be explained as booted by the fallback, got %+v", explanation)
+	}
+	if counters := s.stats.snapshot(false, deterministicTime).Macs; len(counters) != 1 || counters[validMac].Boots != 1 {
+		t.Errorf("explained requests should not count as boots, stats: %+v", counters)
+	}
+
+	notified := make(chan struct{}, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notified <- struct{}{}
+	}))
+	defer webhook.Close()
+	s.Fallback = nil
+	s.discovery = newDiscovery("http://images/discovery.vmlinuz", newEventQueue("discovery event", newWebhookPublisher(webhook.URL), 8))
+	s.unknown, _ = newUnknownMACs("")
+	res = serve(s, "GET", "/api/v1/boot/"+invalidMac+"?explain=true", nil)
+	json.Unmarshal(res.Body.Bytes(), &explanation)
+	if explanation.Match != MatchDiscovery || explanation.Response.Kernel != "http://images/discovery.vmlinuz" {
+		t.Errorf("unknown macs should be explained as booted by discovery, got %+v", explanation)
+	}
+	select {
+	case <-notified:
+		t.Error("explained requests should not be reported to the discovery webhook")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if unknown := s.unknown.snapshot().Unknown; len(unknown) != 0 {
+		t.Errorf("explained requests should not be recorded as unknown macs, got %+v", unknown)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/emicklei/go-restful"
+)
+
+// These are the firmware types servers can define boot entries for.
+const (
+	FirmwareUEFI = "uefi"
+	FirmwareBIOS = "bios"
+)
+
+// Maps the firmware query parameter to a firmware type. Besides the type
+// names, pixiecore's DHCP client system architectures (RFC 4578) are
+// accepted.
+var firmwareAliases = map[string]string{
+	FirmwareUEFI: FirmwareUEFI,
+	"efi":        FirmwareUEFI,
+	FirmwareBIOS: FirmwareBIOS,
+	"legacy":     FirmwareBIOS,
+	"0":          FirmwareBIOS,
+	"6":          FirmwareUEFI,
+	"7":          FirmwareUEFI,
+	"9":          FirmwareUEFI,
+	"10":         FirmwareUEFI,
+	"11":         FirmwareUEFI,
+}
+
+// Returns the firmware type of the request, or "" when it isn't reported or
+// isn't known.
+func requestFirmware(req *restful.Request) string {
+	return parseFirmware(req.QueryParameter("firmware"))
+}
+
+// Returns the firmware type for the value, or "" when it isn't known.
+func parseFirmware(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if n, err := strconv.Atoi(value); err == nil {
+		value = strconv.Itoa(n)
+	}
+	return firmwareAliases[value]
+}
+
+// Returns the server with its boot entry for the firmware applied, or the
+// server itself when it defines none.
+func (s *Server) forFirmware(firmware string) *Server {
+	if entry, ok := s.Firmware[firmware]; ok && firmware != "" {
+		return entry.apply(s)
+	}
+	return s
+}
+
+// Returns an error if the server defines entries for unknown firmware types.
+func (s *Server) checkFirmware() error {
+	for firmware := range s.Firmware {
+		if firmware != FirmwareUEFI && firmware != FirmwareBIOS {
+			return fmt.Errorf("unsupported firmware %q", firmware)
+		}
+	}
+	return nil
+}
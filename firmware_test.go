@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"encoding/json"
+	"net/http"
+)
+
+func TestFirmwareSelection(t *testing.T) {
+	s := &Spriteful{Servers: []Server{{
+		MacAddress:  validMac,
+		Kernel:      "http://images/vmlinuz",
+		CommandLine: "console=ttyS0",
+		Firmware: map[string]BootEntry{
+			FirmwareUEFI: {Kernel: "http://images/shim.efi"},
+			FirmwareBIOS: {Kernel: "http://images/vmlinuz-bios", CommandLine: "console=tty0"},
+		},
+	}}}
+	tests := []struct {
+		query   string
+		kernel  string
+		cmdline string
+	}{
+		{"?firmware=uefi", "http://images/shim.efi", "console=ttyS0"},
+		{"?firmware=EFI", "http://images/shim.efi", "console=ttyS0"},
+		{"?firmware=7", "http://images/shim.efi", "console=ttyS0"},
+		{"?firmware=bios", "http://images/vmlinuz-bios", "console=tty0"},
+		{"?firmware=0", "http://images/vmlinuz-bios", "console=tty0"},
+		{"?firmware=openfirmware", "http://images/vmlinuz", "console=ttyS0"},
+		{"", "http://images/vmlinuz", "console=ttyS0"},
+	}
+	for _, test := range tests {
+		res := serve(s, "GET", "/api/v1/boot/"+validMac+test.query, nil)
+		if res.Code != http.StatusOK {
+			t.Fatalf("%q should boot, status: %d", test.query, res.Code)
+		}
+		var response PixieResponse
+		json.Unmarshal(res.Body.Bytes(), &response)
+		if response.Kernel != test.kernel || response.CommandLine != test.cmdline {
+			t.Errorf("%q should boot %s %q, got %+v", test.query, test.kernel, test.cmdline, response)
+		}
+	}
+
+	s.Servers[0].Firmware = nil
+	res := serve(s, "GET", "/api/v1/boot/"+validMac+"?firmware=uefi", nil)
+	var response PixieResponse
+	json.Unmarshal(res.Body.Bytes(), &response)
+	if response.Kernel != "http://images/vmlinuz" {
+		t.Errorf("servers without firmware entries should boot their own kernel, got %s", response.Kernel)
+	}
+}
+
+func TestFirmwareValidation(t *testing.T) {
+	server := &Server{MacAddress: validMac, Kernel: "vmlinuz", Firmware: map[string]BootEntry{"arm": {}}}
+	if err := server.validate(""); err == nil {
+		t.Error("unknown firmware types should be rejected")
+	}
+	server.Firmware = map[string]BootEntry{FirmwareUEFI: {Kernel: "shim.efi"}}
+	if err := server.validate(""); err != nil {
+		t.Errorf("uefi entries should be valid, got %v", err)
+	}
+}
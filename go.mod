@@ -1,9 +1,17 @@
 module github.com/engineerang/spriteful
 
-go 1.13
+go 1.16
 
 require (
 	github.com/emicklei/go-restful v2.13.0+incompatible
+	github.com/emicklei/go-restful-openapi v1.4.1
+	github.com/go-openapi/spec v0.0.0-20180415031709-bcff419492ee
+	github.com/go-sql-driver/mysql v1.6.0
 	github.com/json-iterator/go v1.1.10 // indirect
+	github.com/lib/pq v1.10.9
 	github.com/sirupsen/logrus v1.6.0
+	golang.org/x/sys v0.0.0-20190422165155-953cdadca894
+	google.golang.org/grpc v1.38.0
+	google.golang.org/protobuf v1.26.0
+	sigs.k8s.io/yaml v1.2.0
 )
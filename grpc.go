@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+
+	"crypto/tls"
+	"net/http"
+
+	"github.com/engineerang/spriteful/bootpb"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcBootServer serves boot configurations over gRPC, resolving them like
+// REST boot requests.
+type grpcBootServer struct {
+	bootpb.UnimplementedBootServer
+	sprite *Spriteful
+}
+
+// Boot returns the boot configuration for a MAC address, within the
+// request timeout like REST boot requests.
+func (g *grpcBootServer) Boot(ctx context.Context, req *bootpb.BootRequest) (*bootpb.BootResponse, error) {
+	s := g.sprite.withSnapshot()
+	logrus.Info("Received gRPC boot request...")
+	if s.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.requestTimeout)
+		defer cancel()
+	}
+	s.idle.begin()
+	defer func() { s.idle.end(s.now()) }()
+	if s.draining() {
+		return nil, status.Error(codes.Unavailable, errDraining.Error())
+	}
+	ip, _ := grpcPeer(ctx)
+	if err := validMAC(req.GetMac()); err != nil {
+		s.unknown.recordMalformed(req.GetMac(), s.now())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	server, err := s.lookupServer(ctx, req.GetMac(), 0, ip)
+	if budgetExceeded(ctx, err) {
+		return nil, status.Error(codes.DeadlineExceeded, errBudgetExceeded.Error())
+	}
+	if errors.Is(err, ErrStoreUnavailable) {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if err != nil {
+		s.stats.miss(req.GetMac())
+		s.recordBoot(AuditEntry{Time: s.now(), MacAddress: macKey(req.GetMac()), ClientIP: ip, Match: MatchNone, Status: http.StatusNotFound})
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	server = s.resolveFor(req.GetArch(), "", server)
+	if server.DelegateURL != "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is delegated to %s, boot it over REST.", server.MacAddress, server.DelegateURL)
+	}
+	if server.Kernel == LocalBoot {
+		s.recordBoot(AuditEntry{Time: s.now(), MacAddress: macKey(server.MacAddress), Kernel: LocalBoot, ClientIP: ip, Match: server.match, Status: http.StatusNotFound})
+		return nil, status.Error(codes.NotFound, errLocalBoot.Error())
+	}
+	response := s.bootResponse(server, req.GetArch(), ip)
+	s.stats.boot(server.MacAddress, s.now())
+	s.recordBoot(AuditEntry{Time: s.now(), MacAddress: macKey(server.MacAddress), Kernel: response.Kernel, ClientIP: ip, Match: server.match, Status: http.StatusOK})
+	return &bootpb.BootResponse{
+		Kernel:  response.Kernel,
+		Initrd:  response.Initrd,
+		Cmdline: response.CommandLine,
+	}, nil
+}
+
+// WatchConfig sends the current config, then every config published after
+// it until the watcher disconnects or the instance shuts down. A watcher
+// slower than the reloads skips to the latest config once its send returns.
+func (g *grpcBootServer) WatchConfig(req *bootpb.WatchConfigRequest, stream bootpb.Boot_WatchConfigServer) error {
+	logrus.Info("Config watcher connected over gRPC...")
+	defer logrus.Info("Config watcher disconnected.")
+	for {
+		cfg, changed, ok := g.sprite.watchConfig()
+		if !ok {
+			return status.Error(codes.Unavailable, "instance is shutting down, watch another instance.")
+		}
+		data, err := json.Marshal(cfg)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		if err := stream.Send(&bootpb.ConfigUpdate{Hash: cfg.configHash, Config: data}); err != nil {
+			return err
+		}
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// Guards the unary methods, which are boot methods such as Boot, with the
+// boot ACL like the REST boot endpoints.
+func (s *Spriteful) grpcBootInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if ip, state := grpcPeer(ctx); !s.config().ACL.allowsBoot(ip, state) {
+		logrus.Warnf(`gRPC boot call to "%s" from "%s" denied by acl.`, info.FullMethod, ip)
+		return nil, status.Error(codes.PermissionDenied, errForbidden.Error())
+	}
+	return handler(ctx, req)
+}
+
+// Guards the streaming methods, which are admin methods such as
+// WatchConfig, with the admin ACL and token like the REST admin endpoints.
+// The token is sent in the "authorization" metadata as a bearer token.
+func (s *Spriteful) grpcAdminInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ip, state := grpcPeer(stream.Context())
+	if !s.config().ACL.allowsAdmin(ip, state) {
+		logrus.Warnf(`gRPC admin call to "%s" from "%s" denied by acl.`, info.FullMethod, ip)
+		return status.Error(codes.PermissionDenied, errForbidden.Error())
+	}
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+	if !s.adminTokenValid(authorization) {
+		logrus.Warnf(`unauthorized gRPC call to "%s" from "%s".`, info.FullMethod, ip)
+		return status.Error(codes.Unauthenticated, "unauthorized.")
+	}
+	return handler(srv, stream)
+}
+
+// Returns the IP and, over TLS, the connection state of the gRPC call's
+// client.
+func grpcPeer(ctx context.Context) (string, *tls.ConnectionState) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", nil
+	}
+	ip := ""
+	if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+		ip = host
+	}
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		return ip, &info.State
+	}
+	return ip, nil
+}
+
+// Starts the gRPC server on its own port, returning it so it can be stopped
+// on shutdown, along with the address it listens on.
+func (s *Spriteful) startGRPC(address string) (*grpc.Server, net.Addr, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, nil, err
+	}
+	var options []grpc.ServerOption
+	if s.tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(s.tlsConfig)))
+	}
+	options = append(options, grpc.UnaryInterceptor(s.grpcBootInterceptor), grpc.StreamInterceptor(s.grpcAdminInterceptor))
+	server := grpc.NewServer(options...)
+	bootpb.RegisterBootServer(server, &grpcBootServer{sprite: s})
+	go server.Serve(listener)
+	logrus.Infof(`Spriteful gRPC API now listening at "%s".`, listener.Addr())
+	return server, listener.Addr(), nil
+}
+
+// Stops the gRPC server, ending config watches and letting boot requests in
+// flight finish within shutdownTimeout.
+func (s *Spriteful) stopGRPC(server *grpc.Server) {
+	s.closeWatches()
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		logrus.Warn("gRPC shutdown timed out, closing open streams.")
+		server.Stop()
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/engineerang/spriteful/bootpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCBoot(t *testing.T) {
+	s := &Spriteful{Servers: []Server{{MacAddress: validMac, Kernel: "vmlinuz", Initrd: []Initrd{{URL: "initrd"}}, CommandLine: "quiet"}}}
+	server, address, err := s.startGRPC("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, address.String(), grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := bootpb.NewBootClient(conn)
+
+	res, err := client.Boot(ctx, &bootpb.BootRequest{Mac: "00-00-00-00-00-00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Kernel != "vmlinuz" || len(res.Initrd) != 1 || res.Cmdline != "quiet" {
+		t.Errorf("grpc should boot the configured server, got %+v", res)
+	}
+	if _, err := client.Boot(ctx, &bootpb.BootRequest{Mac: invalidMac}); status.Code(err) != codes.NotFound {
+		t.Errorf("unknown macs should be not found, got %v", err)
+	}
+
+	s.ACL = &ACL{}
+	s.ACL.boot, _ = parseCIDRs([]string{"10.1.0.0/16"})
+	if _, err := client.Boot(ctx, &bootpb.BootRequest{Mac: validMac}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("boots from outside the boot acl should be denied, got %v", err)
+	}
+	s.ACL = &ACL{BootClients: []string{"node1"}}
+	if _, err := client.Boot(ctx, &bootpb.BootRequest{Mac: validMac}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("boots without a client certificate should be denied by boot-clients, got %v", err)
+	}
+}
+
+func TestGRPCWatchConfig(t *testing.T) {
+	s := &Spriteful{Servers: []Server{{MacAddress: validMac, Kernel: "vmlinuz"}}, configHash: "first"}
+	s.live = &liveConfig{}
+	s.live.value.Store(s)
+	server, address, err := s.startGRPC("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, address.String(), grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stream, err := bootpb.NewBootClient(conn).WatchConfig(ctx, &bootpb.WatchConfigRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	update, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update.Hash != "first" || !strings.Contains(string(update.Config), `"kernel":"vmlinuz"`) {
+		t.Errorf("watch should start with the current config, got %s %s", update.Hash, update.Config)
+	}
+
+	s.update(func(current *Spriteful) (*Spriteful, error) {
+		next := current.withServers([]Server{{MacAddress: validMac, Kernel: "reloaded"}})
+		next.configHash = "second"
+		return next, nil
+	})
+	if update, err = stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	if update.Hash != "second" || !strings.Contains(string(update.Config), `"kernel":"reloaded"`) {
+		t.Errorf("watch should send the published config, got %s %s", update.Hash, update.Config)
+	}
+
+	s.closeWatches()
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("closed watches should end as unavailable, got %v", err)
+	}
+}
+
+func TestGRPCWatchConfigAuth(t *testing.T) {
+	s := &Spriteful{adminToken: "secret", configHash: "first"}
+	s.live = &liveConfig{}
+	s.live.value.Store(s)
+	server, address, err := s.startGRPC("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, address.String(), grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	watch := func(ctx context.Context) error {
+		stream, err := bootpb.NewBootClient(conn).WatchConfig(ctx, &bootpb.WatchConfigRequest{})
+		if err != nil {
+			return err
+		}
+		_, err = stream.Recv()
+		return err
+	}
+
+	if err := watch(ctx); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("watches without the admin token should be unauthenticated, got %v", err)
+	}
+	if err := watch(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer wrong")); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("watches with a wrong admin token should be unauthenticated, got %v", err)
+	}
+	authorized := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	if err := watch(authorized); err != nil {
+		t.Errorf("watches with the admin token should be served, got %v", err)
+	}
+
+	s.ACL = &ACL{}
+	s.ACL.admin, _ = parseCIDRs([]string{"10.9.0.0/24"})
+	if err := watch(authorized); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("watches from outside the admin acl should be denied, got %v", err)
+	}
+}
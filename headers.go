@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"encoding/json"
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+)
+
+// unsafeHeaders can't be configured: they frame or route the response, set
+// cookies, or are set by Spriteful itself.
+var unsafeHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Encoding":  true,
+	"Content-Length":    true,
+	"Content-Type":      true,
+	"Date":              true,
+	"Keep-Alive":        true,
+	"Location":          true,
+	"Proxy-Connection":  true,
+	"Retry-After":       true,
+	"Set-Cookie":        true,
+	"Te":                true,
+	"Trailer":           true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// Headers are response headers set from the config, by name.
+type Headers map[string]string
+
+// UnmarshalJSON decodes the headers, failing the config load when one is
+// unsafe or malformed.
+func (h *Headers) UnmarshalJSON(data []byte) error {
+	var decoded map[string]string
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	for name, value := range decoded {
+		if err := checkHeader(name, value); err != nil {
+			return err
+		}
+	}
+	*h = decoded
+	return nil
+}
+
+// Returns an error unless the header may be configured.
+func checkHeader(name, value string) error {
+	if name == "" || strings.IndexFunc(name, func(r rune) bool { return r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) }) >= 0 {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	canonical := http.CanonicalHeaderKey(name)
+	if unsafeHeaders[canonical] || strings.HasPrefix(canonical, "Proxy-") || strings.HasPrefix(canonical, "X-Spriteful-") {
+		return fmt.Errorf("header %q can't be configured", name)
+	}
+	if strings.ContainsAny(value, "\r\n\x00") {
+		return fmt.Errorf("invalid value for header %q", name)
+	}
+	return nil
+}
+
+// Sets the headers on the response, replacing any set before.
+func (h Headers) set(res *restful.Response) {
+	for name, value := range h {
+		res.Header().Set(name, value)
+	}
+}
+
+// Returns the headers of base and main by name, main's winning.
+func mergeHeaders(base, main Headers) Headers {
+	if base == nil {
+		return main
+	}
+	merged := make(Headers, len(base)+len(main))
+	for name, value := range base {
+		merged[http.CanonicalHeaderKey(name)] = value
+	}
+	for name, value := range main {
+		merged[http.CanonicalHeaderKey(name)] = value
+	}
+	return merged
+}
+
+// Sets the configured global headers on every response. Boot responses
+// then set the server's own headers over them.
+func (s *Spriteful) headersFilter(req *restful.Request, res *restful.Response, chain *restful.FilterChain) {
+	s.config().Headers.set(res)
+	chain.ProcessFilter(req, res)
+}
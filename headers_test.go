@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"net/http"
+)
+
+func TestHeaders(t *testing.T) {
+	config := `{
+		"headers": {"x-content-type-options": "nosniff", "X-Frame-Options": "DENY"},
+		"servers": [{"mac": "00:00:00:00:00:00", "kernel": "vmlinuz", "headers": {"X-Frame-Options": "SAMEORIGIN"}}]
+	}`
+	s, err := decodeConfig(strings.NewReader(config), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := serve(s, "GET", "/api/v1/boot/"+validMac, nil)
+	if res.Code != http.StatusOK || res.Header().Get("X-Content-Type-Options") != "nosniff" || res.Header().Get("X-Frame-Options") != "SAMEORIGIN" {
+		t.Errorf("boot responses should get the global headers under the server's, got %v", res.Header())
+	}
+	for _, path := range []string{"/api/v1/boot/" + invalidMac, "/healthz"} {
+		res = serve(s, "GET", path, nil)
+		if res.Header().Get("X-Content-Type-Options") != "nosniff" || res.Header().Get("X-Frame-Options") != "DENY" {
+			t.Errorf("%s should get the global headers, got %v", path, res.Header())
+		}
+	}
+	if res := serve(s, "GET", "/healthz", nil); res.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("global headers should leave the content type alone, got %q", res.Header().Get("Content-Type"))
+	}
+
+	for _, unsafe := range []string{
+		`{"headers": {"content-length": "0"}}`,
+		`{"headers": {"Transfer-Encoding": "chunked"}}`,
+		`{"headers": {"X-Spriteful-Config-Hash": "forged"}}`,
+		`{"headers": {"Bad Name": "x"}}`,
+		`{"headers": {"X-Injected": "a\r\nSet-Cookie: b"}}`,
+		`{"servers": [{"mac": "00:00:00:00:00:00", "kernel": "vmlinuz", "headers": {"Set-Cookie": "session=1"}}]}`,
+	} {
+		if _, err := decodeConfig(strings.NewReader(unsafe), false); err == nil {
+			t.Errorf("%s should fail the config load", unsafe)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+// version is the Spriteful version reported at the root, set at build time
+// with -ldflags "-X main.version=...".
+var version = "dev"
+
+type (
+	// HealthStatus is the body of the health and readiness endpoints.
+	HealthStatus struct {
+		Status     string                  `json:"status"`
+		Reason     string                  `json:"reason,omitempty"`
+		ConfigHash string                  `json:"config-hash,omitempty"`
+		Origins    map[string]OriginStatus `json:"origins,omitempty"`
+		Store      *StoreStatus            `json:"store,omitempty"`
+	}
+
+	// ServiceInfo is the body of the root endpoint.
+	ServiceInfo struct {
+		Service string `json:"service"`
+		Version string `json:"version"`
+	}
+)
+
+// Registers the health and readiness endpoints.
+func (s *Spriteful) registerHealth(container *restful.Container) {
+	ws := &restful.WebService{}
+	ws.Path("/").Produces(restful.MIME_JSON)
+
+	ws.Route(ws.GET("healthz").To(s.handleHealthRequest).
+		Doc("liveness probe").
+		Param(ws.QueryParameter("deep", "also check that kernel origins are reachable").DataType("boolean")).
+		Writes(HealthStatus{}).
+		Returns(http.StatusOK, "alive", HealthStatus{}).
+		Returns(http.StatusServiceUnavailable, "a kernel origin or the server store is unreachable", HealthStatus{}))
+	ws.Route(ws.GET("readyz").To(s.handleReadyRequest).
+		Doc("readiness probe").
+		Writes(HealthStatus{}).
+		Returns(http.StatusOK, "ready", HealthStatus{}).
+		Returns(http.StatusServiceUnavailable, "degraded", HealthStatus{}))
+	logrus.Info(`health endpoints created at "healthz" and "readyz".`)
+
+	ws.Route(ws.GET("/").To(handleRootRequest).
+		Doc("service name and version").
+		Writes(ServiceInfo{}).
+		Returns(http.StatusOK, "service info", ServiceInfo{}))
+	ws.Route(ws.GET("favicon.ico").To(handleFaviconRequest).
+		Doc("empty favicon for browsers").
+		Returns(http.StatusNoContent, "no favicon", nil))
+
+	container.Add(ws)
+}
+
+// Handles the liveness probe, reporting the store backend's health. With
+// deep=true, the origins of the configured kernels are checked as well and
+// an unreachable store backend is unhealthy.
+func (s *Spriteful) handleHealthRequest(req *restful.Request, res *restful.Response) {
+	status := &HealthStatus{Status: "ok", ConfigHash: s.config().configHash}
+	if store, ok := s.serverStore().(StoreHealth); ok {
+		health := store.Health()
+		status.Store = &health
+	}
+	if req.QueryParameter("deep") != "true" || s.deepCheck == nil {
+		res.WriteAsJson(status)
+		return
+	}
+	if status.Store != nil && !status.Store.OK {
+		status.Status = "unhealthy"
+		status.Reason = "server store unreachable"
+		res.WriteHeaderAndJson(http.StatusServiceUnavailable, status, restful.MIME_JSON)
+		return
+	}
+	status.Origins = s.deepCheck.check(withBaseURL(s.serverStore().List(), s.config().BaseURL), s.now())
+	for _, origin := range status.Origins {
+		if !origin.OK {
+			status.Status = "unhealthy"
+			status.Reason = "kernel origin unreachable"
+			res.WriteHeaderAndJson(http.StatusServiceUnavailable, status, restful.MIME_JSON)
+			return
+		}
+	}
+	res.WriteAsJson(status)
+}
+
+// Handles the readiness probe, reporting draining while the instance is
+// drained and degraded when no request can be booted.
+func (s *Spriteful) handleReadyRequest(req *restful.Request, res *restful.Response) {
+	if s.draining() {
+		res.WriteHeaderAndJson(http.StatusServiceUnavailable, &HealthStatus{Status: "draining", Reason: "instance is drained"}, restful.MIME_JSON)
+		return
+	}
+	if reason := s.degraded(); reason != "" {
+		res.WriteHeaderAndJson(http.StatusServiceUnavailable, &HealthStatus{Status: "degraded", Reason: reason}, restful.MIME_JSON)
+		return
+	}
+	res.WriteAsJson(&HealthStatus{Status: "ready"})
+}
+
+// Returns why the API can't boot anything, or an empty string if it can.
+// Stores reporting their health are judged by it rather than listed, so
+// probes don't query the backend.
+func (s *Spriteful) degraded() string {
+	if store, ok := s.serverStore().(StoreHealth); ok {
+		if !store.Health().OK {
+			return "server store unreachable"
+		}
+		return ""
+	}
+	if s.allowEmpty {
+		return ""
+	}
+	if len(s.serverStore().List()) == 0 && s.config().Fallback == nil && (s.discovery == nil || s.discovery.image == "") {
+		return "no servers configured and no fallback or discovery image set"
+	}
+	return ""
+}
+
+// Warns loudly when the loaded config can't boot anything.
+func (s *Spriteful) warnIfEmpty() {
+	if reason := s.degraded(); reason != "" {
+		logrus.Warnf("!!! %s, every boot request will 404. Use -allow-empty-config if this is intended. !!!", reason)
+	}
+}
+
+// Handles the http request for the root, so browsers and scanners get a
+// short description instead of a 404.
+func handleRootRequest(req *restful.Request, res *restful.Response) {
+	res.WriteAsJson(&ServiceInfo{Service: "spriteful", Version: version})
+}
+
+// Handles the http request for the favicon browsers ask for.
+func handleFaviconRequest(req *restful.Request, res *restful.Response) {
+	res.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"os/exec"
+
+	"github.com/sirupsen/logrus"
+)
+
+// responseHook pipes rendered boot responses through an external command,
+// whose stdout replaces the response. Failures fall back to the response as
+// rendered.
+type responseHook struct {
+	command string
+	timeout time.Duration
+}
+
+// Creates the response hook, returning nil when no command is configured.
+func newResponseHook(command string, timeout time.Duration) *responseHook {
+	if command == "" {
+		return nil
+	}
+	return &responseHook{command: command, timeout: timeout}
+}
+
+// Returns the body transformed by the hook command, which gets the body on
+// stdin and the MAC address and content type in SPRITEFUL_MAC and
+// SPRITEFUL_CONTENT_TYPE, with no other environment than PATH. The body is
+// returned as is without a hook, or when the command fails, times out or
+// writes nothing. The command is killed at the hook timeout or when the
+// context is done, whichever comes first.
+func (h *responseHook) transform(ctx context.Context, body, macAddress, contentType string) string {
+	if h == nil {
+		return body
+	}
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, h.command)
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "SPRITEFUL_MAC=" + macAddress, "SPRITEFUL_CONTENT_TYPE=" + contentType}
+	out, stderr, err := runHook(ctx, cmd, body)
+	if err != nil {
+		fields := logrus.Fields{logrus.ErrorKey: err}
+		if err != context.DeadlineExceeded {
+			fields["stderr"] = strings.TrimSpace(stderr)
+		}
+		logrus.WithFields(fields).Warnf(`response hook failed for "%s", sending the response untransformed.`, macAddress)
+		return body
+	}
+	if len(out) == 0 {
+		logrus.Warnf(`response hook wrote nothing for "%s", sending the response untransformed.`, macAddress)
+		return body
+	}
+	return string(out)
+}
+
+// Runs the hook command with stdin as its input, returning its stdout and
+// stderr. The output is read from pipes of our own rather than through
+// exec's copying goroutines, which wait for every process holding the pipes:
+// when the context is done the command is killed and the pipes are closed,
+// so children it left behind can't leak the goroutines reading them.
+func runHook(ctx context.Context, cmd *exec.Cmd, stdin string) ([]byte, string, error) {
+	input, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, "", err
+	}
+	stdout, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return nil, "", err
+	}
+	defer stdout.Close()
+	stderr, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdoutWriter.Close()
+		return nil, "", err
+	}
+	defer stderr.Close()
+	cmd.Stdout, cmd.Stderr = stdoutWriter, stderrWriter
+	err = cmd.Start()
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	go func() {
+		// Wait closes stdin once the command exits, ending a blocked write.
+		io.WriteString(input, stdin)
+		input.Close()
+	}()
+
+	var out, errOut bytes.Buffer
+	var reads sync.WaitGroup
+	reads.Add(2)
+	go func() {
+		defer reads.Done()
+		out.ReadFrom(stdout)
+	}()
+	go func() {
+		defer reads.Done()
+		errOut.ReadFrom(stderr)
+	}()
+	read := make(chan struct{})
+	go func() {
+		reads.Wait()
+		close(read)
+	}()
+
+	select {
+	case <-read:
+	case <-ctx.Done():
+		stdout.Close()
+		stderr.Close()
+		<-read
+	}
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return nil, errOut.String(), ctx.Err()
+	}
+	return out.Bytes(), errOut.String(), err
+}
@@ -0,0 +1,70 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+)
+
+// Writes an executable shell script to dir.
+func writeHook(t *testing.T, dir, name, script string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestResponseHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spriteful-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &Spriteful{Servers: []Server{{MacAddress: validMac, Kernel: "http://images/vmlinuz"}}}
+	s.responseHook = newResponseHook(writeHook(t, dir, "upper", `tr a-z A-Z; printf "$SPRITEFUL_MAC"`), time.Second)
+	want := `{"KERNEL":"HTTP://IMAGES/VMLINUZ","INITRD":NULL,"CMDLINE":""}` + validMac
+	if res := serve(s, "GET", "/api/v1/boot/"+validMac, nil); res.Code != http.StatusOK || res.Body.String() != want {
+		t.Errorf("responses should be transformed by the hook, status: %d body: %s", res.Code, res.Body.String())
+	}
+	if res := serve(s, "GET", "/api/v1/boot/"+validMac+"?format=ipxe", nil); res.Body.String() != "#!IPXE\nKERNEL HTTP://IMAGES/VMLINUZ\nBOOT\n"+validMac {
+		t.Errorf("ipxe scripts should be transformed by the hook, body: %s", res.Body.String())
+	}
+
+	untransformed := `{"kernel":"http://images/vmlinuz","initrd":null,"cmdline":""}`
+	hooks := map[string]string{
+		"failing": "echo partial; exit 1",
+		"empty":   "cat > /dev/null",
+		"slow":    "sleep 5",
+	}
+	for name, script := range hooks {
+		s.responseHook = newResponseHook(writeHook(t, dir, name, script), 100*time.Millisecond)
+		if res := serve(s, "GET", "/api/v1/boot/"+validMac, nil); res.Body.String() != untransformed {
+			t.Errorf("a %s hook should fall back to the untransformed response, body: %s", name, res.Body.String())
+		}
+	}
+
+	before := runtime.NumGoroutine()
+	s.responseHook = newResponseHook(writeHook(t, dir, "orphaning", "sleep 5 & sleep 5"), 100*time.Millisecond)
+	serve(s, "GET", "/api/v1/boot/"+validMac, nil)
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if leaked := runtime.NumGoroutine() - before; leaked > 0 {
+		t.Errorf("a timed out hook should not leave goroutines behind, leaked: %d", leaked)
+	}
+
+	if newResponseHook("", time.Second) != nil {
+		t.Error("no hook should be created without a command")
+	}
+}
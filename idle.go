@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// idleCheckInterval is how often an idle tracker is checked, at most.
+const idleCheckInterval = time.Second
+
+// idleTracker tracks boot requests to shut ephemeral instances down once
+// none came for the timeout. A nil idleTracker is never idle.
+type idleTracker struct {
+	mu       sync.Mutex
+	timeout  time.Duration
+	interval time.Duration
+	last     time.Time
+	inFlight int
+}
+
+// Creates a tracker idle from now, or nil if timeout isn't positive.
+func newIdleTracker(timeout time.Duration, now time.Time) *idleTracker {
+	if timeout <= 0 {
+		return nil
+	}
+	interval := idleCheckInterval
+	if timeout < interval {
+		interval = timeout
+	}
+	return &idleTracker{timeout: timeout, interval: interval, last: now}
+}
+
+// Records the start of a boot request.
+func (t *idleTracker) begin() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.inFlight++
+	t.mu.Unlock()
+}
+
+// Records the end of a boot request, the instance being idle from now.
+func (t *idleTracker) end(now time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.inFlight--
+	t.last = now
+	t.mu.Unlock()
+}
+
+// Reports whether no boot request is in flight and none ended within the
+// timeout.
+func (t *idleTracker) idle(now time.Time) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.inFlight == 0 && now.Sub(t.last) >= t.timeout
+}
+
+// Returns a channel closed once the instance is idle, checked against the
+// Spriteful clock, or nil, which never fires, without an idle timeout.
+// Checking stops when stop is closed.
+func (s *Spriteful) idleShutdown(stop <-chan struct{}) <-chan struct{} {
+	if s.idle == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(s.idle.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if s.idle.idle(s.now()) {
+					close(done)
+					return
+				}
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	var mu sync.Mutex
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		now = now.Add(d)
+		mu.Unlock()
+	}
+	s := &Spriteful{
+		Servers: []Server{{MacAddress: validMac, Kernel: "vmlinuz"}},
+		clock:   clock,
+		idle:    newIdleTracker(time.Minute, clock()),
+	}
+
+	advance(59 * time.Second)
+	if s.idle.idle(s.now()) {
+		t.Error("instance shouldn't be idle before the timeout")
+	}
+	serve(s, "GET", "/api/v1/boot/"+validMac, nil)
+	advance(59 * time.Second)
+	if s.idle.idle(s.now()) {
+		t.Error("a boot request should reset the idle timeout")
+	}
+
+	s.idle.begin()
+	advance(time.Hour)
+	if s.idle.idle(s.now()) {
+		t.Error("instance shouldn't be idle with a boot request in flight")
+	}
+	s.idle.end(s.now())
+	advance(time.Minute)
+	if !s.idle.idle(s.now()) {
+		t.Error("instance should be idle once the timeout passes")
+	}
+
+	s.idle.interval = time.Millisecond
+	stop := make(chan struct{})
+	defer close(stop)
+	select {
+	case <-s.idleShutdown(stop):
+	case <-time.After(5 * time.Second):
+		t.Error("an idle instance should be shut down")
+	}
+}
+
+func TestIdleTimeoutDisabled(t *testing.T) {
+	s := &Spriteful{idle: newIdleTracker(0, time.Now())}
+	if s.idle != nil || s.idle.idle(time.Now().Add(time.Hour)) {
+		t.Error("a zero idle timeout should disable the tracker")
+	}
+	if s.idleShutdown(nil) != nil {
+		t.Error("without an idle timeout the instance should never shut down")
+	}
+}
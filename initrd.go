@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"encoding/json"
+)
+
+// Initrd is an initrd URL. In the config it is either a plain URL string or
+// an object with the URL and its flags. Optional initrds are left out of
+// iPXE scripts when -verify-assets finds them unreachable.
+type Initrd struct {
+	URL      string `json:"url"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// UnmarshalJSON accepts both the string and the object form.
+func (i *Initrd) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		var url string
+		if err := json.Unmarshal(data, &url); err != nil {
+			return fmt.Errorf("initrd must be a string or an object: %v", err)
+		}
+		*i = Initrd{URL: url}
+		return nil
+	}
+
+	// The alias drops the methods so the object is decoded field by field.
+	type initrd Initrd
+	var entry initrd
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return err
+	}
+	*i = Initrd(entry)
+	return nil
+}
+
+// MarshalJSON writes the plain string form unless a flag is set, so configs
+// without flags are saved as they were written.
+func (i Initrd) MarshalJSON() ([]byte, error) {
+	if !i.Optional {
+		return json.Marshal(i.URL)
+	}
+	type initrd Initrd
+	return json.Marshal(initrd(i))
+}
+
+// Returns the URLs of the initrds.
+func initrdURLs(initrds []Initrd) []string {
+	if initrds == nil {
+		return nil
+	}
+	urls := make([]string, len(initrds))
+	for i, initrd := range initrds {
+		urls[i] = initrd.URL
+	}
+	return urls
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"encoding/json"
+)
+
+func TestInitrdUnmarshal(t *testing.T) {
+	var server Server
+	data := []byte(`{"initrd": ["http://images/base.img", {"url": "http://images/overlay.img", "optional": true}]}`)
+	if err := json.Unmarshal(data, &server); err != nil {
+		t.Fatal(err)
+	}
+	want := []Initrd{{URL: "http://images/base.img"}, {URL: "http://images/overlay.img", Optional: true}}
+	if !reflect.DeepEqual(server.Initrd, want) {
+		t.Errorf("both initrd forms should decode, got %+v", server.Initrd)
+	}
+
+	saved, err := json.Marshal(server.Initrd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `["http://images/base.img",{"url":"http://images/overlay.img","optional":true}]`; string(saved) != want {
+		t.Errorf("initrds without flags should be saved as strings, got %s", saved)
+	}
+	if err := json.Unmarshal([]byte(`{"initrd": [1]}`), &server); err == nil {
+		t.Errorf("a numeric initrd should not decode")
+	}
+}
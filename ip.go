@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+// Handles the http request for server boot configuration keyed on IP
+// address. The IP is mapped to a MAC, which then resolves like a MAC boot
+// request.
+func (s *Spriteful) handleIPBootRequest(req *restful.Request, res *restful.Response) {
+	logrus.Info("Received pixiecore ip request...")
+	s = s.withSnapshot()
+	ip := net.ParseIP(req.PathParameter("ip"))
+	if ip == nil {
+		writeBootError(res, http.StatusBadRequest, fmt.Errorf(`malformed ip address "%s".`, req.PathParameter("ip")))
+		return
+	}
+	macAddress, err := s.macForIP(ip)
+	if err != nil {
+		writeBootError(res, http.StatusNotFound, err)
+		return
+	}
+	logrus.Infof(`ip "%s" maps to "%s".`, ip, macAddress)
+	s.bootMAC(req, res, macAddress, 0)
+}
+
+// Returns the MAC of the IP: the first server configured with it, else the
+// MAC of its DHCP lease.
+func (s *Spriteful) macForIP(ip net.IP) (string, error) {
+	for _, server := range s.serverStore().List() {
+		if serverIP := net.ParseIP(server.IP); serverIP != nil && serverIP.Equal(ip) {
+			return server.MacAddress, nil
+		}
+	}
+	if macAddress, ok := s.config().leaseMACs[ip.String()]; ok {
+		return macAddress, nil
+	}
+	return "", fmt.Errorf("no mac address mapped to %s.", ip)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"encoding/json"
+	"net/http"
+)
+
+func TestIPBoot(t *testing.T) {
+	s := &Spriteful{Servers: []Server{
+		{MacAddress: validMac, Kernel: "configured", IP: "10.0.0.5"},
+		{MacAddress: "aa:bb:*", Kernel: "wildcard"},
+	}}
+	s.applyLeases([]Lease{{IP: "10.0.0.6", MacAddress: "aa:bb:00:00:00:01"}})
+	cases := map[string]string{
+		"10.0.0.5":        "configured",
+		"::ffff:10.0.0.5": "configured",
+		"10.0.0.6":        "wildcard",
+	}
+	for ip, kernel := range cases {
+		var response PixieResponse
+		res := serve(s, "GET", "/api/v1/boot/ip/"+ip, nil)
+		json.Unmarshal(res.Body.Bytes(), &response)
+		if res.Code != http.StatusOK || response.Kernel != kernel {
+			t.Errorf("%s should boot %s, status: %d kernel: %s", ip, kernel, res.Code, response.Kernel)
+		}
+	}
+	if res := serve(s, "GET", "/api/v1/boot/ip/10.0.0.7", nil); res.Code != http.StatusNotFound {
+		t.Errorf("an unmapped ip should not be found, status: %d", res.Code)
+	}
+	if res := serve(s, "GET", "/api/v1/boot/ip/not-an-ip", nil); res.Code != http.StatusBadRequest {
+		t.Errorf("a malformed ip should be a bad request, status: %d", res.Code)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+// IPXEContentType is the content type of iPXE boot scripts.
+const IPXEContentType = "text/x-ipxe"
+
+// verifyClient checks optional initrds with -verify-assets.
+var verifyClient = &http.Client{Timeout: 2 * time.Second}
+
+// Reports whether the request asks for an iPXE script instead of the
+// pixiecore JSON response, with ?format=ipxe or an Accept header.
+func wantsIPXE(req *restful.Request) bool {
+	if format := req.QueryParameter("format"); format != "" {
+		return format == "ipxe"
+	}
+	return strings.Contains(req.HeaderParameter("Accept"), IPXEContentType)
+}
+
+// scriptWriter writes iPXE script lines, keeping the first write error and
+// skipping every line after it.
+type scriptWriter struct {
+	w   io.Writer
+	err error
+}
+
+// Writes a script line.
+func (sw *scriptWriter) line(format string, args ...interface{}) {
+	if sw.err != nil {
+		return
+	}
+	_, sw.err = fmt.Fprintf(sw.w, format+"\n", args...)
+}
+
+// Reports whether iPXE scripts are streamed to the client as they are
+// rendered. Signed responses need the whole body for the signature header,
+// the response hook transforms it and debug logging logs it, so they all
+// buffer the script.
+func (s *Spriteful) streamsIPXE() bool {
+	return s.signingKey == nil && s.responseHook == nil && !logrus.IsLevelEnabled(logrus.DebugLevel)
+}
+
+// Returns an error unless order lists every index below count exactly once.
+func checkInitrdOrder(order []int, count int) error {
+	if len(order) != count {
+		return fmt.Errorf("ipxe initrd order lists %d initrds, expected %d", len(order), count)
+	}
+	seen := make([]bool, count)
+	for _, i := range order {
+		if i < 0 || i >= count {
+			return fmt.Errorf("ipxe initrd order index %d is out of range", i)
+		}
+		if seen[i] {
+			return errors.New("ipxe initrd order lists an index twice")
+		}
+		seen[i] = true
+	}
+	return nil
+}
+
+// Returns the response's initrd URLs and the server's initrd flags in the
+// server's iPXE initrd order. Orders that don't match the resolved initrds,
+// e.g. replaced by a maintenance window, are ignored with a warning.
+func ipxeInitrds(response *PixieResponse, server *Server) ([]string, []Initrd) {
+	urls, initrds := response.Initrd, server.Initrd
+	if server.IPXEInitrdOrder == nil {
+		return urls, initrds
+	}
+	if err := checkInitrdOrder(server.IPXEInitrdOrder, len(urls)); err != nil || len(initrds) != len(urls) {
+		logrus.Warnf(`ignoring the ipxe initrd order of "%s", it doesn't match its %d initrds.`, server.MacAddress, len(urls))
+		return urls, initrds
+	}
+	ordered := make([]string, len(urls))
+	orderedFlags := make([]Initrd, len(urls))
+	for i, index := range server.IPXEInitrdOrder {
+		ordered[i], orderedFlags[i] = urls[index], initrds[index]
+	}
+	return ordered, orderedFlags
+}
+
+// Returns the whole seconds iPXE scripts sleep between retries, rounded up.
+// Invalid delays fall back to retrying without sleeping.
+func (s *Spriteful) ipxeRetryDelay() int {
+	delay := s.RetryDelay
+	if delay == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(delay)
+	if err != nil || d < 0 {
+		logrus.Warnf(`invalid retry delay "%s", iPXE scripts retry without sleeping.`, delay)
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ipxeEscaper keeps iPXE from expanding settings and splitting commands in
+// echoed text: "${}" expands to nothing, so it is inserted after every "$",
+// between the characters of "||" and "&&" and as a placeholder for
+// whitespace characters other than spaces.
+var ipxeEscaper = strings.NewReplacer("$", "$${}", "|", "|${}", "&", "&${}", "\t", " ", "\r", "")
+
+// Returns the banner lines of the global and the server's banner, escaped
+// for echo.
+func (s *Spriteful) ipxeBanner(server *Server) []string {
+	var lines []string
+	for _, banner := range []string{s.Banner, server.Banner} {
+		if banner == "" {
+			continue
+		}
+		for _, line := range strings.Split(banner, "\n") {
+			lines = append(lines, ipxeEscaper.Replace(line))
+		}
+	}
+	return lines
+}
+
+// Renders the boot response for the server as an iPXE script, see
+// writeIPXE.
+func (s *Spriteful) encodeIPXE(ctx context.Context, response *PixieResponse, server *Server) string {
+	var script strings.Builder
+	s.writeIPXE(ctx, &script, response, server)
+	return script.String()
+}
+
+// Writes the boot response for the server as an iPXE script to w line by
+// line, so probing optional initrds never holds the whole script in memory.
+// Initrds are loaded in the server's iPXE initrd order and, with
+// -verify-assets, optional initrds that are unreachable, or not probed
+// before the context is done, are left out. With a retry
+// count, the images are loaded and booted in a loop retried that many times.
+// The global and server banners are echoed first.
+// Returns the first write error, after which nothing else is written.
+func (s *Spriteful) writeIPXE(ctx context.Context, w io.Writer, response *PixieResponse, server *Server) error {
+	cfg := s.config()
+	urls, initrds := ipxeInitrds(response, server)
+	script := &scriptWriter{w: w}
+	script.line("#!ipxe")
+	for _, line := range cfg.ipxeBanner(server) {
+		script.line("echo %s", line)
+	}
+	onError := ""
+	if cfg.RetryCount > 0 {
+		script.line("set retries:int32 0")
+		script.line(":retry")
+		onError = " || goto failed"
+	}
+	if response.CommandLine != "" {
+		script.line("kernel %s %s%s", response.Kernel, response.CommandLine, onError)
+	} else {
+		script.line("kernel %s%s", response.Kernel, onError)
+	}
+	for i, url := range urls {
+		if script.err != nil {
+			break
+		}
+		if s.verifyAssets && initrds[i].Optional {
+			if err := probeAsset(ctx, verifyClient, url); err != nil {
+				logrus.WithField(logrus.ErrorKey, err).Debugf(`skipping unreachable optional initrd "%s".`, url)
+				continue
+			}
+		}
+		script.line("initrd %s%s", url, onError)
+	}
+	script.line("boot%s", onError)
+	if cfg.RetryCount > 0 {
+		script.line(":failed")
+		script.line("iseq ${retries} %d && goto giveup ||", cfg.RetryCount)
+		script.line("inc retries")
+		if delay := cfg.ipxeRetryDelay(); delay > 0 {
+			script.line("sleep %d", delay)
+		}
+		script.line("imgfree")
+		script.line("goto retry")
+		script.line(":giveup")
+		script.line("echo boot failed after %d retries", cfg.RetryCount)
+		script.line("exit 1")
+	}
+	return script.err
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/emicklei/go-restful"
+)
+
+func TestIPXEResponse(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.img" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer origin.Close()
+
+	s := &Spriteful{
+		Servers: []Server{{
+			MacAddress:  validMac,
+			Kernel:      "http://images/vmlinuz",
+			CommandLine: "console=ttyS0",
+			Initrd: []Initrd{
+				{URL: origin.URL + "/base.img"},
+				{URL: origin.URL + "/overlay.img", Optional: true},
+				{URL: origin.URL + "/missing.img", Optional: true},
+			},
+		}},
+		verifyAssets: true,
+	}
+	want := "#!ipxe\nkernel http://images/vmlinuz console=ttyS0\ninitrd " + origin.URL + "/base.img\ninitrd " + origin.URL + "/overlay.img\nboot\n"
+	res := serve(s, "GET", "/api/v1/boot/00:00:00:00:00:00?format=ipxe", nil)
+	if res.Code != http.StatusOK || res.Body.String() != want {
+		t.Errorf("unreachable optional initrds should be skipped, status: %d body:\n%s", res.Code, res.Body.String())
+	}
+	res = serve(s, "GET", "/api/v1/boot/00:00:00:00:00:00", http.Header{"Accept": {IPXEContentType}})
+	if res.Header().Get("Content-Type") != IPXEContentType {
+		t.Errorf("an ipxe accept header should get a script, content type: %s", res.Header().Get("Content-Type"))
+	}
+
+	var response PixieResponse
+	res = serve(s, "GET", "/api/v1/boot/00:00:00:00:00:00", nil)
+	json.Unmarshal(res.Body.Bytes(), &response)
+	if len(response.Initrd) != 3 {
+		t.Errorf("json responses should list every initrd, initrd: %v", response.Initrd)
+	}
+}
+
+// failingWriter fails every write after the first n bytes.
+type failingWriter struct {
+	n       int
+	written strings.Builder
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.written.Len()+len(p) > w.n {
+		return 0, errors.New("connection reset")
+	}
+	return w.written.Write(p)
+}
+
+// writeCounter records a response and counts the writes reaching it.
+type writeCounter struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (r *writeCounter) Write(p []byte) (int, error) {
+	r.writes++
+	return r.ResponseRecorder.Write(p)
+}
+
+func TestIPXEStreaming(t *testing.T) {
+	s := &Spriteful{Servers: []Server{{
+		MacAddress:  validMac,
+		Kernel:      "http://images/vmlinuz",
+		Initrd:      []Initrd{{URL: "http://images/a.img"}, {URL: "http://images/b.img"}},
+		CommandLine: "quiet",
+	}}}
+	res := serve(s, "GET", "/api/v1/boot/"+validMac+"?format=ipxe", nil)
+	want := "#!ipxe\nkernel http://images/vmlinuz quiet\ninitrd http://images/a.img\ninitrd http://images/b.img\nboot\n"
+	if res.Code != http.StatusOK || res.Body.String() != want {
+		t.Errorf("the streamed script should match the rendered one, status: %d body: %q", res.Code, res.Body)
+	}
+
+	s.requestTimeout = time.Minute
+	c := restful.NewContainer()
+	s.register(c)
+	streamed := &writeCounter{ResponseRecorder: httptest.NewRecorder()}
+	normalizePath(c).ServeHTTP(streamed, httptest.NewRequest("GET", "/api/v1/boot/"+validMac+"?format=ipxe", nil))
+	if streamed.Body.String() != want || streamed.writes < 5 {
+		t.Errorf("the script should be streamed line by line past the request timeout, writes: %d body: %q", streamed.writes, streamed.Body)
+	}
+
+	response := &PixieResponse{Kernel: "http://images/vmlinuz", Initrd: []string{"http://images/a.img", "http://images/b.img"}}
+	w := &failingWriter{n: 40}
+	if err := s.writeIPXE(context.Background(), w, response, &s.Servers[0]); err == nil {
+		t.Fatal("a failed write should be returned")
+	}
+	if got := w.written.String(); got != "#!ipxe\nkernel http://images/vmlinuz\n" {
+		t.Errorf("nothing should be written after a failed write, got %q", got)
+	}
+}
+
+func TestIPXEInitrdOrder(t *testing.T) {
+	s := &Spriteful{Servers: []Server{{
+		MacAddress:      validMac,
+		Kernel:          "http://images/vmlinuz",
+		Initrd:          []Initrd{{URL: "http://images/base.img"}, {URL: "http://images/overlay.img"}},
+		IPXEInitrdOrder: []int{1, 0},
+	}}}
+	res := serve(s, "GET", "/api/v1/boot/"+validMac+"?format=ipxe", nil)
+	want := "#!ipxe\nkernel http://images/vmlinuz\ninitrd http://images/overlay.img\ninitrd http://images/base.img\nboot\n"
+	if res.Body.String() != want {
+		t.Errorf("iPXE scripts should follow the ipxe initrd order, got %q", res.Body)
+	}
+	var response PixieResponse
+	json.Unmarshal(serve(s, "GET", "/api/v1/boot/"+validMac, nil).Body.Bytes(), &response)
+	if len(response.Initrd) != 2 || response.Initrd[0] != "http://images/base.img" {
+		t.Errorf("JSON responses should keep the initrd order, got %v", response.Initrd)
+	}
+
+	for _, order := range [][]int{{0}, {0, 2}, {1, 1}, {-1, 0}} {
+		server := s.Servers[0]
+		server.IPXEInitrdOrder = order
+		if err := server.validate(""); err == nil {
+			t.Errorf("ipxe initrd order %v should be rejected", order)
+		}
+	}
+	if err := s.Servers[0].validate(""); err != nil {
+		t.Errorf("ipxe initrd order [1 0] should be valid, got %v", err)
+	}
+}
+
+func TestIPXERetries(t *testing.T) {
+	s := &Spriteful{
+		Servers: []Server{{
+			MacAddress: validMac,
+			Kernel:     "http://images/vmlinuz",
+			Initrd:     []Initrd{{URL: "http://images/initrd"}},
+		}},
+		RetryCount: 3,
+		RetryDelay: "1500ms",
+	}
+	want := "#!ipxe\nset retries:int32 0\n:retry\n" +
+		"kernel http://images/vmlinuz || goto failed\ninitrd http://images/initrd || goto failed\nboot || goto failed\n" +
+		":failed\niseq ${retries} 3 && goto giveup ||\ninc retries\nsleep 2\nimgfree\ngoto retry\n" +
+		":giveup\necho boot failed after 3 retries\nexit 1\n"
+	res := serve(s, "GET", "/api/v1/boot/"+validMac+"?format=ipxe", nil)
+	if res.Code != http.StatusOK || res.Body.String() != want {
+		t.Errorf("scripts should retry with a retry count, status: %d body:\n%s", res.Code, res.Body.String())
+	}
+
+	s.RetryDelay = "bogus"
+	if res := serve(s, "GET", "/api/v1/boot/"+validMac+"?format=ipxe", nil); strings.Contains(res.Body.String(), "sleep") {
+		t.Errorf("invalid delays should retry without sleeping, body:\n%s", res.Body.String())
+	}
+
+	var response map[string]interface{}
+	res = serve(s, "GET", "/api/v1/boot/"+validMac, nil)
+	json.Unmarshal(res.Body.Bytes(), &response)
+	if len(response) != 3 {
+		t.Errorf("json responses should ignore retries, got %s", res.Body.String())
+	}
+}
+
+func TestIPXEBanner(t *testing.T) {
+	s := &Spriteful{
+		Servers: []Server{{
+			MacAddress: validMac,
+			Kernel:     "http://images/vmlinuz",
+			Banner:     "recovery boot: ${ticket} || reboot",
+		}},
+		Banner: "maintenance until 18:00\nask #ops",
+	}
+	want := "#!ipxe\necho maintenance until 18:00\necho ask #ops\necho recovery boot: $${}{ticket} |${}|${} reboot\nkernel http://images/vmlinuz\nboot\n"
+	res := serve(s, "GET", "/api/v1/boot/"+validMac+"?format=ipxe", nil)
+	if res.Body.String() != want {
+		t.Errorf("banners should be echoed escaped before booting, body:\n%s", res.Body.String())
+	}
+
+	var response map[string]interface{}
+	res = serve(s, "GET", "/api/v1/boot/"+validMac, nil)
+	json.Unmarshal(res.Body.Bytes(), &response)
+	if len(response) != 3 {
+		t.Errorf("json responses should ignore banners, got %s", res.Body.String())
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Lease is a DHCP lease read from an ISC dhcpd lease file.
+type Lease struct {
+	IP         string
+	MacAddress string
+	Hostname   string
+}
+
+// Parses an ISC dhcpd lease file. Only "lease <ip> { ... }" blocks with a
+// "hardware ethernet" statement are kept. dhcpd appends a new block for an
+// IP whenever its lease changes, so only the last block of each IP counts,
+// and it is skipped when its binding state is set to anything but active:
+// a lease freed, expired or released after it was active is gone. Of the
+// remaining leases, the last lease of a MAC wins.
+func parseLeases(r io.Reader) ([]Lease, error) {
+	type block struct {
+		lease  Lease
+		active bool
+	}
+	var blocks []block
+	last := make(map[string]int)
+	var current *block
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		fields := strings.Fields(strings.TrimSuffix(line, ";"))
+		switch {
+		case len(fields) == 3 && fields[0] == "lease" && fields[2] == "{":
+			current = &block{lease: Lease{IP: fields[1]}, active: true}
+		case current == nil:
+		case line == "}":
+			last[current.lease.IP] = len(blocks)
+			blocks = append(blocks, *current)
+			current = nil
+		case len(fields) == 3 && fields[0] == "hardware" && fields[1] == "ethernet":
+			current.lease.MacAddress = fields[2]
+		case len(fields) == 2 && fields[0] == "client-hostname":
+			current.lease.Hostname = strings.Trim(fields[1], `"`)
+		case len(fields) == 3 && fields[0] == "binding" && fields[1] == "state":
+			current.active = fields[2] == "active"
+		}
+	}
+
+	var leases []Lease
+	index := make(map[string]int)
+	for i, block := range blocks {
+		if last[block.lease.IP] != i || !block.active || block.lease.MacAddress == "" {
+			continue
+		}
+		key := macKey(block.lease.MacAddress)
+		if j, ok := index[key]; ok {
+			leases[j] = block.lease
+		} else {
+			index[key] = len(leases)
+			leases = append(leases, block.lease)
+		}
+	}
+	return leases, scanner.Err()
+}
+
+// Reads the lease file and applies it to the config.
+func (s *Spriteful) loadLeases(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	leases, err := parseLeases(file)
+	if err != nil {
+		return err
+	}
+	s.applyLeases(leases)
+	logrus.Infof(`%d leases loaded from "%s".`, len(leases), path)
+	return nil
+}
+
+// Fills the hostname and IP of configured servers from their leases, leaving
+// values set in the config alone. Leased MACs missing from the config get a
+// server booting the lease defaults, if set. Every lease is also kept for
+// booting by IP. Must be called before the config is published.
+func (s *Spriteful) applyLeases(leases []Lease) {
+	servers := make(map[string]int)
+	for i, server := range s.Servers {
+		if server.VLAN == 0 {
+			servers[macKey(server.MacAddress)] = i
+		}
+	}
+	s.leaseMACs = make(map[string]string)
+	for _, lease := range leases {
+		if ip := net.ParseIP(lease.IP); ip != nil {
+			s.leaseMACs[ip.String()] = lease.MacAddress
+		}
+		i, ok := servers[macKey(lease.MacAddress)]
+		if !ok {
+			if s.LeaseDefaults == nil {
+				continue
+			}
+			server := s.LeaseDefaults.apply(&Server{MacAddress: lease.MacAddress})
+			server.leased = true
+			s.Servers = append(s.Servers, *server)
+			i = len(s.Servers) - 1
+		}
+		server := &s.Servers[i]
+		if server.Hostname == "" && lease.Hostname != "" {
+			server.Hostname, server.leaseHostname = lease.Hostname, true
+		}
+		if server.IP == "" && lease.IP != "" {
+			server.IP, server.leaseIP = lease.IP, true
+		}
+	}
+	s.buildIndex()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"encoding/json"
+	"io/ioutil"
+)
+
+const testLeases = `# The format of this file is documented in the dhcpd.leases(5) manual page.
+lease 10.0.0.10 {
+  starts 4 2020/01/02 03:04:05;
+  binding state active;
+  hardware ethernet 00:00:00:00:00:00;
+  client-hostname "node0";
+}
+lease 10.0.0.11 {
+  binding state free;
+  hardware ethernet 00:00:00:00:00:01;
+}
+lease 10.0.0.12 {
+  hardware ethernet 00:00:00:00:00:02;
+  client-hostname "old";
+}
+lease 10.0.0.13 {
+  hardware ethernet 00:00:00:00:00:02;
+  client-hostname "node2";
+}
+`
+
+func TestApplyLeases(t *testing.T) {
+	leases, err := parseLeases(strings.NewReader(testLeases))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leases) != 2 || leases[1].IP != "10.0.0.13" || leases[1].Hostname != "node2" {
+		t.Fatalf("active leases with the last lease per mac are expected, leases: %+v", leases)
+	}
+
+	released, err := parseLeases(strings.NewReader(testLeases + `
+lease 10.0.0.20 {
+  binding state active;
+  hardware ethernet 00:00:00:00:00:03;
+  client-hostname "node3";
+}
+lease 10.0.0.20 {
+  binding state free;
+  hardware ethernet 00:00:00:00:00:03;
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(released) != 2 {
+		t.Errorf("a lease freed after it was active should be dropped, leases: %+v", released)
+	}
+
+	s := &Spriteful{
+		Servers:       []Server{{MacAddress: validMac, Kernel: "vmlinuz", Hostname: "configured"}},
+		LeaseDefaults: &BootEntry{Kernel: "leased.vmlinuz"},
+	}
+	s.applyLeases(leases)
+	if len(s.Servers) != 2 || s.Servers[0].Hostname != "configured" || s.Servers[0].IP != "10.0.0.10" {
+		t.Errorf("configured servers should only be enriched, servers: %+v", s.Servers)
+	}
+	server, err := s.findServerConfig("00:00:00:00:00:02")
+	if err != nil || server.Kernel != "leased.vmlinuz" || server.Hostname != "node2" {
+		t.Errorf("leased macs should boot the lease defaults, got %+v", server)
+	}
+
+	path := writeTestConfig(t, 0, "")
+	defer os.Remove(path)
+	if err := s.saveConfig(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	var saved Spriteful
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Servers) != 1 || saved.Servers[0].Hostname != "configured" || saved.Servers[0].IP != "" {
+		t.Errorf("saved configs should keep configured values and drop leased ones, servers: %+v", saved.Servers)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// unixPrefix marks bind hosts that are Unix socket paths.
+const unixPrefix = "unix:"
+
+// limitWarnInterval is how often reaching the connection limit is logged.
+const limitWarnInterval = time.Minute
+
+type (
+	// limitListener accepts at most cap(slots) simultaneous connections.
+	// Further connections wait in the accept queue until one is closed.
+	limitListener struct {
+		net.Listener
+		slots  chan struct{}
+		warned time.Time
+	}
+
+	// limitConn frees its listener slot when closed.
+	limitConn struct {
+		net.Conn
+		release sync.Once
+		slots   chan struct{}
+	}
+)
+
+// Returns the listener limited to max simultaneous connections, or the
+// listener itself if max isn't positive.
+func limitListen(listener net.Listener, max int) net.Listener {
+	if max <= 0 {
+		return listener
+	}
+	return &limitListener{Listener: listener, slots: make(chan struct{}, max)}
+}
+
+// Accept waits for a free slot, logging at most every limitWarnInterval
+// that the limit was reached, then accepts the next connection. Only the
+// http.Server accept loop calls it, so warned needs no lock.
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		if time.Since(l.warned) >= limitWarnInterval {
+			l.warned = time.Now()
+			logrus.Warnf("connection limit of %d reached, new connections wait until one closes.", cap(l.slots))
+		}
+		l.slots <- struct{}{}
+	}
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	return &limitConn{Conn: conn, slots: l.slots}, nil
+}
+
+// Close closes the connection and frees its slot.
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.release.Do(func() { <-c.slots })
+	return err
+}
+
+// Returns the address to listen at for the bind host and port. IPv6 hosts
+// may be written with or without brackets ("::1" or "[::1]"). Unix socket
+// hosts (unix:/path/to/sock) are returned as is and the port is ignored.
+func joinBindAddress(host string, port int) string {
+	if strings.HasPrefix(host, unixPrefix) {
+		return host
+	}
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// Returns an error if the bind host is a hostname that doesn't resolve.
+// Empty hosts (all interfaces), IP addresses and Unix socket paths are
+// never looked up.
+func resolveBindHost(host string) error {
+	if host == "" || strings.HasPrefix(host, unixPrefix) {
+		return nil
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		host = host[:i]
+	}
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("%s has no addresses", host)
+	}
+	return nil
+}
+
+// Opens the API listener. With reusePort, SO_REUSEPORT is set so several
+// instances can share the port, and a positive backlog replaces the default
+// accept queue length (the kernel still caps it, e.g. at net.core.somaxconn).
+// Both fall back to the defaults with a warning where unsupported. Unix
+// socket addresses ignore both; a stale socket left at the path is replaced,
+// and the socket file is removed when the listener is closed.
+func listen(address string, reusePort bool, backlog int) (net.Listener, error) {
+	if strings.HasPrefix(address, unixPrefix) {
+		return listenUnix(strings.TrimPrefix(address, unixPrefix), reusePort || backlog > 0)
+	}
+	config := net.ListenConfig{}
+	if reusePort {
+		config.Control = func(network, address string, conn syscall.RawConn) error {
+			var sockErr error
+			if err := conn.Control(func(fd uintptr) { sockErr = setReusePort(fd) }); err != nil {
+				return err
+			}
+			return sockErr
+		}
+	}
+	listener, err := config.Listen(context.Background(), "tcp", address)
+	if err != nil || backlog <= 0 {
+		return listener, err
+	}
+	if err := setBacklog(listener.(*net.TCPListener), backlog); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Listens on the Unix socket at path, removing a socket file left behind by
+// an instance that didn't shut down cleanly. Sockets still accepting
+// connections and other files at path are kept and fail the listen.
+func listenUnix(path string, tuned bool) (net.Listener, error) {
+	if tuned {
+		logrus.Warn("-reuseport and -listen-backlog don't apply to unix sockets, ignoring them.")
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unix socket %s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
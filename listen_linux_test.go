@@ -0,0 +1,25 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"testing"
+)
+
+func TestListenReusePort(t *testing.T) {
+	first, err := listen("127.0.0.1:0", true, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := listen(first.Addr().String(), true, 16)
+	if err != nil {
+		t.Fatalf("a second reuseport listener should share the port: %v", err)
+	}
+	second.Close()
+	if third, err := listen(first.Addr().String(), false, 0); err == nil {
+		third.Close()
+		t.Errorf("a listener without reuseport should not share the port")
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package main
+
+import (
+	"net"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SO_REUSEPORT isn't supported here, so the port is not shared.
+func setReusePort(fd uintptr) error {
+	logrus.Warn("-reuseport is not supported on this platform, ignoring it.")
+	return nil
+}
+
+// The backlog can't be tuned here, so the default is kept.
+func setBacklog(listener *net.TCPListener, backlog int) error {
+	logrus.Warn("-listen-backlog is not supported on this platform, ignoring it.")
+	return nil
+}
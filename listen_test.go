@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+
+	"github.com/emicklei/go-restful"
+)
+
+func TestJoinBindAddress(t *testing.T) {
+	cases := map[string]string{
+		"0.0.0.0":                  "0.0.0.0:5000",
+		"::":                       "[::]:5000",
+		"::1":                      "[::1]:5000",
+		"[::1]":                    "[::1]:5000",
+		"fe80::1%lo":               "[fe80::1%lo]:5000",
+		"":                         ":5000",
+		"unix:/run/spriteful.sock": "unix:/run/spriteful.sock",
+	}
+	for host, want := range cases {
+		if got := joinBindAddress(host, 5000); got != want {
+			t.Errorf("%q should bind %s, got %s", host, want, got)
+		}
+	}
+}
+
+func TestListenIPv6(t *testing.T) {
+	listener, err := listen(joinBindAddress("[::1]", 0), false, 0)
+	if err != nil {
+		t.Skipf("ipv6 loopback is unavailable: %v", err)
+	}
+	defer listener.Close()
+	s := &Spriteful{Servers: []Server{{MacAddress: validMac, Kernel: "vmlinuz"}}}
+	c := restful.NewContainer()
+	s.register(c)
+	go http.Serve(listener, c)
+
+	res, err := http.Get("http://" + listener.Addr().String() + "/api/v1/boot/" + validMac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("boot requests should be served over ipv6, status: %d", res.StatusCode)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spriteful-socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spriteful.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets are unavailable: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := listen(joinBindAddress("unix:"+path, 5000), false, 0)
+	if err != nil {
+		t.Fatalf("a stale socket should be replaced, but it wasn't: %v", err)
+	}
+	if _, err := listen("unix:"+path, false, 0); err == nil {
+		t.Errorf("a socket in use should not be replaced")
+	}
+	s := &Spriteful{Servers: []Server{{MacAddress: validMac, Kernel: "vmlinuz"}}}
+	c := restful.NewContainer()
+	s.register(c)
+	go http.Serve(listener, c)
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	res, err := client.Get("http://spriteful/api/v1/boot/" + validMac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("boot requests should be served over the unix socket, status: %d", res.StatusCode)
+	}
+	listener.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the socket file should be removed on close, err: %v", err)
+	}
+}
+
+func TestLimitListen(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := limitListen(inner, 1)
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+
+	first := <-accepted
+	select {
+	case <-accepted:
+		t.Fatal("a second connection should wait while the limit is reached")
+	case <-time.After(100 * time.Millisecond):
+	}
+	first.Close()
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(time.Second):
+		t.Error("a waiting connection should be accepted once a slot frees")
+	}
+	if limitListen(inner, 0) != inner {
+		t.Error("no limit should keep the listener")
+	}
+}
+
+func TestStalledAndIdleConnections(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Spriteful{
+		Servers:           []Server{{MacAddress: validMac, Kernel: "vmlinuz"}},
+		readHeaderTimeout: 50 * time.Millisecond,
+		keepAliveTimeout:  50 * time.Millisecond,
+	}
+	c := restful.NewContainer()
+	s.register(c)
+	server := s.httpServer(inner.Addr().String(), c)
+	go server.Serve(limitListen(inner, 1))
+	defer server.Close()
+
+	stalled, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+	for i := 0; i < 2; i++ {
+		// Each client keeps its connection alive and idle after booting.
+		transport := &http.Transport{}
+		defer transport.CloseIdleConnections()
+		client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+		res, err := client.Get("http://" + inner.Addr().String() + "/api/v1/boot/" + validMac)
+		if err != nil {
+			t.Fatalf("stalled and idle connections should be closed to free their slot: %v", err)
+		}
+		res.Body.Close()
+	}
+}
+
+func TestResolveBindHost(t *testing.T) {
+	for _, host := range []string{"", "0.0.0.0", "::", "[::1]", "fe80::1%lo", "unix:/run/spriteful.sock", "localhost"} {
+		if err := resolveBindHost(host); err != nil {
+			t.Errorf("%q should be accepted, got %v", host, err)
+		}
+	}
+	if err := resolveBindHost("spriteful.invalid"); err == nil {
+		t.Error("a hostname that doesn't resolve should fail")
+	}
+}
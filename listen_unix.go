@@ -0,0 +1,28 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package main
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// Sets SO_REUSEPORT on the socket.
+func setReusePort(fd uintptr) error {
+	return unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+}
+
+// Calls listen again on the listening socket, which updates its backlog.
+func setBacklog(listener *net.TCPListener, backlog int) error {
+	conn, err := listener.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var listenErr error
+	if err := conn.Control(func(fd uintptr) { listenErr = unix.Listen(int(fd), backlog) }); err != nil {
+		return err
+	}
+	return listenErr
+}
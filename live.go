@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// errReloading is the boot error while a quiesced reload is in progress.
+	errReloading = errors.New("config reload in progress, retry shortly.")
+
+	// errDraining is the boot error while the instance is drained.
+	errDraining = errors.New("instance is draining, retry on another instance.")
+)
+
+// liveConfig holds the config snapshot requests are served from. Snapshots
+// are immutable once published, so readers load them without locking and a
+// reload is a single pointer swap. reloading is set while a quiesced reload
+// is in progress and draining while the instance is drained. changed is
+// closed when the next snapshot is published, waking up config watchers.
+type liveConfig struct {
+	value     atomic.Value
+	mu        sync.Mutex
+	changed   chan struct{}
+	closed    bool
+	reloading int32
+	draining  int32
+}
+
+// Returns the current config snapshot, or the snapshot the view was pinned
+// to by withSnapshot.
+func (s *Spriteful) config() *Spriteful {
+	if s.snapshot != nil {
+		return s.snapshot
+	}
+	if s.live != nil {
+		if cfg, ok := s.live.value.Load().(*Spriteful); ok {
+			return cfg
+		}
+	}
+	return s
+}
+
+// Returns a view serving every config read from the current snapshot, so a
+// request sees a single config even when a reload publishes another while it
+// is being served.
+func (s *Spriteful) withSnapshot() *Spriteful {
+	if s.snapshot != nil {
+		return s
+	}
+	view := *s
+	view.snapshot = s.config()
+	return &view
+}
+
+// Publishes the config returned by fn, which receives the current snapshot
+// and must not modify it. Updates are serialized.
+func (s *Spriteful) update(fn func(current *Spriteful) (*Spriteful, error)) error {
+	if s.live == nil {
+		// Only happens before serving, main publishes the initial config.
+		s.live = &liveConfig{}
+	}
+	s.live.mu.Lock()
+	defer s.live.mu.Unlock()
+	next, err := fn(s.config())
+	if err != nil {
+		return err
+	}
+	s.live.value.Store(next)
+	if s.live.changed != nil {
+		close(s.live.changed)
+		s.live.changed = nil
+	}
+	return nil
+}
+
+// Returns the current config snapshot along with a channel closed once
+// another is published, or false once watches are closed for shutdown.
+func (s *Spriteful) watchConfig() (*Spriteful, <-chan struct{}, bool) {
+	if s.live == nil {
+		// Nothing is ever published without a live config.
+		return s, nil, true
+	}
+	s.live.mu.Lock()
+	defer s.live.mu.Unlock()
+	if s.live.closed {
+		return nil, nil, false
+	}
+	if s.live.changed == nil {
+		s.live.changed = make(chan struct{})
+	}
+	return s.config(), s.live.changed, true
+}
+
+// Wakes up and ends every config watch, and the ones started afterwards.
+func (s *Spriteful) closeWatches() {
+	if s.live == nil {
+		return
+	}
+	s.live.mu.Lock()
+	defer s.live.mu.Unlock()
+	s.live.closed = true
+	if s.live.changed != nil {
+		close(s.live.changed)
+		s.live.changed = nil
+	}
+}
+
+// Returns a copy of the config snapshot with the servers replaced and
+// indexed. The copy is detached from the live config and any pinned
+// snapshot, so it only ever reads itself.
+func (s *Spriteful) withServers(servers []Server) *Spriteful {
+	next := *s
+	next.live, next.snapshot = nil, nil
+	next.Servers = servers
+	next.buildIndex()
+	return &next
+}
+
+// Marks a reload as in progress when -reload-quiesce is set, returning the
+// func that clears the mark.
+func (s *Spriteful) quiesce() func() {
+	if !s.reloadQuiesce || s.live == nil {
+		return func() {}
+	}
+	atomic.StoreInt32(&s.live.reloading, 1)
+	return func() { atomic.StoreInt32(&s.live.reloading, 0) }
+}
+
+// Answers boot requests with a 503 and a Retry-After header while a quiesced
+// reload is in progress or the instance is drained.
+func (s *Spriteful) quiesceFilter(req *restful.Request, res *restful.Response, chain *restful.FilterChain) {
+	if s.draining() {
+		res.Header().Set("Retry-After", "5")
+		writeBootError(res, http.StatusServiceUnavailable, errDraining)
+		return
+	}
+	if s.live != nil && atomic.LoadInt32(&s.live.reloading) == 1 {
+		res.Header().Set("Retry-After", "1")
+		writeBootError(res, http.StatusServiceUnavailable, errReloading)
+		return
+	}
+	chain.ProcessFilter(req, res)
+}
+
+// Reports whether the instance is drained.
+func (s *Spriteful) draining() bool {
+	return s.live != nil && atomic.LoadInt32(&s.live.draining) == 1
+}
+
+// Handles the http request draining the instance: new boot requests get a
+// 503 and readyz reports not ready, while requests in flight finish.
+func (s *Spriteful) handleDrainRequest(req *restful.Request, res *restful.Response) {
+	s.setDraining(true)
+	logrus.Warn("instance drained, new boot requests are refused.")
+	res.WriteAsJson(&HealthStatus{Status: "draining"})
+}
+
+// Handles the http request undraining the instance.
+func (s *Spriteful) handleUndrainRequest(req *restful.Request, res *restful.Response) {
+	s.setDraining(false)
+	logrus.Info("instance undrained, boot requests are served again.")
+	res.WriteAsJson(&HealthStatus{Status: "ready"})
+}
+
+// Sets or clears the drain flag.
+func (s *Spriteful) setDraining(draining bool) {
+	if s.live == nil {
+		// Only happens before serving, main publishes the initial config.
+		s.live = &liveConfig{}
+	}
+	var value int32
+	if draining {
+		value = 1
+	}
+	atomic.StoreInt32(&s.live.draining, value)
+}
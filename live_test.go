@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// Writes a config holding count servers to a temporary file.
+func writeTestConfig(t testing.TB, count int, kernel string) string {
+	file, err := ioutil.TempFile("", "spriteful-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	fmt.Fprint(file, `{"servers": [`)
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			fmt.Fprint(file, ",")
+		}
+		fmt.Fprintf(file, `{"mac": "%s", "kernel": "%s"}`, testMac(i), kernel)
+	}
+	fmt.Fprint(file, `]}`)
+	return file.Name()
+}
+
+func TestReload(t *testing.T) {
+	path := writeTestConfig(t, 1, "old")
+	defer os.Remove(path)
+	s := &Spriteful{configPath: path}
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+	old := s.config()
+	if server, err := s.findServerConfig(testMac(0)); err != nil || server.Kernel != "old" {
+		t.Fatalf("loaded config should be served, got %+v", server)
+	}
+
+	ioutil.WriteFile(path, []byte(`{"servers": [{"mac": "`+testMac(0)+`", "kernel": "new"}]}`), 0644)
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if server, err := s.findServerConfig(testMac(0)); err != nil || server.Kernel != "new" {
+		t.Errorf("reloaded config should be served, got %+v", server)
+	}
+	if old.Servers[0].Kernel != "old" {
+		t.Errorf("published snapshots should never be modified")
+	}
+
+	view := s.withSnapshot()
+	next := view.withServers([]Server{{MacAddress: testMac(0), Kernel: "derived"}})
+	if next.config() != next || next.config().Servers[0].Kernel != "derived" {
+		t.Errorf("derived snapshots should only read themselves")
+	}
+	if s.config().Servers[0].Kernel != "new" {
+		t.Errorf("deriving a snapshot should not change the live config")
+	}
+}
+
+func TestReloadMissingFile(t *testing.T) {
+	path := writeTestConfig(t, 1, "old")
+	s := &Spriteful{configPath: path}
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path)
+	hook := test.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	s.reloadOrKeep()
+	entry := hook.LastEntry()
+	if entry == nil || entry.Level != logrus.ErrorLevel || entry.Data["phase"] != "reload" {
+		t.Errorf("a missing file should be logged as a failed reload, got %+v", entry)
+	} else if err, _ := entry.Data[logrus.ErrorKey].(error); !os.IsNotExist(err) {
+		t.Errorf("the missing file error should be logged, got %v", entry.Data[logrus.ErrorKey])
+	}
+	if server, err := s.findServerConfig(testMac(0)); err != nil || server.Kernel != "old" {
+		t.Errorf("the current config should keep serving, got %+v", server)
+	}
+}
+
+func TestReloadQuiesce(t *testing.T) {
+	path := writeTestConfig(t, 1, "old")
+	defer os.Remove(path)
+	s := &Spriteful{configPath: path, reloadQuiesce: true}
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if res := serve(s, "GET", "/api/v1/boot/"+testMac(0), nil); res.Code != http.StatusOK {
+		t.Errorf("boot requests should be served once the reload is done, status: %d", res.Code)
+	}
+
+	done := s.quiesce()
+	res := serve(s, "GET", "/api/v1/boot/"+testMac(0), nil)
+	if res.Code != http.StatusServiceUnavailable || res.Header().Get("Retry-After") == "" {
+		t.Errorf("boot requests should get a 503 with Retry-After during a reload, status: %d", res.Code)
+	}
+	done()
+
+	s.reloadQuiesce = false
+	s.quiesce()
+	if res := serve(s, "GET", "/api/v1/boot/"+testMac(0), nil); res.Code != http.StatusOK {
+		t.Errorf("reloads should not quiesce by default, status: %d", res.Code)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	s := &Spriteful{Servers: []Server{{MacAddress: validMac, Kernel: "vmlinuz"}}}
+	if res := serve(s, "POST", "/api/v1/drain", nil); res.Code != http.StatusOK {
+		t.Fatalf("drain should succeed, status: %d", res.Code)
+	}
+	res := serve(s, "GET", "/api/v1/boot/"+validMac, nil)
+	if res.Code != http.StatusServiceUnavailable || res.Header().Get("Retry-After") == "" {
+		t.Errorf("boot requests should get a 503 with Retry-After while drained, status: %d", res.Code)
+	}
+	if res := serve(s, "GET", "/readyz", nil); res.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz should not be ready while drained, status: %d", res.Code)
+	}
+
+	serve(s, "POST", "/api/v1/undrain", nil)
+	if res := serve(s, "GET", "/api/v1/boot/"+validMac, nil); res.Code != http.StatusOK {
+		t.Errorf("boot requests should be served once undrained, status: %d", res.Code)
+	}
+	if res := serve(s, "GET", "/readyz", nil); res.Code != http.StatusOK {
+		t.Errorf("readyz should be ready once undrained, status: %d", res.Code)
+	}
+}
+
+// Returns a config of generation gen, in which every part of a boot
+// response names the generation.
+func generationConfig(t *testing.T, gen int) *Spriteful {
+	config := fmt.Sprintf(`{
+		"base-url": "http://gen%[1]d/",
+		"default-initrd": ["initrd-%[1]d"],
+		"servers": [{"mac": "%[2]s", "kernel": "vmlinuz-%[1]d", "cmdline": "gen=%[1]d"}]
+	}`, gen, testMac(0))
+	cfg, err := decodeConfig(strings.NewReader(config), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestReloadDuringBootRequests(t *testing.T) {
+	generations := []*Spriteful{generationConfig(t, 0), generationConfig(t, 1)}
+	s := &Spriteful{}
+	s.update(func(*Spriteful) (*Spriteful, error) { return generations[0], nil })
+	c := restful.NewContainer()
+	s.register(c)
+	handler := normalizePath(c)
+
+	level := logrus.GetLevel()
+	logrus.SetLevel(logrus.ErrorLevel)
+	defer logrus.SetLevel(level)
+
+	done := make(chan struct{})
+	reloads := make(chan struct{})
+	go func() {
+		defer close(reloads)
+		for i := 1; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			next := generations[i%2]
+			s.update(func(*Spriteful) (*Spriteful, error) { return next, nil })
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				res := httptest.NewRecorder()
+				handler.ServeHTTP(res, httptest.NewRequest("GET", "/api/v1/boot/"+testMac(0), nil))
+				var response PixieResponse
+				if res.Code != http.StatusOK || json.Unmarshal(res.Body.Bytes(), &response) != nil {
+					t.Errorf("boot requests should be served during reloads, status: %d body: %s", res.Code, res.Body.String())
+					return
+				}
+				gen := strings.TrimPrefix(response.CommandLine, "gen=")
+				kernel := "http://gen" + gen + "/vmlinuz-" + gen
+				initrd := "http://gen" + gen + "/initrd-" + gen
+				if gen == "" || response.Kernel != kernel || len(response.Initrd) != 1 || response.Initrd[0] != initrd {
+					t.Errorf("boot responses should come from a single config, got %+v", response)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	<-reloads
+
+	// Publish another generation every time the request reads the clock, so a
+	// reload always lands between its config reads.
+	s.update(func(*Spriteful) (*Spriteful, error) { return generations[0], nil })
+	flips := 0
+	s.clock = func() time.Time {
+		flips++
+		next := generations[flips%2]
+		s.update(func(*Spriteful) (*Spriteful, error) { return next, nil })
+		return time.Now()
+	}
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest("GET", "/api/v1/boot/"+testMac(0), nil))
+	var response PixieResponse
+	json.Unmarshal(res.Body.Bytes(), &response)
+	if flips == 0 || response.Kernel != "http://gen0/vmlinuz-0" || len(response.Initrd) != 1 || response.Initrd[0] != "http://gen0/initrd-0" {
+		t.Errorf("a reload during a request should not change its config, got %+v", response)
+	}
+}
+
+func BenchmarkFindServerDuringReload(b *testing.B) {
+	path := writeTestConfig(b, 1000, "vmlinuz")
+	defer os.Remove(path)
+	s := &Spriteful{configPath: path}
+	if err := s.reload(); err != nil {
+		b.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				s.reload()
+			}
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := s.findServerConfig(testMac(i % 1000)); err != nil {
+				b.Error(err)
+			}
+			i++
+		}
+	})
+	b.StopTimer()
+	close(done)
+}
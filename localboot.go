@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strconv"
+
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+// LocalBoot is the kernel of servers that must boot from their local disk
+// instead of the network.
+const LocalBoot = "local"
+
+// localBootScript boots iPXE clients from the first BIOS disk, and makes
+// those that can't, such as UEFI clients, exit to the next boot device.
+const localBootScript = "#!ipxe\nsanboot --no-describe --drive 0x80 || exit\n"
+
+// errLocalBoot is the boot error sent to pixiecore for local disk boots,
+// which pixiecore answers by ignoring the machine.
+var errLocalBoot = errors.New("booting from local disk.")
+
+// Answers a boot request for a server booting from local disk: iPXE clients
+// get a script continuing from the local disk, and pixiecore a 404, its
+// documented way of not netbooting a machine.
+func (s *Spriteful) writeLocalBoot(req *restful.Request, res *restful.Response, server *Server) {
+	logrus.Infof(`booting "%s" from local disk.`, server.MacAddress)
+	status := http.StatusNotFound
+	if server.sendsIPXE(req) {
+		status = http.StatusOK
+		logBootResponse(status, localBootScript)
+		res.Header().Set("Content-Type", IPXEContentType)
+		if s.signingKey != nil {
+			res.Header().Set(SignatureHeader, signBody(s.signingKey, []byte(localBootScript)))
+		}
+		res.Header().Set("Content-Length", strconv.Itoa(len(localBootScript)))
+		passThrough(res)
+		if written, err := io.WriteString(res.ResponseWriter, localBootScript); err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Warnf(`boot response to "%s" was cut short after %d of %d bytes.`, server.MacAddress, written, len(localBootScript))
+			s.stats.cutShort(server.MacAddress)
+			return
+		}
+	} else {
+		writeBootError(res, status, errLocalBoot)
+	}
+	s.recordBoot(AuditEntry{Time: s.now(), MacAddress: macKey(server.MacAddress), Kernel: LocalBoot, ClientIP: clientIP(req), Match: server.match, Status: status})
+}
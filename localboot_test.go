@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/emicklei/go-restful"
+)
+
+func TestLocalBoot(t *testing.T) {
+	s := &Spriteful{
+		Servers: []Server{{MacAddress: validMac, Kernel: LocalBoot}},
+		BaseURL: "http://images/",
+		audit:   newAuditLog(4),
+	}
+	res := serve(s, "GET", "/api/v1/boot/"+validMac+"?format=ipxe", nil)
+	if res.Code != http.StatusOK || res.Body.String() != localBootScript || res.Header().Get("Content-Type") != IPXEContentType {
+		t.Errorf("ipxe clients should boot from local disk, status: %d body: %s", res.Code, res.Body.String())
+	}
+	if res := serve(s, "GET", "/api/v1/boot/"+validMac, nil); res.Code != http.StatusNotFound {
+		t.Errorf("pixiecore should get a 404 for local disk boots, status: %d", res.Code)
+	}
+	if entry := s.audit.recent(1)[0]; entry.Kernel != LocalBoot || entry.Status != http.StatusNotFound || entry.Match != MatchExact {
+		t.Errorf("local disk boots should be audited, got %+v", entry)
+	}
+	if asset := resolveAsset(s.BaseURL, LocalBoot); asset != LocalBoot {
+		t.Errorf("local boots should not be resolved against the base url, got %s", asset)
+	}
+}
+
+func TestLocalBootCutShort(t *testing.T) {
+	for _, timeout := range []time.Duration{0, time.Minute} {
+		s := &Spriteful{
+			Servers:        []Server{{MacAddress: validMac, Kernel: LocalBoot}},
+			stats:          newStats(time.Now()),
+			audit:          newAuditLog(4),
+			requestTimeout: timeout,
+		}
+		c := restful.NewContainer()
+		s.register(c)
+		res := &hangupRecorder{ResponseRecorder: httptest.NewRecorder(), n: 4}
+		normalizePath(c).ServeHTTP(res, httptest.NewRequest("GET", "/api/v1/boot/"+validMac+"?format=ipxe", nil))
+		if counters := s.stats.snapshot(false, time.Now()).Macs[validMac]; res.Body.Len() != 4 || counters.CutShort != 1 {
+			t.Errorf("local boot scripts cut short should be counted with a %s timeout, body: %q stats: %+v", timeout, res.Body.String(), counters)
+		}
+		if entries := s.audit.recent(1); len(entries) != 0 {
+			t.Errorf("local boots cut short should not be audited with a %s timeout, got %+v", timeout, entries)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"io/ioutil"
+
+	"github.com/sirupsen/logrus"
+)
+
+// instanceLock guards a config file against being served by more than one
+// Spriteful instance at a time.
+type instanceLock struct {
+	path string
+	file *os.File
+}
+
+// Returns the lock file path used for the given config file.
+func lockPath(config string) string {
+	return config + ".lock"
+}
+
+// Acquires the single-instance lock at path. When force is set, a lock held
+// by another instance is logged and ignored instead of failing startup.
+func acquireLock(path string, force bool) (*instanceLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		owner := lockOwner(path)
+		if !force {
+			return nil, fmt.Errorf("lock %s is held by another instance (%s): %v", path, owner, err)
+		}
+		logrus.Warnf(`lock "%s" is held by another instance (%s), continuing due to -force.`, path, owner)
+		return &instanceLock{path: path}, nil
+	}
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	logrus.Infof(`Lock "%s" acquired.`, path)
+	return &instanceLock{path: path, file: file}, nil
+}
+
+// Releases the lock if this instance owns it. The lock file is emptied but
+// left in place: removing it could race another instance that locks it
+// between the unlock and the removal, leaving that instance holding a lock
+// on a deleted file.
+func (l *instanceLock) release() {
+	if l == nil || l.file == nil {
+		return
+	}
+	l.file.Truncate(0)
+	unlockFile(l.file)
+	l.file.Close()
+	l.file = nil
+	logrus.Infof(`Lock "%s" released.`, l.path)
+}
+
+// Returns a description of the process recorded in the lock file.
+func lockOwner(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "unknown pid"
+	}
+	pid := strings.TrimSpace(string(data))
+	if pid == "" {
+		return "unknown pid"
+	}
+	return "pid " + pid
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+var testLockFile = "/tmp/spriteful-test.lock"
+
+func TestAcquireLock(t *testing.T) {
+	os.Remove(testLockFile)
+	lock, err := acquireLock(testLockFile, false)
+	if err != nil {
+		t.Fatalf("lock should be acquired, but it's not: %v", err)
+	}
+	if _, err := acquireLock(testLockFile, false); err == nil {
+		t.Errorf("lock should be held, but a second instance acquired it")
+	}
+	forced, err := acquireLock(testLockFile, true)
+	if err != nil {
+		t.Errorf("forced lock should not fail: %v", err)
+	}
+	forced.release()
+	if _, err := os.Stat(testLockFile); err != nil {
+		t.Errorf("releasing a forced lock should not remove the owner's lock file")
+	}
+	lock.release()
+	if owner := lockOwner(testLockFile); owner != "unknown pid" {
+		t.Errorf("lock file should be emptied on release, got %s", owner)
+	}
+	relocked, err := acquireLock(testLockFile, false)
+	if err != nil {
+		t.Fatalf("a released lock should be acquired again: %v", err)
+	}
+	relocked.release()
+}
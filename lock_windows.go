@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+)
+
+// lockRange is the byte range locked, far past the PID so other instances
+// can still read it. Windows drops the lock when the holder exits, so a
+// crash leaves no stale lock behind.
+var lockRange = syscall.Overlapped{OffsetHigh: 0x40000000}
+
+// Takes an exclusive, non-blocking LockFileEx lock on the file.
+func lockFile(file *os.File) error {
+	overlapped := lockRange
+	ok, _, err := procLockFileEx.Call(file.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
+
+// Drops the lock held on the file.
+func unlockFile(file *os.File) error {
+	overlapped := lockRange
+	ok, _, err := procUnlockFileEx.Call(file.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
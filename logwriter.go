@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logFlushTimeout bounds how long exiting waits for buffered log entries to
+// be written.
+const logFlushTimeout = 2 * time.Second
+
+// asyncLogWriter writes log entries to out from a goroutine, so a log
+// destination that blocks or fails, e.g. a full disk or a stalled pipe,
+// never holds up the requests logging. Entries arriving while the buffer is
+// full, and entries out fails to write, are dropped and counted; a single
+// warning reports them once out accepts entries again.
+type asyncLogWriter struct {
+	out     io.Writer
+	entries chan []byte
+	pending int64
+	dropped uint64
+}
+
+// Creates a log writer buffering up to size entries for out and starts
+// writing them.
+func newAsyncLogWriter(out io.Writer, size int) *asyncLogWriter {
+	w := &asyncLogWriter{out: out, entries: make(chan []byte, size)}
+	go w.run()
+	return w
+}
+
+// Write queues the entry without ever blocking or failing, dropping it when
+// the buffer is full.
+func (w *asyncLogWriter) Write(p []byte) (int, error) {
+	entry := make([]byte, len(p))
+	copy(entry, p)
+	atomic.AddInt64(&w.pending, 1)
+	select {
+	case w.entries <- entry:
+	default:
+		atomic.AddInt64(&w.pending, -1)
+		atomic.AddUint64(&w.dropped, 1)
+	}
+	return len(p), nil
+}
+
+// Writes the queued entries to out, preceded by the drop warning when
+// entries were dropped since the last one.
+func (w *asyncLogWriter) run() {
+	for entry := range w.entries {
+		if dropped := atomic.SwapUint64(&w.dropped, 0); dropped > 0 {
+			if _, err := w.out.Write(dropWarning(dropped)); err != nil {
+				atomic.AddUint64(&w.dropped, dropped)
+			}
+		}
+		if _, err := w.out.Write(entry); err != nil {
+			atomic.AddUint64(&w.dropped, 1)
+		}
+		atomic.AddInt64(&w.pending, -1)
+	}
+}
+
+// Waits up to timeout for the queued entries to be written.
+func (w *asyncLogWriter) flush(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(&w.pending) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Returns the warning entry reporting dropped log entries, formatted like
+// every other entry.
+func dropWarning(dropped uint64) []byte {
+	logger := logrus.StandardLogger()
+	entry := logrus.NewEntry(logger).WithField("dropped", dropped)
+	entry.Time = time.Now()
+	entry.Level = logrus.WarnLevel
+	entry.Message = "log output failed or fell behind, log entries were dropped."
+	line, err := logger.Formatter.Format(entry)
+	if err != nil {
+		return []byte(fmt.Sprintf("%d log entries were dropped.\n", dropped))
+	}
+	return line
+}
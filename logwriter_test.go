@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stalledWriter blocks every write until released, then records them,
+// failing while failing is set.
+type stalledWriter struct {
+	mu      sync.Mutex
+	release chan struct{}
+	failing bool
+	out     bytes.Buffer
+}
+
+func (w *stalledWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failing {
+		return 0, errors.New("no space left on device")
+	}
+	return w.out.Write(p)
+}
+
+func (w *stalledWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.out.String()
+}
+
+func TestAsyncLogWriter(t *testing.T) {
+	out := &stalledWriter{release: make(chan struct{})}
+	w := newAsyncLogWriter(out, 2)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			if n, err := w.Write([]byte("entry\n")); n != 6 || err != nil {
+				t.Errorf("writes should always succeed, got %d (%v)", n, err)
+			}
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writes should not block on a stalled output")
+	}
+
+	close(out.release)
+	w.flush(5 * time.Second)
+	w.Write([]byte("after\n"))
+	w.flush(5 * time.Second)
+	logged := out.String()
+	if strings.Count(logged, "entries were dropped") != 1 || !strings.Contains(logged, "dropped=") || !strings.HasSuffix(logged, "after\n") {
+		t.Errorf("a single warning should report the dropped entries, got %q", logged)
+	}
+
+	out.mu.Lock()
+	out.failing = true
+	out.mu.Unlock()
+	w.Write([]byte("lost\n"))
+	w.flush(5 * time.Second)
+	out.mu.Lock()
+	out.failing = false
+	out.out.Reset()
+	out.mu.Unlock()
+	w.Write([]byte("recovered\n"))
+	w.flush(5 * time.Second)
+	if logged := out.String(); !strings.Contains(logged, "dropped=1") || !strings.HasSuffix(logged, "recovered\n") {
+		t.Errorf("entries the output failed to write should be reported as dropped, got %q", logged)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// These are the MAC formats used in API responses.
+const (
+	MacFormatColon = "colon"
+	MacFormatDash  = "dash"
+	MacFormatCisco = "cisco"
+	MacFormatBare  = "bare"
+)
+
+// Separates a MAC address from a trailing interface identifier, as in
+// "aa:bb:cc:dd:ee:ff%eth0", "aa:bb:cc:dd:ee:ff@eth0" or
+// "aa:bb:cc:dd:ee:ff eth0".
+const macScopeSeparators = "%@ \t"
+
+// Returns the canonical (lowercase, colon separated) form of a MAC address.
+// A trailing interface identifier, which some firmware appends to name the
+// interface, is dropped.
+func normalizeMAC(mac string) (string, error) {
+	mac = strings.TrimSpace(mac)
+	if i := strings.IndexAny(mac, macScopeSeparators); i >= 0 {
+		mac = mac[:i]
+	}
+	addr, err := net.ParseMAC(mac)
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+// Returns an error unless mac can be parsed as a MAC address, telling
+// malformed request MACs apart from unknown ones.
+func validMAC(mac string) error {
+	if _, err := normalizeMAC(mac); err != nil {
+		return fmt.Errorf(`malformed mac address "%s".`, mac)
+	}
+	return nil
+}
+
+// Returns the key MAC addresses are compared on. Addresses that can't be
+// parsed fall back to a case-insensitive comparison.
+func macKey(mac string) string {
+	if normalized, err := normalizeMAC(mac); err == nil {
+		return normalized
+	}
+	return strings.ToLower(mac)
+}
+
+// Returns an error unless format is a known MAC format.
+func validMACFormat(format string) error {
+	switch format {
+	case "", MacFormatColon, MacFormatDash, MacFormatCisco, MacFormatBare:
+		return nil
+	}
+	return fmt.Errorf("unknown mac format %s.", format)
+}
+
+// Renders a canonical MAC address in the requested format. Addresses that
+// aren't canonical are returned as is.
+func formatMAC(mac, format string) string {
+	if _, err := net.ParseMAC(mac); err != nil {
+		return mac
+	}
+	bare := strings.Replace(mac, ":", "", -1)
+	switch format {
+	case MacFormatDash:
+		return strings.Replace(mac, ":", "-", -1)
+	case MacFormatBare:
+		return bare
+	case MacFormatCisco:
+		var groups []string
+		for i := 0; i < len(bare); i += 4 {
+			groups = append(groups, bare[i:i+4])
+		}
+		return strings.Join(groups, ".")
+	}
+	return mac
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"net/http"
+)
+
+func TestNormalizeMAC(t *testing.T) {
+	for _, mac := range []string{"AA:BB:CC:DD:EE:FF", "aa-bb-cc-dd-ee-ff", "aabb.ccdd.eeff", " aa:bb:cc:dd:ee:ff "} {
+		normalized, err := normalizeMAC(mac)
+		if err != nil || normalized != "aa:bb:cc:dd:ee:ff" {
+			t.Errorf("%q should normalize to aa:bb:cc:dd:ee:ff, got %q (%v)", mac, normalized, err)
+		}
+	}
+	for _, mac := range []string{"aa:bb:cc:dd:ee:ff%eth0", "AA-BB-CC-DD-EE-FF%2", "aa:bb:cc:dd:ee:ff %enp3s0f1", "aa:bb:cc:dd:ee:ff%",
+		"aa:bb:cc:dd:ee:ff@eth0", "aabb.ccdd.eeff@net0", "aa:bb:cc:dd:ee:ff eth0", "aa-bb-cc-dd-ee-ff\tnet1"} {
+		normalized, err := normalizeMAC(mac)
+		if err != nil || normalized != "aa:bb:cc:dd:ee:ff" {
+			t.Errorf("%q should drop its interface and normalize to aa:bb:cc:dd:ee:ff, got %q (%v)", mac, normalized, err)
+		}
+	}
+	for _, mac := range []string{"not-a-mac", "%eth0", "aa:bb:cc%dd:ee:ff", "eth0 aa:bb:cc:dd:ee:ff", "aa:bb:cc@dd:ee:ff"} {
+		if _, err := normalizeMAC(mac); err == nil {
+			t.Errorf("%q should not normalize, but it did", mac)
+		}
+	}
+}
+
+func TestScopedBootRequest(t *testing.T) {
+	s := &Spriteful{Servers: []Server{{MacAddress: "aa:bb:cc:dd:ee:ff", Kernel: "http://images/vmlinuz"}}}
+	if res := serve(s, "GET", "/api/v1/boot/aa:bb:cc:dd:ee:ff%25eth0", nil); res.Code != http.StatusOK {
+		t.Errorf("a mac with a zone should boot its server, status: %d", res.Code)
+	}
+}
+
+func TestFormatMAC(t *testing.T) {
+	mac := "aa:bb:cc:dd:ee:ff"
+	formats := map[string]string{
+		"":             mac,
+		MacFormatColon: mac,
+		MacFormatDash:  "aa-bb-cc-dd-ee-ff",
+		MacFormatCisco: "aabb.ccdd.eeff",
+		MacFormatBare:  "aabbccddeeff",
+	}
+	for format, want := range formats {
+		if got := formatMAC(mac, format); got != want {
+			t.Errorf("%s format of %s should be %s, got %s", format, mac, want, got)
+		}
+	}
+	if err := validMACFormat("weird"); err == nil {
+		t.Errorf("weird should not be a valid mac format")
+	}
+}
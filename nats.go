@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"encoding/json"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+)
+
+// natsPublisher publishes boot events to a subject of a NATS server over
+// the plain text core protocol. It connects on the first event and again
+// after the connection fails.
+type natsPublisher struct {
+	addr     string
+	subject  string
+	user     string
+	password string
+	timeout  time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	w    *bufio.Writer
+}
+
+// natsInfo is the part of the server's INFO message the publisher checks.
+type natsInfo struct {
+	TLSRequired  bool `json:"tls_required"`
+	AuthRequired bool `json:"auth_required"`
+}
+
+// natsConnect is the CONNECT message sent to the server.
+type natsConnect struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"pass,omitempty"`
+}
+
+// Creates the publisher for the nats URL, defaulting to port 4222.
+func newNATSPublisher(u *url.URL, subject string) *natsPublisher {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	p := &natsPublisher{addr: addr, subject: subject, timeout: 5 * time.Second}
+	if u.User != nil {
+		p.user = u.User.Username()
+		p.password, _ = u.User.Password()
+	}
+	return p
+}
+
+// Publish sends the event as a JSON message to the subject.
+func (p *natsPublisher) Publish(event interface{}) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+	p.conn.SetWriteDeadline(time.Now().Add(p.timeout))
+	fmt.Fprintf(p.w, "PUB %s %d\r\n", p.subject, len(body))
+	p.w.Write(body)
+	p.w.WriteString("\r\n")
+	if err := p.w.Flush(); err != nil {
+		p.close()
+		return err
+	}
+	return nil
+}
+
+// Dials the server, reads its INFO and sends CONNECT. Servers requiring
+// TLS, which the publisher doesn't speak, or authentication without
+// credentials in the URL are refused. Called with mu held.
+func (p *natsPublisher) connect() error {
+	conn, err := net.DialTimeout("tcp", p.addr, p.timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(p.timeout))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return errors.New("nats server didn't send INFO")
+	}
+	var info natsInfo
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info); err != nil {
+		conn.Close()
+		return fmt.Errorf("invalid nats server INFO: %v", err)
+	}
+	if info.TLSRequired {
+		conn.Close()
+		return errors.New("nats server requires TLS, which isn't supported")
+	}
+	if info.AuthRequired && p.user == "" {
+		conn.Close()
+		return errors.New("nats server requires authentication, pass credentials in the events url")
+	}
+	connect, err := json.Marshal(&natsConnect{Name: "spriteful", User: p.user, Password: p.password})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "CONNECT %s\r\n", connect)
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	p.conn, p.w = conn, w
+	go p.read(conn, r)
+	return nil
+}
+
+// Answers the server's PINGs and logs its errors until the connection
+// fails, then drops it so the next event reconnects.
+func (p *natsPublisher) read(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PING":
+			p.mu.Lock()
+			if p.conn == conn {
+				conn.SetWriteDeadline(time.Now().Add(p.timeout))
+				p.w.WriteString("PONG\r\n")
+				p.w.Flush()
+			}
+			p.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			logrus.Warnf("nats server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+	p.mu.Lock()
+	if p.conn == conn {
+		p.close()
+	}
+	p.mu.Unlock()
+}
+
+// Closes the connection. Called with mu held.
+func (p *natsPublisher) close() {
+	p.conn.Close()
+	p.conn, p.w = nil, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"time"
+
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+// OverrideClaims are the payload of a break-glass override token: the boot
+// entry forced for one MAC until the token expires.
+type OverrideClaims struct {
+	BootEntry
+	MacAddress string `json:"mac"`
+	Expires    int64  `json:"exp"`
+}
+
+// Returns an override token for the claims: the base64url encoded claims
+// JSON, a dot and the base64url encoded HMAC-SHA256 of the encoded claims.
+func signOverride(key []byte, claims *OverrideClaims) (string, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(overrideMAC(key, payload)), nil
+}
+
+// Returns the claims of the token after checking its signature, expiry and
+// MAC.
+func verifyOverride(key []byte, token, macAddress string, now time.Time) (*OverrideClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, errors.New("malformed override token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, overrideMAC(key, parts[0])) {
+		return nil, errors.New("invalid override token signature")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	var claims OverrideClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, err
+	}
+	if now.Unix() >= claims.Expires {
+		return nil, errors.New("override token expired")
+	}
+	if macKey(claims.MacAddress) != macKey(macAddress) {
+		return nil, errors.New("override token is for another mac")
+	}
+	return &claims, nil
+}
+
+// Returns the HMAC-SHA256 of the encoded claims.
+func overrideMAC(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// Applies the override token of the request to the server. Without an
+// override key, or when the token is invalid, the server is returned as is.
+func (s *Spriteful) applyOverride(token string, server *Server) *Server {
+	if token == "" || s.overrideKey == nil {
+		return server
+	}
+	claims, err := verifyOverride(s.overrideKey, token, server.MacAddress, s.now())
+	if err != nil {
+		logrus.WithField(logrus.ErrorKey, err).Warnf(`ignoring override token for "%s".`, server.MacAddress)
+		return server
+	}
+	logrus.Warnf(`break-glass override applied to "%s", kernel "%s".`, server.MacAddress, claims.Kernel)
+	return claims.apply(server)
+}
+
+// OverrideCmdlineHeader replaces the resolved cmdline of a boot request when
+// -allow-header-overrides is set.
+const OverrideCmdlineHeader = "X-Spriteful-Override-Cmdline"
+
+// Returns the server with its cmdline replaced by the request's
+// OverrideCmdlineHeader, if header overrides are allowed and it is set.
+func (s *Spriteful) applyHeaderOverride(req *restful.Request, server *Server) *Server {
+	cmdline := req.HeaderParameter(OverrideCmdlineHeader)
+	if cmdline == "" || !s.allowHeaderOverrides {
+		return server
+	}
+	logrus.Warnf(`!!! header override replaced the cmdline of "%s" with "%s" for this request. !!!`, server.MacAddress, cmdline)
+	overridden := *server
+	overridden.CommandLine = Cmdline(cmdline)
+	return &overridden
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"encoding/json"
+	"net/http"
+)
+
+func TestOverrideToken(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	key := []byte("break-glass")
+	s := &Spriteful{
+		Servers:     []Server{{MacAddress: validMac, Kernel: "vmlinuz", CommandLine: "quiet"}},
+		overrideKey: key,
+		clock:       func() time.Time { return now },
+	}
+	sign := func(key []byte, mac string, expires time.Time) string {
+		token, err := signOverride(key, &OverrideClaims{
+			BootEntry:  BootEntry{Kernel: "rescue.vmlinuz", CommandLine: "single"},
+			MacAddress: mac,
+			Expires:    expires.Unix(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	cases := []struct {
+		token  string
+		kernel string
+	}{
+		{sign(key, validMac, now.Add(time.Minute)), "rescue.vmlinuz"},
+		{sign(key, validMac, now), "vmlinuz"},
+		{sign(key, invalidMac, now.Add(time.Minute)), "vmlinuz"},
+		{sign([]byte("other"), validMac, now.Add(time.Minute)), "vmlinuz"},
+		{"garbage", "vmlinuz"},
+	}
+	for i, c := range cases {
+		var response PixieResponse
+		res := serve(s, "GET", "/api/v1/boot/"+validMac+"?override="+c.token, nil)
+		json.Unmarshal(res.Body.Bytes(), &response)
+		if res.Code != http.StatusOK || response.Kernel != c.kernel {
+			t.Errorf("case %d should boot %s, status: %d kernel: %s", i, c.kernel, res.Code, response.Kernel)
+		}
+	}
+}
+
+func TestHeaderOverride(t *testing.T) {
+	s := &Spriteful{Servers: []Server{{MacAddress: validMac, Kernel: "vmlinuz", CommandLine: "quiet"}}}
+	header := http.Header{OverrideCmdlineHeader: {"console=ttyS0 debug"}}
+	var got PixieResponse
+	json.Unmarshal(serve(s, "GET", "/api/v1/boot/"+validMac, header).Body.Bytes(), &got)
+	if got.CommandLine != "quiet" {
+		t.Errorf("header overrides should be ignored by default, got %s", got.CommandLine)
+	}
+	s.allowHeaderOverrides = true
+	json.Unmarshal(serve(s, "GET", "/api/v1/boot/"+validMac, header).Body.Bytes(), &got)
+	if got.CommandLine != "console=ttyS0 debug" || got.Kernel != "vmlinuz" {
+		t.Errorf("an allowed header override should replace only the cmdline, got %+v", got)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+
+	"net/http"
+	"net/url"
+)
+
+// Wraps handler so that duplicate and trailing slashes in the request path
+// are dropped before routing. Some firmware requests "/api/v1/boot/{mac}/"
+// or "//api/v1/..." and doesn't follow the redirects the mux would answer
+// with, so these paths are matched in place instead.
+func normalizePath(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cleaned := cleanPath(r.URL.Path)
+		if cleaned == r.URL.Path {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = cleaned
+		r2.URL.RawPath = ""
+		handler.ServeHTTP(w, r2)
+	})
+}
+
+// Collapses repeated slashes and trims the trailing slash of a path.
+func cleanPath(path string) string {
+	for strings.Contains(path, "//") {
+		path = strings.Replace(path, "//", "/", -1)
+	}
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+	return path
+}
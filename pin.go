@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// pinStore holds the boot entries MACs are temporarily pinned to. Pins
+	// live in memory only and are dropped once expired. A nil pinStore pins
+	// nothing.
+	pinStore struct {
+		mu   sync.Mutex
+		pins map[string]Pin
+	}
+
+	// PinRequest pins a MAC to the boot entry for the TTL, a duration such
+	// as "30m".
+	PinRequest struct {
+		BootEntry
+		TTL string `json:"ttl"`
+	}
+
+	// Pin is a boot entry a MAC is pinned to until it expires.
+	Pin struct {
+		MacAddress string `json:"mac"`
+		BootEntry
+		Expires time.Time `json:"expires"`
+	}
+)
+
+// Creates an empty pin store.
+func newPinStore() *pinStore {
+	return &pinStore{pins: make(map[string]Pin)}
+}
+
+// Pins the MAC, replacing any current pin.
+func (p *pinStore) set(pin Pin) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pins[macKey(pin.MacAddress)] = pin
+}
+
+// Returns the MAC's pin at now, dropping it if it has expired.
+func (p *pinStore) lookup(macAddress string, now time.Time) (Pin, bool) {
+	if p == nil {
+		return Pin{}, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := macKey(macAddress)
+	pin, ok := p.pins[key]
+	if ok && !now.Before(pin.Expires) {
+		delete(p.pins, key)
+		logrus.Infof(`pin of "%s" expired, booting its config again.`, key)
+		return Pin{}, false
+	}
+	return pin, ok
+}
+
+// Removes the MAC's pin, returning it if there was one.
+func (p *pinStore) remove(macAddress string) (Pin, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := macKey(macAddress)
+	pin, ok := p.pins[key]
+	delete(p.pins, key)
+	return pin, ok
+}
+
+// Returns the server booted for the pin.
+func (p *Pin) server() *Server {
+	return withMatch(&Server{
+		MacAddress:  p.MacAddress,
+		Kernel:      p.Kernel,
+		Initrd:      p.Initrd,
+		CommandLine: p.CommandLine,
+	}, MatchPin)
+}
+
+// Handles the http request pinning a MAC to a boot entry until the TTL
+// passes. Pinned MACs boot the entry instead of their config.
+func (s *Spriteful) handlePinRequest(req *restful.Request, res *restful.Response) {
+	macAddress := req.PathParameter("mac-addr")
+	if err := validMAC(macAddress); err != nil {
+		res.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	var pinReq PinRequest
+	if err := req.ReadEntity(&pinReq); err != nil {
+		res.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	if pinReq.Kernel == "" {
+		res.WriteErrorString(http.StatusBadRequest, "kernel is required.")
+		return
+	}
+	ttl, err := time.ParseDuration(pinReq.TTL)
+	if err != nil || ttl <= 0 {
+		res.WriteErrorString(http.StatusBadRequest, fmt.Sprintf("ttl %q must be a positive duration.", pinReq.TTL))
+		return
+	}
+
+	pin := Pin{MacAddress: macKey(macAddress), BootEntry: pinReq.BootEntry, Expires: s.now().Add(ttl).UTC()}
+	s.pins.set(pin)
+	logrus.Warnf(`"%s" pinned to kernel "%s" until %s.`, pin.MacAddress, pin.Kernel, pin.Expires.Format(time.RFC3339))
+	res.WriteAsJson(&pin)
+}
+
+// Handles the http request removing a MAC's pin before it expires.
+func (s *Spriteful) handleUnpinRequest(req *restful.Request, res *restful.Response) {
+	macAddress := req.PathParameter("mac-addr")
+	pin, ok := s.pins.remove(macAddress)
+	if !ok {
+		res.WriteErrorString(http.StatusNotFound, fmt.Sprintf("%q isn't pinned.", macAddress))
+		return
+	}
+	logrus.Infof(`pin of "%s" removed, booting its config again.`, pin.MacAddress)
+	res.WriteAsJson(&pin)
+}
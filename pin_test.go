@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"encoding/json"
+	"net/http"
+)
+
+func TestPin(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &Spriteful{
+		Servers: []Server{{MacAddress: validMac, Kernel: "http://images/vmlinuz"}},
+		clock:   func() time.Time { return now },
+		pins:    newPinStore(),
+		audit:   newAuditLog(10),
+	}
+	bootKernel := func() string {
+		var response PixieResponse
+		json.Unmarshal(serve(s, "GET", "/api/v1/boot/"+validMac, nil).Body.Bytes(), &response)
+		return response.Kernel
+	}
+
+	if res := postJSON(s, "/api/v1/pin/"+validMac, `{"kernel": "http://images/debug.vmlinuz"}`); res.Code != http.StatusBadRequest {
+		t.Errorf("a pin without a ttl should be rejected, status: %d", res.Code)
+	}
+	if res := postJSON(s, "/api/v1/pin/not-a-mac", `{"kernel": "k", "ttl": "1m"}`); res.Code != http.StatusBadRequest {
+		t.Errorf("a malformed mac should be rejected, status: %d", res.Code)
+	}
+	res := postJSON(s, "/api/v1/pin/"+validMac, `{"kernel": "http://images/debug.vmlinuz", "cmdline": "debug", "ttl": "10m"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("pin should succeed, status: %d body: %s", res.Code, res.Body)
+	}
+	if kernel := bootKernel(); kernel != "http://images/debug.vmlinuz" {
+		t.Errorf("a pinned mac should boot the pinned kernel, got %s", kernel)
+	}
+	if entry := s.audit.recent(1)[0]; entry.Match != MatchPin {
+		t.Errorf("pinned boots should be audited as pins, got %s", entry.Match)
+	}
+
+	now = now.Add(10 * time.Minute)
+	if kernel := bootKernel(); kernel != "http://images/vmlinuz" {
+		t.Errorf("an expired pin should revert to the config, got %s", kernel)
+	}
+
+	postJSON(s, "/api/v1/pin/"+validMac, `{"kernel": "http://images/debug.vmlinuz", "ttl": "1h"}`)
+	if res := sendJSON(s, "DELETE", "/api/v1/pin/"+validMac, ""); res.Code != http.StatusOK {
+		t.Fatalf("unpin should succeed, status: %d", res.Code)
+	}
+	if kernel := bootKernel(); kernel != "http://images/vmlinuz" {
+		t.Errorf("an unpinned mac should boot its config, got %s", kernel)
+	}
+	if res := sendJSON(s, "DELETE", "/api/v1/pin/"+validMac, ""); res.Code != http.StatusNotFound {
+		t.Errorf("unpinning an unpinned mac should 404, status: %d", res.Code)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Pixiecore API response envelopes spriteful can answer boot requests with.
+// The current envelope sends the cmdline as a string. The legacy envelope
+// of the original pixiecore API sends it as an object of key/value pairs,
+// with true for bare flags.
+const (
+	PixieCurrent = "current"
+	PixieLegacy  = "legacy"
+)
+
+// legacyPixieResponse is the boot response in the legacy envelope.
+type legacyPixieResponse struct {
+	Kernel      string                 `json:"kernel"`
+	Initrd      []string               `json:"initrd"`
+	CommandLine map[string]interface{} `json:"cmdline"`
+}
+
+// Returns an error unless version is a known pixiecore envelope.
+func validPixieVersion(version string) error {
+	switch version {
+	case "", PixieCurrent, PixieLegacy:
+		return nil
+	}
+	return fmt.Errorf("unknown pixiecore version %s.", version)
+}
+
+// Encodes the boot response in the configured pixiecore envelope, see
+// encodeResponse.
+func (s *Spriteful) encodePixieResponse(response *PixieResponse, raw bool) (string, error) {
+	if s.pixieVersion != PixieLegacy {
+		return encodeResponse(response, raw)
+	}
+	if !raw {
+		var err error
+		if response, err = unescapeResponse(response); err != nil {
+			return "", err
+		}
+	}
+	return encodeJSON(&legacyPixieResponse{
+		Kernel:      response.Kernel,
+		Initrd:      response.Initrd,
+		CommandLine: legacyCmdline(Cmdline(response.CommandLine)),
+	})
+}
+
+// Returns the cmdline as the object of the legacy envelope. Quotes around
+// values are dropped, and only the last token of a repeated key is kept
+// since an object can't repeat it.
+func legacyCmdline(cmdline Cmdline) map[string]interface{} {
+	args := make(map[string]interface{})
+	for _, token := range cmdline.tokens() {
+		key := tokenKey(token)
+		if _, ok := args[key]; ok {
+			logrus.Warnf(`cmdline key "%s" is repeated, only the last one is sent in the legacy pixiecore envelope.`, key)
+		}
+		if key == token {
+			args[key] = true
+			continue
+		}
+		value := token[len(key)+1:]
+		if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+			value = value[1 : len(value)-1]
+		}
+		args[key] = value
+	}
+	return args
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"net/http"
+)
+
+func TestPixieVersion(t *testing.T) {
+	server := Server{
+		MacAddress:  validMac,
+		Kernel:      "http://images/vmlinuz",
+		Initrd:      []Initrd{{URL: "http://images/initrd"}},
+		CommandLine: `quiet console=ttyS0 root="LABEL=my root"`,
+	}
+	golden := map[string]string{
+		"":           `{"kernel":"http://images/vmlinuz","initrd":["http://images/initrd"],"cmdline":"quiet console=ttyS0 root=\"LABEL=my root\""}`,
+		PixieCurrent: `{"kernel":"http://images/vmlinuz","initrd":["http://images/initrd"],"cmdline":"quiet console=ttyS0 root=\"LABEL=my root\""}`,
+		PixieLegacy:  `{"kernel":"http://images/vmlinuz","initrd":["http://images/initrd"],"cmdline":{"console":"ttyS0","quiet":true,"root":"LABEL=my root"}}`,
+	}
+	for version, want := range golden {
+		s := &Spriteful{Servers: []Server{server}, pixieVersion: version}
+		res := serve(s, "GET", "/api/v1/boot/"+validMac, nil)
+		if res.Code != http.StatusOK {
+			t.Errorf("%q should boot, status: %d", version, res.Code)
+			continue
+		}
+		if got := res.Body.String(); got != want {
+			t.Errorf("%q response should be\n%s\ngot\n%s", version, want, got)
+		}
+	}
+
+	if err := validPixieVersion("v3"); err == nil {
+		t.Error("v3 should not be a valid pixiecore version")
+	}
+}
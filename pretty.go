@@ -0,0 +1,14 @@
+package main
+
+import (
+	"github.com/emicklei/go-restful"
+)
+
+// Indents JSON API responses when the pretty query parameter is true or
+// the -pretty flag is set. Boot responses are encoded by hand and stay
+// compact either way. The URL query is read directly, as QueryParameter
+// would consume form-encoded request bodies.
+func (s *Spriteful) prettyFilter(req *restful.Request, res *restful.Response, chain *restful.FilterChain) {
+	res.PrettyPrint(s.prettyJSON || req.Request.URL.Query().Get("pretty") == "true")
+	chain.ProcessFilter(req, res)
+}
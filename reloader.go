@@ -0,0 +1,62 @@
+package main
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// reloader runs config reloads one at a time, coalescing the triggers that
+// arrive within the debounce interval of the first one into a single
+// reload. Triggers arriving during a reload start the next burst.
+type reloader struct {
+	reload   func()
+	debounce time.Duration
+	triggers chan string
+}
+
+// Creates a reloader calling reload.
+func newReloader(reload func(), debounce time.Duration) *reloader {
+	return &reloader{reload: reload, debounce: debounce, triggers: make(chan string, 64)}
+}
+
+// Asks for a reload, naming the source of the trigger for the logs. Never
+// blocks: once enough triggers are pending, more are dropped as they would
+// be coalesced anyway.
+func (r *reloader) trigger(source string) {
+	select {
+	case r.triggers <- source:
+	default:
+		logrus.Debugf("reload already pending, dropping the %s trigger.", source)
+	}
+}
+
+// Reloads for every burst of triggers until stop is closed.
+func (r *reloader) run(stop <-chan struct{}) {
+	for {
+		var first string
+		select {
+		case first = <-r.triggers:
+		case <-stop:
+			return
+		}
+		sources := []string{first}
+		timer := time.NewTimer(r.debounce)
+	burst:
+		for {
+			select {
+			case source := <-r.triggers:
+				sources = append(sources, source)
+			case <-timer.C:
+				break burst
+			case <-stop:
+				timer.Stop()
+				return
+			}
+		}
+		if len(sources) > 1 {
+			logrus.WithField("sources", sources).Infof("coalesced %d reload triggers into one reload.", len(sources))
+		}
+		r.reload()
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReloaderCoalesces(t *testing.T) {
+	reloads := make(chan struct{}, 10)
+	r := newReloader(func() { reloads <- struct{}{} }, 50*time.Millisecond)
+	stop := make(chan struct{})
+	defer close(stop)
+	go r.run(stop)
+
+	for i := 0; i < 5; i++ {
+		r.trigger("SIGHUP")
+	}
+	select {
+	case <-reloads:
+	case <-time.After(time.Second):
+		t.Fatal("a burst of triggers should reload")
+	}
+	select {
+	case <-reloads:
+		t.Fatal("a burst of triggers should reload only once")
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	r.trigger("SIGHUP")
+	select {
+	case <-reloads:
+	case <-time.After(time.Second):
+		t.Error("a later trigger should reload again")
+	}
+}
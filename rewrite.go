@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net"
+	"regexp"
+
+	"encoding/json"
+)
+
+// RewriteRule rewrites kernel and initrd URLs matching Match to Replace,
+// which may refer to submatches ($1, ${name}). With CIDR set, the rule only
+// applies to clients within it.
+type RewriteRule struct {
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+	CIDR    string `json:"cidr,omitempty"`
+
+	pattern *regexp.Regexp
+	network *net.IPNet
+}
+
+// UnmarshalJSON decodes the rule and compiles its pattern and CIDR, failing
+// the config load when either is invalid.
+func (r *RewriteRule) UnmarshalJSON(data []byte) error {
+	type rule RewriteRule
+	var decoded rule
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*r = RewriteRule(decoded)
+	pattern, err := regexp.Compile(r.Match)
+	if err != nil {
+		return err
+	}
+	r.pattern = pattern
+	if r.CIDR != "" {
+		if _, r.network, err = net.ParseCIDR(r.CIDR); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reports whether the rule applies to the URL requested by the client.
+func (r *RewriteRule) matches(url string, client net.IP) bool {
+	if r.pattern == nil || !r.pattern.MatchString(url) {
+		return false
+	}
+	return r.network == nil || (client != nil && r.network.Contains(client))
+}
+
+// Rewrites the URL with the first rule applying to it and the client, or
+// returns it unchanged.
+func rewriteURL(rules []RewriteRule, url, clientIP string) string {
+	client := net.ParseIP(clientIP)
+	for i := range rules {
+		if rules[i].matches(url, client) {
+			return rules[i].pattern.ReplaceAllString(url, rules[i].Replace)
+		}
+	}
+	return url
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"encoding/json"
+)
+
+func TestRewriteRules(t *testing.T) {
+	config := `{"rewrite-rules": [
+		{"match": "^http://origin/(.*)$", "replace": "http://mirror-eu/$1", "cidr": "10.1.0.0/16"},
+		{"match": "^http://origin/(.*)$", "replace": "http://mirror-us/$1"},
+		{"match": "^http://origin/images/(.*)$", "replace": "http://never/$1"}
+	]}`
+	s, err := decodeConfig(strings.NewReader(config), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		url, client, want string
+	}{
+		{"http://origin/images/vmlinuz", "10.1.2.3", "http://mirror-eu/images/vmlinuz"},
+		{"http://origin/images/vmlinuz", "10.2.2.3", "http://mirror-us/images/vmlinuz"},
+		{"http://other/images/vmlinuz", "10.1.2.3", "http://other/images/vmlinuz"},
+	}
+	for _, c := range cases {
+		if got := rewriteURL(s.RewriteRules, c.url, c.client); got != c.want {
+			t.Errorf("%s from %s should rewrite to %s, got %s", c.url, c.client, c.want, got)
+		}
+	}
+
+	s.Servers = []Server{{MacAddress: validMac, Kernel: "http://origin/vmlinuz", Initrd: []Initrd{{URL: "http://origin/initrd"}}}}
+	var response PixieResponse
+	res := serve(s, "GET", "/api/v1/boot/"+validMac, nil)
+	json.Unmarshal(res.Body.Bytes(), &response)
+	if response.Kernel != "http://mirror-us/vmlinuz" || response.Initrd[0] != "http://mirror-us/initrd" {
+		t.Errorf("boot responses should be rewritten, got %+v", response)
+	}
+
+	for _, invalid := range []string{`{"rewrite-rules": [{"match": "("}]}`, `{"rewrite-rules": [{"match": "x", "cidr": "bogus"}]}`} {
+		if _, err := decodeConfig(strings.NewReader(invalid), false); err == nil {
+			t.Errorf("%s should not decode", invalid)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"math/rand"
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+// redacted replaces secrets in sampled request dumps.
+const redacted = "REDACTED"
+
+// Headers and query parameters whose values are redacted in request dumps.
+var (
+	secretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+	secretParams  = []string{"override"}
+)
+
+// captureWriter passes a response through while keeping a copy of its status
+// and body.
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *captureWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Dumps the request headers and the rendered response of the -debug-sample-rate
+// fraction of boot requests at debug level, with secrets redacted.
+func (s *Spriteful) debugSampleFilter(req *restful.Request, res *restful.Response, chain *restful.FilterChain) {
+	if s.debugSampleRate <= 0 || !logrus.IsLevelEnabled(logrus.DebugLevel) || rand.Float64() >= s.debugSampleRate {
+		chain.ProcessFilter(req, res)
+		return
+	}
+	capture := &captureWriter{ResponseWriter: res.ResponseWriter}
+	res.ResponseWriter = capture
+	chain.ProcessFilter(req, res)
+	res.ResponseWriter = capture.ResponseWriter
+
+	logrus.WithFields(logrus.Fields{
+		"method":           req.Request.Method,
+		"url":              redactURL(req.Request),
+		"remote":           req.Request.RemoteAddr,
+		"request-headers":  redactHeaders(req.Request.Header),
+		"status":           capture.status,
+		"response-headers": redactHeaders(capture.Header()),
+		"body":             capture.body.String(),
+	}).Debug("sampled boot request.")
+}
+
+// Returns a copy of the headers with secret values redacted.
+func redactHeaders(header http.Header) map[string]string {
+	dump := make(map[string]string, len(header))
+	for key, values := range header {
+		dump[key] = strings.Join(values, ", ")
+	}
+	for _, key := range secretHeaders {
+		if _, ok := header[key]; ok {
+			dump[key] = redacted
+		}
+	}
+	return dump
+}
+
+// Returns the request URL with secret query parameters redacted.
+func redactURL(req *http.Request) string {
+	u := *req.URL
+	query := u.Query()
+	for _, param := range secretParams {
+		if _, ok := query[param]; ok {
+			query.Set(param, redacted)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestDebugSample(t *testing.T) {
+	hook := test.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	defer logrus.SetLevel(logrus.GetLevel())
+	logrus.SetLevel(logrus.DebugLevel)
+
+	s := &Spriteful{Servers: []Server{{MacAddress: validMac, Kernel: "vmlinuz"}}}
+	sampled := func() []*logrus.Entry {
+		var entries []*logrus.Entry
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == "sampled boot request." {
+				entries = append(entries, entry)
+			}
+		}
+		hook.Reset()
+		return entries
+	}
+	header := http.Header{"Authorization": {"Bearer secret"}, "User-Agent": {"pixiecore"}}
+	serve(s, "GET", "/api/v1/boot/"+validMac+"?override=token", header)
+	if entries := sampled(); len(entries) != 0 {
+		t.Errorf("nothing should be sampled by default, got %d", len(entries))
+	}
+
+	s.debugSampleRate = 1
+	serve(s, "GET", "/api/v1/boot/"+validMac+"?override=token", header)
+	entries := sampled()
+	if len(entries) != 1 {
+		t.Fatalf("every request should be sampled at rate 1, got %d", len(entries))
+	}
+	data := entries[0].Data
+	headers := data["request-headers"].(map[string]string)
+	if headers["Authorization"] != redacted || headers["User-Agent"] != "pixiecore" {
+		t.Errorf("only secret headers should be redacted, got %v", headers)
+	}
+	if url := data["url"].(string); strings.Contains(url, "token") {
+		t.Errorf("override tokens should be redacted, got %s", url)
+	}
+	if data["status"] != http.StatusOK || !strings.Contains(data["body"].(string), "vmlinuz") {
+		t.Errorf("the rendered response should be dumped, got %v", data)
+	}
+}
+
+func TestDebugSampleTimeout(t *testing.T) {
+	defer logrus.SetLevel(logrus.GetLevel())
+	logrus.SetLevel(logrus.DebugLevel)
+
+	s := &Spriteful{
+		Servers:         []Server{{MacAddress: validMac, Kernel: "http://images/vmlinuz"}},
+		stats:           newStats(time.Now()),
+		debugSampleRate: 1,
+		requestTimeout:  time.Minute,
+	}
+	c := restful.NewContainer()
+	s.register(c)
+	res := &hangupRecorder{ResponseRecorder: httptest.NewRecorder(), n: 10}
+	normalizePath(c).ServeHTTP(res, httptest.NewRequest("GET", "/api/v1/boot/"+validMac, nil))
+	if counters := s.stats.snapshot(false, time.Now()).Macs[validMac]; res.Body.Len() != 10 || counters.CutShort != 1 {
+		t.Errorf("sampled responses should still reach the client past the request timeout, body: %q stats: %+v", res.Body.String(), counters)
+	}
+}
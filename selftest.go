@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Resolves the canary MAC the way a boot request would and fails unless it
+// boots a kernel, and kernel if one is expected.
+func (s *Spriteful) selfTest(macAddress, kernel string) error {
+	server, err := s.serverStore().Lookup(macAddress)
+	if err != nil {
+		return err
+	}
+	resolved := s.resolveFor("", "", server)
+	if resolved.Kernel == "" {
+		return fmt.Errorf("%s resolves without a kernel", macAddress)
+	}
+	if kernel != "" && resolved.Kernel != kernel {
+		return fmt.Errorf("%s resolves to kernel %s, expected %s", macAddress, resolved.Kernel, kernel)
+	}
+	return nil
+}
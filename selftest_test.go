@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSelfTest(t *testing.T) {
+	s := &Spriteful{Servers: []Server{{MacAddress: validMac, Kernel: "vmlinuz"}, {MacAddress: invalidMac}}}
+	if err := s.selfTest(validMac, "vmlinuz"); err != nil {
+		t.Errorf("%s should pass the self-test: %v", validMac, err)
+	}
+	if err := s.selfTest(validMac, "other.vmlinuz"); err == nil {
+		t.Errorf("%s should fail with an unexpected kernel", validMac)
+	}
+	if err := s.selfTest(invalidMac, ""); err == nil {
+		t.Errorf("%s should fail without a kernel", invalidMac)
+	}
+	if err := s.selfTest("00:00:00:00:00:02", ""); err == nil {
+		t.Errorf("an unknown mac should fail the self-test")
+	}
+}
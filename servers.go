@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+// responseContentTypes are the content types JSON boot responses may be
+// sent with, the default first.
+var responseContentTypes = []string{restful.MIME_JSON, "text/plain", "text/json"}
+
+// responseStatuses are the statuses servers may be answered with instead of
+// a boot response. Redirects need a redirect URL.
+var responseStatuses = []int{
+	http.StatusOK,
+	http.StatusNoContent,
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusSeeOther,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+	http.StatusForbidden,
+	http.StatusNotFound,
+	http.StatusGone,
+}
+
+// Orders servers can be listed in.
+const (
+	SortByMAC      = "mac"
+	SortByHostname = "hostname"
+)
+
+var (
+	// errBatchRejected reports a bulk import with invalid entries.
+	errBatchRejected = errors.New("batch rejected")
+
+	// errEmptyGroup reports a group update matching no servers.
+	errEmptyGroup = errors.New("no servers in group")
+)
+
+type (
+	// BulkReport is the outcome of a bulk import.
+	BulkReport struct {
+		Applied bool         `json:"applied"`
+		Results []BulkResult `json:"results"`
+	}
+
+	// BulkResult is the outcome of a single entry of a bulk import.
+	BulkResult struct {
+		Index      int    `json:"index"`
+		MacAddress string `json:"mac"`
+		Error      string `json:"error,omitempty"`
+	}
+
+	// GroupUpdate is the outcome of a group update.
+	GroupUpdate struct {
+		Group   string `json:"group"`
+		Updated int    `json:"updated"`
+	}
+)
+
+// Returns an error if the server can't be booted, taking defaultKernel as
+// the kernel of servers that leave it empty.
+func (s *Server) validate(defaultKernel string) error {
+	if _, err := normalizeMAC(s.MacAddress); err != nil {
+		return fmt.Errorf("invalid mac %q", s.MacAddress)
+	}
+	if err := s.checkResponseStatus(); err != nil {
+		return err
+	}
+	if err := s.checkFirmware(); err != nil {
+		return err
+	}
+	if err := s.checkChecksums(); err != nil {
+		return err
+	}
+	if s.Initrd != nil && s.IPXEInitrdOrder != nil {
+		if err := checkInitrdOrder(s.IPXEInitrdOrder, len(s.Initrd)); err != nil {
+			return err
+		}
+	}
+	if s.VLAN < 0 || s.VLAN > maxVLAN {
+		return fmt.Errorf("vlan %d isn't between 1 and %d", s.VLAN, maxVLAN)
+	}
+	if s.DelegateURL != "" {
+		if s.ResponseStatus != 0 {
+			return errors.New("delegated servers can't set a response status")
+		}
+		if err := checkDelegateURL(s.DelegateURL); err != nil {
+			return err
+		}
+	}
+	if s.Kernel == "" && defaultKernel == "" && s.responseStatus() == http.StatusOK && s.DelegateURL == "" {
+		return errors.New("kernel is required")
+	}
+	if s.ContentType != "" && !containsString(responseContentTypes, s.ContentType) {
+		return fmt.Errorf("unsupported content type %q", s.ContentType)
+	}
+	return nil
+}
+
+// Returns an error unless the server's response status is one of
+// responseStatuses and it has a redirect URL exactly when it redirects.
+func (s *Server) checkResponseStatus() error {
+	if s.ResponseStatus == 0 {
+		if s.RedirectURL != "" {
+			return errors.New("redirect url needs a redirect response status")
+		}
+		return nil
+	}
+	if !containsStatus(responseStatuses, s.ResponseStatus) {
+		return fmt.Errorf("unsupported response status %d", s.ResponseStatus)
+	}
+	if isRedirect(s.ResponseStatus) != (s.RedirectURL != "") {
+		return fmt.Errorf("response status %d and redirect url %q don't match", s.ResponseStatus, s.RedirectURL)
+	}
+	return nil
+}
+
+// Returns the status of the server's boot responses. Invalid statuses fall
+// back to 200 and a boot response.
+func (s *Server) responseStatus() int {
+	if s.ResponseStatus == 0 {
+		return http.StatusOK
+	}
+	if err := s.checkResponseStatus(); err != nil {
+		logrus.WithField(logrus.ErrorKey, err).Warnf(`invalid response status for "%s", booting it normally.`, s.MacAddress)
+		return http.StatusOK
+	}
+	return s.ResponseStatus
+}
+
+// Reports whether the status is a redirect.
+func isRedirect(status int) bool {
+	return status >= 300 && status < 400
+}
+
+// Reports whether values contains value.
+func containsStatus(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the content type of the server's JSON boot responses. Types
+// missing from responseContentTypes fall back to application/json.
+func (s *Server) responseContentType() string {
+	if s.ContentType == "" {
+		return restful.MIME_JSON
+	}
+	if !containsString(responseContentTypes, s.ContentType) {
+		logrus.Warnf(`unsupported content type "%s" for "%s", using %s.`, s.ContentType, s.MacAddress, restful.MIME_JSON)
+		return restful.MIME_JSON
+	}
+	return s.ContentType
+}
+
+// Returns an error if the server carries a tag missing from the allowlist.
+// Every tag is allowed when the allowlist is empty.
+func (s *Server) checkTags(allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, tag := range s.Tags {
+		if !containsString(allowed, tag) {
+			return fmt.Errorf("unknown tag %q", tag)
+		}
+	}
+	return nil
+}
+
+// Reports whether the server has the tag.
+func (s *Server) hasTag(tag string) bool {
+	return containsString(s.Tags, tag)
+}
+
+// Reports whether values contains value.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Handles the http request adding a batch of servers. The batch is applied
+// only if every entry is valid and no MAC is duplicated within the batch or
+// against the existing servers.
+func (s *Spriteful) handleBulkImportRequest(req *restful.Request, res *restful.Response) {
+	if _, ok := s.serverStore().(*fileStore); !ok {
+		res.WriteErrorString(http.StatusNotImplemented, "bulk import is only supported by the file store.")
+		return
+	}
+	var servers []Server
+	if err := req.ReadEntity(&servers); err != nil {
+		res.WriteError(http.StatusBadRequest, err)
+		return
+	}
+
+	report := BulkReport{Results: make([]BulkResult, len(servers))}
+	err := s.update(func(cfg *Spriteful) (*Spriteful, error) {
+		existing := make(map[string]bool)
+		for _, server := range cfg.Servers {
+			existing[server.key()] = true
+		}
+		batch := make(map[string]int)
+		failed := false
+		for i, server := range servers {
+			result := BulkResult{Index: i, MacAddress: server.MacAddress}
+			key := server.key()
+			if err := server.validate(cfg.DefaultKernel); err != nil {
+				result.Error = err.Error()
+			} else if err := server.checkTags(cfg.AllowedTags); err != nil {
+				result.Error = err.Error()
+			} else if existing[key] {
+				result.Error = "mac is already configured"
+			} else if first, ok := batch[key]; ok {
+				result.Error = fmt.Sprintf("mac duplicates entry %d", first)
+			} else {
+				batch[key] = i
+			}
+			failed = failed || result.Error != ""
+			report.Results[i] = result
+		}
+		if failed {
+			return nil, errBatchRejected
+		}
+
+		next := cfg.withServers(append(append([]Server{}, cfg.Servers...), servers...))
+		if s.persist {
+			if err := next.saveConfig(s.configPath, s.sortServers); err != nil {
+				logrus.WithField(logrus.ErrorKey, err).Error("unable to persist config, bulk import reverted.")
+				return nil, err
+			}
+		}
+		return next, nil
+	})
+	if err == errBatchRejected {
+		res.WriteHeaderAndJson(http.StatusUnprocessableEntity, &report, restful.MIME_JSON)
+		return
+	}
+	if err != nil {
+		res.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	logrus.Infof("bulk import added %d servers.", len(servers))
+	report.Applied = true
+	res.WriteAsJson(&report)
+}
+
+// Returns an error unless order is a known server order.
+func validServerOrder(order string) error {
+	switch order {
+	case "", SortByMAC, SortByHostname:
+		return nil
+	}
+	return fmt.Errorf("unknown server order %s.", order)
+}
+
+// Returns the servers sorted by normalized MAC or by hostname, then MAC.
+// The servers are returned as is when order is empty, and are never sorted
+// in place so lookups keep the config order.
+func sortedServers(servers []Server, order string) []Server {
+	if order == "" {
+		return servers
+	}
+	sorted := append([]Server{}, servers...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if order == SortByHostname && sorted[i].Hostname != sorted[j].Hostname {
+			return sorted[i].Hostname < sorted[j].Hostname
+		}
+		return macKey(sorted[i].MacAddress) < macKey(sorted[j].MacAddress)
+	})
+	return sorted
+}
+
+// Handles the http request listing servers, optionally filtered by tag and
+// group.
+func (s *Spriteful) handleServersRequest(req *restful.Request, res *restful.Response) {
+	tag := req.QueryParameter("tag")
+	group := req.QueryParameter("group")
+	servers := []Server{}
+	for _, server := range sortedServers(s.serverStore().List(), s.sortServers) {
+		if tag != "" && !server.hasTag(tag) {
+			continue
+		}
+		if group != "" && server.Group != group {
+			continue
+		}
+		servers = append(servers, server)
+	}
+	res.WriteAsJson(servers)
+}
+
+// Handles the http request applying a boot entry to every server in a
+// group. Set kernel, initrd and cmdline fields replace the servers' own.
+func (s *Spriteful) handleGroupUpdateRequest(req *restful.Request, res *restful.Response) {
+	if _, ok := s.serverStore().(*fileStore); !ok {
+		res.WriteErrorString(http.StatusNotImplemented, "group updates are only supported by the file store.")
+		return
+	}
+	group := req.PathParameter("group")
+	var entry BootEntry
+	if err := req.ReadEntity(&entry); err != nil {
+		res.WriteError(http.StatusBadRequest, err)
+		return
+	}
+
+	update := GroupUpdate{Group: group}
+	err := s.update(func(cfg *Spriteful) (*Spriteful, error) {
+		servers := make([]Server, len(cfg.Servers))
+		for i, server := range cfg.Servers {
+			if server.Group == group {
+				server = *entry.apply(&server)
+				update.Updated++
+			}
+			servers[i] = server
+		}
+		if update.Updated == 0 {
+			return nil, errEmptyGroup
+		}
+
+		next := cfg.withServers(servers)
+		if s.persist {
+			if err := next.saveConfig(s.configPath, s.sortServers); err != nil {
+				logrus.WithField(logrus.ErrorKey, err).Error("unable to persist config, group update reverted.")
+				return nil, err
+			}
+		}
+		return next, nil
+	})
+	if err == errEmptyGroup {
+		res.WriteErrorString(http.StatusNotFound, fmt.Sprintf("no servers in group %q.", group))
+		return
+	}
+	if err != nil {
+		res.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	logrus.Infof(`group update changed %d servers in "%s".`, update.Updated, group)
+	res.WriteAsJson(&update)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/emicklei/go-restful"
+)
+
+func TestBulkImport(t *testing.T) {
+	config, err := ioutil.TempFile("", "spriteful-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Close()
+	defer os.Remove(config.Name())
+
+	s := &Spriteful{
+		Servers:    []Server{{MacAddress: validMac, Kernel: "vmlinuz", Meta: map[string]string{"owner": "infra"}}},
+		configPath: config.Name(),
+		persist:    true,
+	}
+	s.buildIndex()
+
+	var report BulkReport
+	res := postJSON(s, "/api/v1/servers/bulk", `[
+		{"mac": "00:00:00:00:00:02", "kernel": "vmlinuz"},
+		{"mac": "00-00-00-00-00-00", "kernel": "vmlinuz"},
+		{"mac": "00:00:00:00:00:02", "kernel": "vmlinuz"},
+		{"mac": "bogus", "kernel": "vmlinuz"},
+		{"mac": "00:00:00:00:00:03"}
+	]`)
+	json.Unmarshal(res.Body.Bytes(), &report)
+	if res.Code != http.StatusUnprocessableEntity || report.Applied {
+		t.Fatalf("invalid batch should be rejected, status: %d", res.Code)
+	}
+	for i, result := range report.Results {
+		if (i == 0) != (result.Error == "") {
+			t.Errorf("entry %d has an unexpected result: %+v", i, result)
+		}
+	}
+	if len(s.config().Servers) != 1 {
+		t.Errorf("rejected batch should not be applied, servers: %d", len(s.Servers))
+	}
+
+	res = postJSON(s, "/api/v1/servers/bulk", `[
+		{"mac": "00:00:00:00:00:02", "kernel": "vmlinuz", "serial": "SN-2"},
+		{"mac": "00:00:00:00:00:03", "kernel": "vmlinuz"}
+	]`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("valid batch should be applied, status: %d body: %s", res.Code, res.Body.String())
+	}
+	if _, err := s.findServerBySerial("SN-2"); err != nil {
+		t.Errorf("imported servers should be indexed")
+	}
+
+	file, err := os.Open(config.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	saved, err := decodeConfig(file, false)
+	if err != nil {
+		t.Fatalf("persisted config should decode: %v", err)
+	}
+	if len(saved.Servers) != 3 || saved.Servers[0].Meta["owner"] != "infra" {
+		t.Errorf("persisted config should hold every server with its meta, servers: %+v", saved.Servers)
+	}
+}
+
+func TestServerGroups(t *testing.T) {
+	s := &Spriteful{
+		Servers: []Server{
+			{MacAddress: validMac, Kernel: "old", Group: "compute", Tags: []string{"compute", "rack1"}},
+			{MacAddress: invalidMac, Kernel: "old", Group: "storage", Tags: []string{"storage"}},
+		},
+		AllowedTags: []string{"compute", "storage", "rack1"},
+	}
+	s.buildIndex()
+
+	var servers []Server
+	res := serve(s, "GET", "/api/v1/servers?tag=compute", nil)
+	json.Unmarshal(res.Body.Bytes(), &servers)
+	if len(servers) != 1 || servers[0].MacAddress != validMac {
+		t.Errorf("only the compute server is expected, servers: %+v", servers)
+	}
+
+	res = sendJSON(s, "PATCH", "/api/v1/groups/compute", `{"kernel": "new"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("group update should succeed, status: %d body: %s", res.Code, res.Body.String())
+	}
+	if cfg := s.config(); cfg.Servers[0].Kernel != "new" || cfg.Servers[1].Kernel != "old" {
+		t.Errorf("only the compute group should be updated, servers: %+v", cfg.Servers)
+	}
+	if res := sendJSON(s, "PATCH", "/api/v1/groups/missing", `{"kernel": "new"}`); res.Code != http.StatusNotFound {
+		t.Errorf("an empty group should 404, status: %d", res.Code)
+	}
+
+	res = postJSON(s, "/api/v1/servers/bulk", `[{"mac": "00:00:00:00:00:02", "kernel": "vmlinuz", "tags": ["gpu"]}]`)
+	if res.Code != http.StatusUnprocessableEntity {
+		t.Errorf("unknown tags should be rejected, status: %d", res.Code)
+	}
+}
+
+func TestSortedServers(t *testing.T) {
+	s := &Spriteful{
+		Servers: []Server{
+			{MacAddress: "00:00:00:00:00:03", Hostname: "a"},
+			{MacAddress: "00-00-00-00-00-01", Hostname: "c"},
+			{MacAddress: "00:00:00:00:00:02", Hostname: "b"},
+		},
+		sortServers: SortByMAC,
+	}
+	var macs []string
+	res := serve(s, "GET", "/api/v1/macs", nil)
+	json.Unmarshal(res.Body.Bytes(), &macs)
+	if want := []string{"00:00:00:00:00:01", "00:00:00:00:00:02", "00:00:00:00:00:03"}; !reflect.DeepEqual(macs, want) {
+		t.Errorf("macs should be sorted, macs: %v", macs)
+	}
+	var hostnames []string
+	for _, server := range sortedServers(s.Servers, SortByHostname) {
+		hostnames = append(hostnames, server.Hostname)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(hostnames, want) {
+		t.Errorf("servers should be sorted by hostname, hostnames: %v", hostnames)
+	}
+	if s.Servers[0].Hostname != "a" || s.Servers[1].Hostname != "c" {
+		t.Errorf("sorting should not reorder the config")
+	}
+}
+
+// Posts a JSON body against the registered API and returns the recorded response.
+func postJSON(s *Spriteful, path, body string) *httptest.ResponseRecorder {
+	return sendJSON(s, "POST", path, body)
+}
+
+// Sends a JSON body against the registered API and returns the recorded response.
+func sendJSON(s *Spriteful, method, path, body string) *httptest.ResponseRecorder {
+	c := restful.NewContainer()
+	s.register(c)
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", restful.MIME_JSON)
+	res := httptest.NewRecorder()
+	c.ServeHTTP(res, req)
+	return res
+}
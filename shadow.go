@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+// Loads the shadow config. Boot requests are also resolved against it and
+// differences are logged, but responses always come from the primary config.
+func (s *Spriteful) loadShadow() error {
+	file, err := openConfig(s.shadowPath, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	shadow, err := readConfig(limitConfig(file, s.shadowPath, s.maxConfigSize), s.configFormat, s.strictConfig)
+	if err != nil {
+		return err
+	}
+	shadow.clock = s.clock
+	if s.shadow == nil {
+		s.shadow = &liveConfig{}
+	}
+	s.shadow.value.Store(shadow)
+	logrus.Infof(`Shadow config "%s" loaded, %d servers.`, s.shadowPath, len(shadow.Servers))
+	return nil
+}
+
+// Resolves the MAC against the shadow config and logs how it differs from
+// primary, the resolved primary server or nil when the primary config has
+// none.
+func (s *Spriteful) compareShadow(req *restful.Request, macAddress string, primary *Server) {
+	if s.shadow == nil || macAddress == "" {
+		return
+	}
+	shadow, ok := s.shadow.value.Load().(*Spriteful)
+	if !ok {
+		return
+	}
+	var resolved *Server
+	if server, err := shadow.findServerConfig(macAddress); err == nil {
+		resolved = shadow.resolveServer(req, server)
+	}
+	if diff := shadowDiff(primary, resolved); diff != nil {
+		diff["mac"] = macKey(macAddress)
+		logrus.WithFields(diff).Warn("shadow config differs.")
+	}
+}
+
+// Returns the boot settings that differ between the primary and shadow
+// servers as primary-<field> and shadow-<field> pairs, or nil if they boot
+// the same. A nil server is reported as missing.
+func shadowDiff(primary, shadow *Server) logrus.Fields {
+	if primary == nil && shadow == nil {
+		return nil
+	}
+	if primary == nil || shadow == nil {
+		return logrus.Fields{"primary-found": primary != nil, "shadow-found": shadow != nil}
+	}
+	diff := logrus.Fields{}
+	if primary.Kernel != shadow.Kernel {
+		diff["primary-kernel"], diff["shadow-kernel"] = primary.Kernel, shadow.Kernel
+	}
+	if !reflect.DeepEqual(initrdURLs(primary.Initrd), initrdURLs(shadow.Initrd)) {
+		diff["primary-initrd"], diff["shadow-initrd"] = initrdURLs(primary.Initrd), initrdURLs(shadow.Initrd)
+	}
+	if primary.CommandLine != shadow.CommandLine {
+		diff["primary-cmdline"], diff["shadow-cmdline"] = primary.CommandLine, shadow.CommandLine
+	}
+	if len(diff) == 0 {
+		return nil
+	}
+	return diff
+}
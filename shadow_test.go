@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestShadowConfig(t *testing.T) {
+	shadow, err := ioutil.TempFile("", "spriteful-shadow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(shadow.Name())
+	shadow.WriteString(`{"servers": [{"mac": "00:00:00:00:00:00", "kernel": "new", "cmdline": "quiet"}, {"mac": "00:00:00:00:00:01", "kernel": "new"}]}`)
+	shadow.Close()
+
+	s := &Spriteful{
+		Servers:    []Server{{MacAddress: validMac, Kernel: "old", CommandLine: "quiet"}},
+		shadowPath: shadow.Name(),
+	}
+	if err := s.loadShadow(); err != nil {
+		t.Fatal(err)
+	}
+	hook := test.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	var response PixieResponse
+	res := serve(s, "GET", "/api/v1/boot/"+validMac, nil)
+	json.Unmarshal(res.Body.Bytes(), &response)
+	if response.Kernel != "old" {
+		t.Errorf("responses should come from the primary config, kernel: %s", response.Kernel)
+	}
+	diffs := 0
+	for _, entry := range hook.AllEntries() {
+		if entry.Message != "shadow config differs." {
+			continue
+		}
+		diffs++
+		if entry.Data["mac"] == validMac && (entry.Data["shadow-kernel"] != "new" || entry.Data["primary-cmdline"] != nil) {
+			t.Errorf("only the kernel should differ, diff: %v", entry.Data)
+		}
+	}
+	serve(s, "GET", "/api/v1/boot/"+invalidMac, nil)
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "shadow config differs." && entry.Data["mac"] == invalidMac && entry.Data["shadow-found"] == true {
+			diffs++
+		}
+	}
+	if diffs != 2 {
+		t.Errorf("a changed and a missing server should be logged, diffs: %d", diffs)
+	}
+}
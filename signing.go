@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"io/ioutil"
+)
+
+// SignatureHeader carries the HMAC of a signed boot response.
+const SignatureHeader = "X-Spriteful-Signature"
+
+// Reads the shared signing key, ignoring surrounding whitespace.
+func loadSigningKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) == 0 {
+		return nil, errors.New("signing key is empty")
+	}
+	return key, nil
+}
+
+// Returns the signature header value for the response body: "sha256="
+// followed by the hex HMAC-SHA256 of the exact body bytes sent.
+func signBody(key []byte, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestSignedBootResponse(t *testing.T) {
+	key := []byte("shared-secret")
+	s := &Spriteful{
+		Servers:    []Server{{MacAddress: validMac, Kernel: "vmlinuz"}},
+		signingKey: key,
+	}
+	res := serve(s, "GET", "/api/v1/boot/"+validMac, nil)
+	signature := res.Header().Get(SignatureHeader)
+	if !strings.HasPrefix(signature, "sha256=") {
+		t.Fatalf("boot response should be signed, signature: %q", signature)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(res.Body.Bytes())
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("signature should cover the response body, want %s got %s", want, signature)
+	}
+
+	s.signingKey = nil
+	if res := serve(s, "GET", "/api/v1/boot/"+validMac, nil); res.Header().Get(SignatureHeader) != "" {
+		t.Errorf("boot response should not be signed without a key")
+	}
+}
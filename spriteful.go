@@ -2,45 +2,273 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"net"
+	"io"
 	"os"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"crypto/tls"
 	"encoding/json"
-	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"os/signal"
+	"path/filepath"
 
-	"github.com/sirupsen/logrus"
 	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
 )
 
-// These are the error codes returned.
+// These are the error codes returned. They start at 1, as 0 tells service
+// managers the process succeeded.
 const (
-	ExitLoadConfigError = iota
+	ExitLoadConfigError = iota + 1
 	ExitParseConfigError
+	ExitLockError
+	ExitStoreError
+	ExitSelfTestError
+	ExitBindError
+	ExitTLSError
+)
+
+// shutdownTimeout bounds how long shutdown waits for requests in flight.
+const shutdownTimeout = 10 * time.Second
+
+// The frozen clock and random seed of -deterministic.
+var (
+	deterministicTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	deterministicSeed = int64(1)
 )
 
+// hiddenFlags are left out of the usage message.
+var hiddenFlags = map[string]bool{"deterministic": true}
+
 type (
 	// Spriteful handles the API endpoints.
 	Spriteful struct {
-		BindHost   string   `json:"bind-host"`
-		BindPort   int      `json:"bind-port"`
-		Servers    []Server `json:"servers"`
+		BindHost string   `json:"bind-host"`
+		BindPort int      `json:"bind-port"`
+		Servers  []Server `json:"servers"`
+
+		// RawURLs applies Server.RawURLs to every server.
+		RawURLs bool `json:"raw-urls,omitempty"`
+
+		// RawCmdline is the deprecated name of RawURLs.
+		RawCmdline bool `json:"raw-cmdline,omitempty"`
+
+		// BaseURL is the absolute URL relative kernels and initrds are
+		// resolved against in boot responses.
+		BaseURL string `json:"base-url,omitempty"`
+
+		// DefaultKernel and DefaultInitrd boot servers that leave their
+		// own kernel or initrd empty.
+		DefaultKernel string   `json:"default-kernel,omitempty"`
+		DefaultInitrd []Initrd `json:"default-initrd,omitempty"`
+
+		// GroupDefaults are merged under every server of the group.
+		GroupDefaults map[string]BootEntry `json:"group-defaults,omitempty"`
+
+		// Fallback boots MACs that match no server or wildcard, before
+		// discovery is tried.
+		Fallback *BootEntry `json:"fallback,omitempty"`
+
+		// ArchDefaults are merged under every server booted with the
+		// matching arch query parameter.
+		ArchDefaults map[string]BootEntry `json:"arch-defaults,omitempty"`
+
+		// AllowedTags, when set, rejects servers added or updated through
+		// the API with any other tag.
+		AllowedTags []string `json:"allowed-tags,omitempty"`
+
+		// RewriteRules rewrite kernel and initrd URLs in boot responses, the
+		// first matching rule wins.
+		RewriteRules []RewriteRule `json:"rewrite-rules,omitempty"`
+
+		// UserAgents select boot entries and response formats by the
+		// client's User-Agent, after the server's own rules.
+		UserAgents []UserAgentRule `json:"user-agents,omitempty"`
+
+		// LeaseDefaults boots leased MACs missing from the servers.
+		LeaseDefaults *BootEntry `json:"lease-defaults,omitempty"`
+
+		// ACL restricts the boot and admin endpoints to client networks.
+		ACL *ACL `json:"acl,omitempty"`
+
+		// RetryCount and RetryDelay, a duration such as "5s", make iPXE
+		// scripts retry loading and booting the images that many times,
+		// sleeping the delay in between. JSON responses ignore them.
+		RetryCount int    `json:"retry-count,omitempty"`
+		RetryDelay string `json:"retry-delay,omitempty"`
+
+		// Banner is echoed by every iPXE script before it boots, followed
+		// by the server's own banner. JSON responses ignore it.
+		Banner string `json:"banner,omitempty"`
+
+		// Headers are set on every response, under the headers of the
+		// booted server.
+		Headers Headers `json:"headers,omitempty"`
+
+		serials    map[string]int
+		vlans      map[string]int
+		own        *Spriteful
+		snapshot   *Spriteful
+		leaseMACs  map[string]string
+		configHash string
+		assetsDir  string
+		cache      *assetCache
+		adminToken string
+		macFormat  string
+		discovery  *discovery
+		store      ServerStore
+
+		requestTimeout time.Duration
+		allowEmpty     bool
+		clock          func() time.Time
+		docs           bool
+		signingKey     []byte
+		configPath     string
+		persist        bool
+		live           *liveConfig
+		cacheWorkers   int
+		deepCheck      *deepChecker
+		verifyAssets   bool
+		stats          *stats
+		unknown        *unknownMACs
+		audit          *auditLog
+		events         *eventQueue
+		idle           *idleTracker
+		pins           *pinStore
+		responseHook   *responseHook
+		sortServers    string
+		overrideKey    []byte
+		reusePort      bool
+		listenBacklog  int
+		maxConnections int
+		reloadQuiesce  bool
+		reloadDebounce time.Duration
+		leasesPath     string
+		strictConfig   bool
+		configFormat   string
+		maxConfigSize  int64
+		baseConfigPath string
+		shadowPath     string
+		shadow         *liveConfig
+		grpcPort       int
+		tlsConfig      *tls.Config
+
+		readHeaderTimeout    time.Duration
+		keepAliveTimeout     time.Duration
+		allowHeaderOverrides bool
+		debugSampleRate      float64
+		maxCmdline           int
+		cmdlineSyntax        string
+		prettyJSON           bool
+		pixieVersion         string
+		debug                bool
 	}
 
 	// Server represents a server with it's boot configuration.
 	Server struct {
 		MacAddress  string   `json:"mac"`
 		Kernel      string   `json:"kernel"`
-		Initrd      []string `json:"initrd"`
-		CommandLine string   `json:"cmdline"`
+		Initrd      []Initrd `json:"initrd"`
+		CommandLine Cmdline  `json:"cmdline"`
+		Serial      string   `json:"serial,omitempty"`
+		Hostname    string   `json:"hostname,omitempty"`
+		IP          string   `json:"ip,omitempty"`
+
+		// VLAN keys the server on its MAC and this VLAN ID. It is only
+		// booted by requests for the VLAN, which boot the MAC's server
+		// without a VLAN when there is none.
+		VLAN int `json:"vlan,omitempty"`
+
+		// Disabled servers are kept in the config but never booted.
+		Disabled bool `json:"disabled,omitempty"`
+
+		// RawURLs skips the URL-unescaping applied to the kernel and
+		// initrd URLs of boot responses.
+		RawURLs bool `json:"raw-urls,omitempty"`
+
+		// RawCmdline is the deprecated name of RawURLs, from when the
+		// cmdline was URL-unescaped too.
+		RawCmdline bool `json:"raw-cmdline,omitempty"`
+
+		// Meta holds free-form annotations (owner, ticket, ...) and is never
+		// used when booting.
+		Meta map[string]string `json:"meta,omitempty"`
+
+		// Windows are alternate boot entries used during maintenance windows.
+		Windows []WindowedEntry `json:"windows,omitempty"`
+
+		// Firmware holds boot entries applied over the server when booted
+		// with the matching firmware query parameter (uefi or bios).
+		Firmware map[string]BootEntry `json:"firmware,omitempty"`
+
+		// IPXEInitrdOrder lists the indexes of Initrd in the order iPXE
+		// scripts load them, for platforms needing a different order than
+		// the JSON response. Every index must be listed once.
+		IPXEInitrdOrder []int `json:"ipxe-initrd-order,omitempty"`
+
+		// KernelSHA256 and InitrdSHA256 are the hex SHA-256 checksums of the
+		// kernel and of each initrd, "" where unknown, sent in extended
+		// responses. Entries replacing the kernel or initrds drop them.
+		KernelSHA256 string   `json:"kernel-sha256,omitempty"`
+		InitrdSHA256 []string `json:"initrd-sha256,omitempty"`
+
+		// ContentType overrides the content type of the server's JSON boot
+		// responses, see responseContentTypes.
+		ContentType string `json:"content-type,omitempty"`
+
+		// Tags and Group select servers for listing and group updates.
+		Tags  []string `json:"tags,omitempty"`
+		Group string   `json:"group,omitempty"`
+
+		// ResponseStatus replaces the boot response with a bare status,
+		// e.g. 404 for machines that must boot from local disk, see
+		// responseStatuses. Redirects are sent to RedirectURL.
+		ResponseStatus int    `json:"response-status,omitempty"`
+		RedirectURL    string `json:"redirect-url,omitempty"`
+
+		// DelegateURL hands the server's boot requests to another boot
+		// server with a 302, see delegateTarget.
+		DelegateURL string `json:"delegate-url,omitempty"`
+
+		// Banner is echoed by the server's iPXE scripts after the global
+		// banner.
+		Banner string `json:"banner,omitempty"`
+
+		// UserAgents select boot entries and response formats by the
+		// client's User-Agent, the first matching rule wins.
+		UserAgents []UserAgentRule `json:"user-agents,omitempty"`
+
+		// Headers are set on the server's boot responses, over the global
+		// headers.
+		Headers Headers `json:"headers,omitempty"`
+
+		// leased servers were created from a DHCP lease and aren't saved.
+		leased bool
+
+		// leaseHostname and leaseIP are set when the hostname or IP was
+		// filled from a DHCP lease, which isn't saved either.
+		leaseHostname bool
+		leaseIP       bool
+
+		// inherited servers come from the base config and aren't saved.
+		inherited bool
+
+		// match is the lookup layer that found the server, for the audit
+		// log.
+		match string
+
+		// format is the response format chosen by a User-Agent rule, ""
+		// to negotiate it.
+		format string
 	}
 
 	// PixieResponse is the response required by pixie core for booting up servers.
@@ -49,102 +277,1066 @@ type (
 		Initrd      []string `json:"initrd"`
 		CommandLine string   `json:"cmdline"`
 	}
+
+	// MacEntry describes a configured MAC address for discovery.
+	MacEntry struct {
+		MacAddress string `json:"mac"`
+		Hostname   string `json:"hostname,omitempty"`
+		Disabled   bool   `json:"disabled,omitempty"`
+	}
 )
 
 // Starts Spriteful API using the provided configuration.
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(runDiff(os.Args[2:], os.Stdout, os.Stderr))
+	}
 	logrus.Info("Starting Spriteful API...")
-	config := flag.String("config", "config.json", "spriteful configuration")
+	config := flag.String("config", "config.json", "spriteful configuration file or http(s) URL")
+	strictConfig := flag.Bool("strict-config", false, "fail to load configs with unknown fields")
+	allowEmbeddedDefault := flag.Bool("allow-embedded-default", false, "start with the embedded default config when the config file doesn't exist")
+	baseConfig := flag.String("base-config", "", "config the main config is merged over, file or URL")
+	maxConfigSize := flag.Int64("max-config-size", 64<<20, "largest config read in bytes, 0 for no limit")
+	configFormat := flag.String("config-format", FormatAuto, "config format (auto, json, yaml), auto detects it from the content")
+	configRetries := flag.Int("config-retries", 3, "times a remote config is fetched again before giving up at startup")
+	configRetryInterval := flag.Duration("config-retry-interval", time.Second, "wait before the first remote config retry, doubled after each")
+	logLevel := flag.String("log-level", "info", "log level (debug, info, warn, error)")
+	logBuffer := flag.Int("log-buffer", 4096, "log entries buffered for a slow or failing log output before they are dropped, 0 writes them synchronously")
+	single := flag.Bool("lock", false, "refuse to start if another instance holds the config lock file")
+	force := flag.Bool("force", false, "start even if another instance holds the lock")
+	assetsDir := flag.String("assets-dir", "", "directory served at api/v1/static")
+	cacheDir := flag.String("cache-dir", "", "directory caching remote kernels and initrds")
+	eventsURL := flag.String("events-url", "", "where boot events are published: an http(s) webhook or a nats://host:port server")
+	eventsSubject := flag.String("events-subject", "spriteful.boot", "NATS subject boot events are published to")
+	eventsQueue := flag.Int("events-queue", 1024, "boot events waiting to be published before new ones are dropped")
+	eventsFailures := flag.Int("events-failure-threshold", 5, "consecutive boot event publish failures opening its circuit breaker, 0 disables the breaker")
+	eventsCooldown := flag.Duration("events-cooldown", 30*time.Second, "how long an open boot events circuit drops events before retrying")
+	unknownMACsEnabled := flag.Bool("unknown-macs", false, "record MACs booting without a server of their own for api/v1/unknown-macs")
+	unknownMACsFile := flag.String("unknown-macs-file", "", "file the unknown macs are loaded from at startup and saved to, implies -unknown-macs")
+	auditSize := flag.Int("audit-size", 1000, "boot decisions kept for api/v1/audit, 0 disables the audit log")
+	cacheWorkers := flag.Int("cache-workers", 4, "concurrent downloads when warming the cache")
+	cacheTTL := flag.Duration("cache-ttl", 5*time.Minute, "how long cached assets are served before being re-validated against the origin")
+	adminToken := flag.String("admin-token", "", "bearer token required by admin endpoints")
+	discoveryImage := flag.String("discovery-image", "", "kernel booted by machines missing from the config")
+	webhookFailures := flag.Int("webhook-failure-threshold", 5, "consecutive discovery webhook failures opening its circuit breaker, 0 disables the breaker")
+	webhookCooldown := flag.Duration("webhook-cooldown", 30*time.Second, "how long an open discovery webhook circuit drops events before retrying")
+	discoveryWebhook := flag.String("discovery-webhook", "", "URL notified about machines missing from the config")
+	pretty := flag.Bool("pretty", false, "indent JSON API responses, as with ?pretty=true")
+	requestTimeout := flag.Duration("request-timeout", 30*time.Second, "maximum time to answer a request, shared by its outbound calls, 0 disables")
+	storeType := flag.String("store", "file", "server store (file, sql)")
+	sqlDriver := flag.String("sql-driver", "postgres", "sql store driver (postgres, mysql)")
+	dsn := flag.String("dsn", "", "sql store data source name")
+	sqlTable := flag.String("sql-table", "servers", "sql store table")
+	storeCacheTTL := flag.Duration("store-cache-ttl", 10*time.Second, "how long sql store lookups are cached")
+	storeRetries := flag.Int("store-retries", 2, "how many times failed sql store queries are retried")
+	storeRetryBackoff := flag.Duration("store-retry-backoff", 100*time.Millisecond, "wait before the first sql store retry, doubled for each next one")
+	storeMaxStale := flag.Duration("store-max-stale", 5*time.Minute, "how long past the cache ttl sql store results are served while the database is unreachable")
+	tlsCert := flag.String("tls-cert", "", "certificate file the API is served over TLS with")
+	tlsKey := flag.String("tls-key", "", "private key file of -tls-cert")
+	tlsClientCA := flag.String("tls-client-ca", "", "CA bundle client certificates are required to be signed by, needs -tls-cert")
+	allowEmpty := flag.Bool("allow-empty-config", false, "don't warn or report degraded when no servers are configured")
+	signingKey := flag.String("signing-key", "", "file holding the shared key boot responses are signed with")
+	overrideKey := flag.String("override-key", "", "file holding the key break-glass override tokens are signed with")
+	leases := flag.String("dhcp-leases", "", "ISC dhcpd lease file enriching and adding servers")
+	shadowConfig := flag.String("shadow-config", "", "config boot requests are also resolved against, logging differences")
+	persist := flag.Bool("persist", false, "write servers changed through the API back to the config file")
+	deepInterval := flag.Duration("deep-check-interval", time.Minute, "how long /healthz?deep=true results are cached")
+	responseHook := flag.String("response-hook", "", "command boot responses are piped through, its output is sent instead")
+	responseHookTimeout := flag.Duration("response-hook-timeout", 2*time.Second, "how long the response hook may run before the response is sent untransformed")
+	verifyAssets := flag.Bool("verify-assets", false, "verify configured assets at startup and leave unreachable optional initrds out of iPXE scripts")
+	verifyConcurrency := flag.Int("verify-concurrency", 8, "concurrent asset checks when verifying assets at startup")
+	verifyHostConcurrency := flag.Int("verify-host-concurrency", 2, "concurrent asset checks against one host when verifying assets at startup")
+	reusePort := flag.Bool("reuseport", false, "set SO_REUSEPORT so several instances can share the bind port")
+	listenBacklog := flag.Int("listen-backlog", 0, "accept queue length, 0 keeps the system default")
+	maxConnections := flag.Int("max-connections", 0, "simultaneous connections accepted, 0 for no limit")
+	readHeaderTimeout := flag.Duration("read-header-timeout", 10*time.Second, "how long a connection may take to send its request headers, TLS handshake included")
+	keepAliveTimeout := flag.Duration("keepalive-timeout", time.Minute, "how long an idle keep-alive connection is kept open")
+	reloadQuiesce := flag.Bool("reload-quiesce", false, "answer boot requests with 503 and Retry-After while a reload is in progress")
+	reloadDebounce := flag.Duration("reload-debounce", 500*time.Millisecond, "how long reload triggers are collected into a single reload")
+	selfTestMac := flag.String("self-test-mac", "", "mac resolved at startup, failing startup unless it boots")
+	selfTestKernel := flag.String("self-test-kernel", "", "kernel the self-test mac is expected to boot")
+	allowHeaderOverrides := flag.Bool("allow-header-overrides", false, "honor the X-Spriteful-Override-Cmdline header on boot requests, for staging only")
+	debugSampleRate := flag.Float64("debug-sample-rate", 0, "fraction (0.0-1.0) of boot requests dumped in full at debug level")
+	maxCmdline := flag.Int("max-cmdline-length", 2048, "cmdline length in bytes warned about, or failing the config with -strict-config, 0 disables")
+	cmdlineSyntax := flag.String("cmdline-syntax", CmdlineSyntaxOff, "checking of cmdline quotes and key=value tokens (off, warn, strict)")
+	deterministic := flag.Bool("deterministic", false, "testing only: freeze the clock and seed randomness to fixed values")
+	debug := flag.Bool("debug", false, "answer boot requests with ?explain=true with how they were resolved, for debugging only")
+	check := flag.Bool("check", false, "load the config, run the self-test and exit without serving")
+	grpcPort := flag.Int("grpc-port", 0, "port of the gRPC boot API, 0 disables it")
+	idleTimeout := flag.Duration("idle-timeout", 0, "shut down after no boot request for this long, 0 disables")
+	docs := flag.Bool("docs", false, "serve the OpenAPI spec at apidocs.json")
+	sortServers := flag.String("sort-servers", "", "order of listed and saved servers (mac, hostname), config order by default")
+	macFormat := flag.String("mac-format", MacFormatColon, "mac format in API responses (colon, dash, cisco, bare)")
+	pixieVersion := flag.String("pixie-version", PixieCurrent, "pixiecore response envelope of boot responses (current, legacy)")
+	flag.Usage = usage
 	flag.Parse()
-	data, err := ioutil.ReadFile(*config)
+	if *logBuffer > 0 {
+		logs := newAsyncLogWriter(os.Stderr, *logBuffer)
+		logrus.SetOutput(logs)
+		logrus.RegisterExitHandler(func() { logs.flush(logFlushTimeout) })
+		defer logs.flush(logFlushTimeout)
+	}
+	if level, err := logrus.ParseLevel(*logLevel); err != nil {
+		logrus.WithField(logrus.ErrorKey, err).Warn("invalid log level, using info.")
+	} else {
+		logrus.SetLevel(level)
+	}
+	if *single {
+		lock, err := acquireLock(lockPath(*config), *force)
+		if err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Error("unable to acquire lock.")
+			logrus.Exit(ExitLockError)
+		}
+		defer lock.release()
+	}
+	file, err := openConfigOrDefault(*config, *configRetries, *configRetryInterval, *allowEmbeddedDefault)
 	if err != nil {
-		logrus.WithField(logrus.ErrorKey, err).Fatal("unable to read config")
-		os.Exit(ExitLoadConfigError)
+		configLoadFailed(*config, "startup", err).Error("unable to read config")
+		logrus.Exit(ExitLoadConfigError)
 	}
-	var sprite Spriteful
-	if err := json.Unmarshal(data, &sprite); err != nil {
-		logrus.WithField(logrus.ErrorKey, err).Fatal("unable to parse config.")
-		os.Exit(ExitParseConfigError)
+	if err := validConfigFormat(*configFormat); err != nil {
+		logrus.WithField(logrus.ErrorKey, err).Warn("invalid config format, detecting it.")
+		*configFormat = FormatAuto
+	}
+	sprite, err := readConfig(limitConfig(file, *config, *maxConfigSize), *configFormat, *strictConfig)
+	file.Close()
+	if err != nil {
+		configLoadFailed(*config, "startup", err).Fatal("unable to parse config.")
+		logrus.Exit(ExitParseConfigError)
+	}
+	if *baseConfig != "" {
+		base, err := readConfigFile(*baseConfig, *configRetries, *configRetryInterval, *maxConfigSize, *configFormat, *strictConfig)
+		if err != nil {
+			configLoadFailed(*baseConfig, "startup", err).Fatal("unable to load base config.")
+		}
+		if sprite, err = mergeConfig(base, sprite); err != nil {
+			configLoadFailed(*config, "startup", err).Fatal("unable to merge base config.")
+		}
+		logrus.Infof(`Base config "%s" loaded.`, *baseConfig)
 	}
 	logrus.Infof(`Config "%s" loaded.`, *config)
+	if *deterministic {
+		logrus.Warn("!!! deterministic mode: the clock is frozen and randomness is seeded, for testing only. !!!")
+		sprite.clock = func() time.Time { return deterministicTime }
+		rand.Seed(deterministicSeed)
+	} else {
+		rand.Seed(time.Now().UnixNano())
+	}
+	sprite.assetsDir = *assetsDir
+	sprite.adminToken = *adminToken
+	var discoveryEvents *eventQueue
+	if *discoveryWebhook != "" {
+		discoveryEvents = newEventQueue("discovery event", newGuardedPublisher(newWebhookPublisher(*discoveryWebhook), newBreaker("discovery webhook", *webhookFailures, *webhookCooldown)), *eventsQueue)
+	}
+	sprite.discovery = newDiscovery(*discoveryImage, discoveryEvents)
+	sprite.requestTimeout = *requestTimeout
+	sprite.prettyJSON = *pretty
+	sprite.allowEmpty = *allowEmpty
+	sprite.docs = *docs
+	sprite.debug = *debug
+	sprite.grpcPort = *grpcPort
+	if sprite.tlsConfig, err = loadTLSConfig(*tlsCert, *tlsKey, *tlsClientCA); err != nil {
+		logrus.WithField(logrus.ErrorKey, err).Error("unable to load the tls config.")
+		logrus.Exit(ExitTLSError)
+	}
+	sprite.allowHeaderOverrides = *allowHeaderOverrides
+	if *debugSampleRate < 0 || *debugSampleRate > 1 {
+		logrus.Warnf("invalid debug sample rate %v, sampling disabled.", *debugSampleRate)
+	} else {
+		sprite.debugSampleRate = *debugSampleRate
+	}
+	sprite.reloadQuiesce = *reloadQuiesce
+	sprite.reloadDebounce = *reloadDebounce
+	sprite.reusePort = *reusePort
+	sprite.listenBacklog = *listenBacklog
+	sprite.maxConnections = *maxConnections
+	sprite.readHeaderTimeout = *readHeaderTimeout
+	sprite.keepAliveTimeout = *keepAliveTimeout
+	sprite.verifyAssets = *verifyAssets
+	sprite.responseHook = newResponseHook(*responseHook, *responseHookTimeout)
+	sprite.stats = newStats(sprite.now())
+	if *unknownMACsEnabled || *unknownMACsFile != "" {
+		unknown, err := newUnknownMACs(*unknownMACsFile)
+		if err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Warnf(`unable to load unknown macs from "%s", starting empty.`, *unknownMACsFile)
+		}
+		sprite.unknown = unknown
+	}
+	sprite.idle = newIdleTracker(*idleTimeout, sprite.now())
+	sprite.audit = newAuditLog(*auditSize)
+	if *eventsURL != "" {
+		publisher, err := newEventPublisher(*eventsURL, *eventsSubject)
+		if err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Warn("invalid events url or subject, boot events won't be published.")
+		} else {
+			sprite.events = newEventQueue("boot event", newGuardedPublisher(publisher, newBreaker("boot events", *eventsFailures, *eventsCooldown)), *eventsQueue)
+		}
+	}
+	sprite.pins = newPinStore()
+	sprite.deepCheck = newDeepChecker(*deepInterval)
+	sprite.configPath = *config
+	sprite.persist = *persist
+	sprite.strictConfig = *strictConfig
+	sprite.configFormat = *configFormat
+	sprite.maxConfigSize = *maxConfigSize
+	sprite.baseConfigPath = *baseConfig
+	sprite.leasesPath = *leases
+	sprite.shadowPath = *shadowConfig
+	if *shadowConfig != "" {
+		if err := sprite.loadShadow(); err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Error("unable to load shadow config, shadowing disabled.")
+		}
+	}
+	if *leases != "" {
+		if err := sprite.loadLeases(*leases); err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Error("unable to read dhcp leases, using the config only.")
+		}
+	}
+	if *signingKey != "" {
+		key, err := loadSigningKey(*signingKey)
+		if err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Fatal("unable to read signing key.")
+		}
+		sprite.signingKey = key
+	}
+	if *overrideKey != "" {
+		key, err := loadSigningKey(*overrideKey)
+		if err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Fatal("unable to read override key.")
+		}
+		sprite.overrideKey = key
+	}
+	switch *storeType {
+	case "file":
+	case "sql":
+		store, err := newSQLStore(*sqlDriver, *dsn, *sqlTable, *storeCacheTTL)
+		if err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Error("unable to open sql store.")
+			logrus.Exit(ExitStoreError)
+		}
+		store.retries = *storeRetries
+		store.backoff = *storeRetryBackoff
+		store.maxStale = *storeMaxStale
+		sprite.store = store
+		logrus.Infof(`Using %s sql store table "%s".`, *sqlDriver, *sqlTable)
+	default:
+		logrus.Errorf(`unknown store "%s".`, *storeType)
+		logrus.Exit(ExitStoreError)
+	}
+	if err := validMACFormat(*macFormat); err != nil {
+		logrus.WithField(logrus.ErrorKey, err).Warn("invalid mac format, using colon.")
+	} else {
+		sprite.macFormat = *macFormat
+	}
+	if err := validPixieVersion(*pixieVersion); err != nil {
+		logrus.WithField(logrus.ErrorKey, err).Warn("invalid pixiecore version, using current.")
+	} else {
+		sprite.pixieVersion = *pixieVersion
+	}
+	if err := validServerOrder(*sortServers); err != nil {
+		logrus.WithField(logrus.ErrorKey, err).Warn("invalid server order, using config order.")
+	} else {
+		sprite.sortServers = *sortServers
+	}
+	if *cacheDir != "" {
+		cache, err := newAssetCache(*cacheDir, *cacheTTL)
+		if err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Error("unable to create asset cache, caching disabled.")
+		} else {
+			sprite.cache = cache
+			sprite.cacheWorkers = *cacheWorkers
+			go cache.warm(remoteAssets(withBaseURL(sprite.Servers, sprite.BaseURL)), *cacheWorkers)
+		}
+	}
+	sprite.maxCmdline = *maxCmdline
+	if err := sprite.checkCmdlineLengths(*maxCmdline); err != nil && *strictConfig {
+		configLoadFailed(*config, "startup", err).Fatal("config has cmdlines over the maximum length.")
+	}
+	if err := validCmdlineSyntax(*cmdlineSyntax); err != nil {
+		logrus.WithField(logrus.ErrorKey, err).Warn("invalid cmdline syntax level, not checking cmdlines.")
+	} else {
+		sprite.cmdlineSyntax = *cmdlineSyntax
+	}
+	if err := sprite.checkCmdlineSyntax(sprite.cmdlineSyntax); err != nil {
+		configLoadFailed(*config, "startup", err).Fatal("config has malformed cmdlines.")
+	}
+	sprite.live = &liveConfig{}
+	sprite.live.value.Store(sprite)
+	sprite.warnIfEmpty()
+	if *verifyAssets {
+		go verifyConfiguredAssets(withBaseURL(sprite.Servers, sprite.BaseURL), *verifyConcurrency, *verifyHostConcurrency)
+	}
+	if *selfTestMac != "" {
+		if err := sprite.selfTest(*selfTestMac, *selfTestKernel); err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Error("self-test failed.")
+			logrus.Exit(ExitSelfTestError)
+		}
+		logrus.Infof(`self-test resolved "%s".`, *selfTestMac)
+	}
+	if *check {
+		logrus.Info("Config check passed.")
+		return
+	}
 	sprite.startApi()
 }
 
+// Prints the usage message, leaving out the hidden flags.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	visible := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	visible.SetOutput(out)
+	flag.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			visible.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	visible.PrintDefaults()
+}
+
 // Starts the Spriteful API.
 func (s *Spriteful) startApi() {
 	container := restful.NewContainer()
 	s.register(container)
 
-	bindAddress := net.JoinHostPort(s.BindHost, strconv.Itoa(s.BindPort))
-	server := &http.Server{
-		Addr:    bindAddress,
-		Handler: container,
+	if err := resolveBindHost(s.BindHost); err != nil {
+		logrus.WithField(logrus.ErrorKey, err).Errorf(`unable to resolve bind host "%s".`, s.BindHost)
+		logrus.Exit(ExitBindError)
+	}
+	bindAddress := joinBindAddress(s.BindHost, s.BindPort)
+	listener, err := listen(bindAddress, s.reusePort, s.listenBacklog)
+	if err != nil {
+		logrus.WithField(logrus.ErrorKey, err).Errorf(`unable to listen at "%s".`, bindAddress)
+		logrus.Exit(ExitBindError)
+	}
+	listener = limitListen(listener, s.maxConnections)
+	if s.tlsConfig != nil {
+		// Clients without a valid certificate are rejected by the handshake
+		// with -tls-client-ca, before any request is read.
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+	server := s.httpServer(bindAddress, normalizePath(container))
+	go server.Serve(listener)
+	logrus.Infof(`Spriteful API now listening at "%s".`, listener.Addr())
+	if s.grpcPort > 0 {
+		grpcHost := s.BindHost
+		if strings.HasPrefix(grpcHost, unixPrefix) {
+			grpcHost = "localhost"
+		}
+		grpcServer, _, err := s.startGRPC(joinBindAddress(grpcHost, s.grpcPort))
+		if err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Fatal("unable to start the gRPC API.")
+		}
+		defer s.stopGRPC(grpcServer)
 	}
-	go server.ListenAndServe()
-	logrus.Infof(`Spriteful API now listening at "%s".`, bindAddress)
 
-	ch := make(chan os.Signal)
-	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
-	<-ch
+	reloads := newReloader(s.reloadOrKeep, s.reloadDebounce)
+	stopReloads := make(chan struct{})
+	defer close(stopReloads)
+	go reloads.run(stopReloads)
+
+	go s.unknown.run(unknownSaveInterval, stopReloads)
+	idle := s.idleShutdown(stopReloads)
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, os.Interrupt)
+wait:
+	for {
+		select {
+		case sig := <-ch:
+			if sig != syscall.SIGHUP {
+				break wait
+			}
+			reloads.trigger("SIGHUP")
+		case <-idle:
+			logrus.Infof("no boot request for %s, shutting down idle instance.", s.idle.timeout)
+			break wait
+		}
+	}
 	logrus.Info("Shutting down Spriteful API...")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logrus.WithField(logrus.ErrorKey, err).Warn("shutdown timed out, closing open connections.")
+		server.Close()
+	}
+	if err := s.unknown.save(); err != nil {
+		logrus.WithField(logrus.ErrorKey, err).Warnf(`unable to save unknown macs to "%s".`, s.unknown.path)
+	}
+}
+
+// Returns the HTTP server for the API. Connections stalling before their
+// request headers or idling between requests are closed, so they can't hold
+// the -max-connections slots other clients need.
+func (s *Spriteful) httpServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ErrorLog:          serverErrorLog(),
+		ReadHeaderTimeout: s.readHeaderTimeout,
+		IdleTimeout:       s.keepAliveTimeout,
+	}
 }
 
 // Registers the endpoints for the API.
 func (s *Spriteful) register(container *restful.Container) {
 	logrus.Info("Creating API endpoints...")
+	container.Filter(s.headersFilter)
+	container.Filter(s.prettyFilter)
+	if s.requestTimeout > 0 {
+		container.Filter(s.timeoutFilter)
+	}
 
 	ws := &restful.WebService{}
 	ws.Path("/api/v1")
 
 	ws.Route(ws.GET("boot/{mac-addr}").To(s.handleBootRequest).
+		Filter(s.bootACLFilter).
+		Filter(s.statsFilter).
+		Filter(s.debugSampleFilter).
+		Filter(s.quiesceFilter).
 		Consumes(restful.MIME_JSON).
-		Produces(restful.MIME_JSON).
+		Produces(append(responseContentTypes, IPXEContentType, ExtendedContentType)...).
+		Doc("boot configuration for a mac address").
 		Param(ws.PathParameter("mac-addr", "the mac address")).
-		Writes(PixieResponse{}))
+		Param(ws.QueryParameter("format", "ipxe for an iPXE script instead of json")).
+		Param(ws.QueryParameter("arch", "the client architecture")).
+		Param(ws.QueryParameter("firmware", "the client firmware, uefi or bios")).
+		Param(ws.QueryParameter("override", "a signed break-glass override token")).
+		Param(ws.QueryParameter("explain", "true for how the request was resolved instead, with -debug")).
+		Param(ws.HeaderParameter(OverrideCmdlineHeader, "replaces the cmdline with -allow-header-overrides")).
+		Writes(PixieResponse{}).
+		Returns(http.StatusOK, "boot configuration", PixieResponse{}).
+		Returns(http.StatusBadRequest, "malformed mac address", nil).
+		Returns(http.StatusNotFound, "no configuration defined", nil).
+		Returns(http.StatusForbidden, "client outside the boot acl", nil).
+		Returns(http.StatusServiceUnavailable, "server store unavailable or reload in progress", nil))
 	logrus.Info(`pixiecore endpoint created at "api/v1/boot/{mac}".`)
 
+	ws.Route(ws.GET("boot/{mac-addr}/vlan/{vlan}").To(s.handleVLANBootRequest).
+		Filter(s.bootACLFilter).
+		Filter(s.statsFilter).
+		Filter(s.debugSampleFilter).
+		Filter(s.quiesceFilter).
+		Consumes(restful.MIME_JSON).
+		Produces(append(responseContentTypes, IPXEContentType, ExtendedContentType)...).
+		Doc("boot configuration for a mac address on a vlan, falling back to the mac address alone").
+		Param(ws.PathParameter("mac-addr", "the mac address")).
+		Param(ws.PathParameter("vlan", "the vlan id").DataType("integer")).
+		Param(ws.QueryParameter("format", "ipxe for an iPXE script instead of json")).
+		Param(ws.QueryParameter("arch", "the client architecture")).
+		Param(ws.QueryParameter("firmware", "the client firmware, uefi or bios")).
+		Param(ws.QueryParameter("override", "a signed break-glass override token")).
+		Param(ws.HeaderParameter(OverrideCmdlineHeader, "replaces the cmdline with -allow-header-overrides")).
+		Writes(PixieResponse{}).
+		Returns(http.StatusOK, "boot configuration", PixieResponse{}).
+		Returns(http.StatusBadRequest, "malformed mac address or vlan", nil).
+		Returns(http.StatusNotFound, "no configuration defined", nil).
+		Returns(http.StatusForbidden, "client outside the boot acl", nil).
+		Returns(http.StatusServiceUnavailable, "server store unavailable or reload in progress", nil))
+	logrus.Info(`pixiecore endpoint created at "api/v1/boot/{mac}/vlan/{vlan}".`)
+
+	ws.Route(ws.GET("boot/serial/{serial}").To(s.handleSerialBootRequest).
+		Filter(s.bootACLFilter).
+		Filter(s.statsFilter).
+		Filter(s.debugSampleFilter).
+		Filter(s.quiesceFilter).
+		Consumes(restful.MIME_JSON).
+		Produces(append(responseContentTypes, IPXEContentType, ExtendedContentType)...).
+		Doc("boot configuration for a system serial number").
+		Param(ws.PathParameter("serial", "the system serial number")).
+		Param(ws.QueryParameter("format", "ipxe for an iPXE script instead of json")).
+		Param(ws.QueryParameter("arch", "the client architecture")).
+		Param(ws.QueryParameter("firmware", "the client firmware, uefi or bios")).
+		Param(ws.QueryParameter("override", "a signed break-glass override token")).
+		Param(ws.HeaderParameter(OverrideCmdlineHeader, "replaces the cmdline with -allow-header-overrides")).
+		Writes(PixieResponse{}).
+		Returns(http.StatusOK, "boot configuration", PixieResponse{}).
+		Returns(http.StatusNotFound, "no configuration defined", nil).
+		Returns(http.StatusForbidden, "client outside the boot acl", nil).
+		Returns(http.StatusServiceUnavailable, "server store unavailable or reload in progress", nil))
+	logrus.Info(`pixiecore endpoint created at "api/v1/boot/serial/{serial}".`)
+
+	ws.Route(ws.GET("boot/ip/{ip}").To(s.handleIPBootRequest).
+		Filter(s.bootACLFilter).
+		Filter(s.statsFilter).
+		Filter(s.debugSampleFilter).
+		Filter(s.quiesceFilter).
+		Consumes(restful.MIME_JSON).
+		Produces(append(responseContentTypes, IPXEContentType, ExtendedContentType)...).
+		Doc("boot configuration for the mac address leased or configured for an ip address").
+		Param(ws.PathParameter("ip", "the ipv4 or ipv6 address")).
+		Param(ws.QueryParameter("format", "ipxe for an iPXE script instead of json")).
+		Param(ws.QueryParameter("arch", "the client architecture")).
+		Param(ws.QueryParameter("firmware", "the client firmware, uefi or bios")).
+		Param(ws.QueryParameter("override", "a signed break-glass override token")).
+		Param(ws.HeaderParameter(OverrideCmdlineHeader, "replaces the cmdline with -allow-header-overrides")).
+		Writes(PixieResponse{}).
+		Returns(http.StatusOK, "boot configuration", PixieResponse{}).
+		Returns(http.StatusBadRequest, "malformed ip address", nil).
+		Returns(http.StatusNotFound, "no mac mapped to the ip or no configuration defined", nil).
+		Returns(http.StatusForbidden, "client outside the boot acl", nil).
+		Returns(http.StatusServiceUnavailable, "server store unavailable or reload in progress", nil))
+	logrus.Info(`pixiecore endpoint created at "api/v1/boot/ip/{ip}".`)
+
+	ws.Route(ws.GET("static/{resource:*}").To(s.handleStaticRequest).
+		Doc("asset from the assets directory").
+		Param(ws.PathParameter("resource", "the asset path")).
+		Returns(http.StatusOK, "asset", nil).
+		Returns(http.StatusNotFound, "no such asset", nil))
+	logrus.Info(`static endpoint created at "api/v1/static/{resource}".`)
+
+	ws.Route(ws.GET("cache/{key}").To(s.handleCacheRequest).
+		Doc("cached copy of a remote asset").
+		Param(ws.PathParameter("key", "the cached asset key")).
+		Returns(http.StatusOK, "asset", nil).
+		Returns(http.StatusNotFound, "no such cached asset", nil))
+	logrus.Info(`cache endpoint created at "api/v1/cache/{key}".`)
+
+	ws.Route(ws.GET("macs").To(s.handleMacsRequest).
+		Filter(s.adminFilter).
+		Doc("configured mac addresses").
+		Returns(http.StatusOK, "mac addresses", []string{}).
+		Returns(http.StatusUnauthorized, "missing or invalid admin token", nil).
+		Returns(http.StatusForbidden, "client outside the admin acl", nil).
+		Produces(restful.MIME_JSON).
+		Param(ws.QueryParameter("include-disabled", "include disabled servers").DataType("boolean")).
+		Param(ws.QueryParameter("hostnames", "return objects with hostnames").DataType("boolean")).
+		Writes([]MacEntry{}))
+	logrus.Info(`macs endpoint created at "api/v1/macs".`)
+
+	ws.Route(ws.POST("servers/bulk").To(s.handleBulkImportRequest).
+		Filter(s.adminFilter).
+		Doc("add servers atomically").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON).
+		Reads([]Server{}).
+		Writes(BulkReport{}).
+		Returns(http.StatusOK, "servers added", BulkReport{}).
+		Returns(http.StatusUnprocessableEntity, "batch rejected", BulkReport{}).
+		Returns(http.StatusUnauthorized, "missing or invalid admin token", nil).
+		Returns(http.StatusForbidden, "client outside the admin acl", nil))
+	logrus.Info(`bulk import endpoint created at "api/v1/servers/bulk".`)
+
+	ws.Route(ws.GET("servers").To(s.handleServersRequest).
+		Filter(s.adminFilter).
+		Doc("list servers").
+		Produces(restful.MIME_JSON).
+		Param(ws.QueryParameter("tag", "only list servers with this tag")).
+		Param(ws.QueryParameter("group", "only list servers in this group")).
+		Writes([]Server{}).
+		Returns(http.StatusOK, "servers", []Server{}).
+		Returns(http.StatusUnauthorized, "missing or invalid admin token", nil).
+		Returns(http.StatusForbidden, "client outside the admin acl", nil))
+	logrus.Info(`servers endpoint created at "api/v1/servers".`)
+
+	ws.Route(ws.PATCH("groups/{group}").To(s.handleGroupUpdateRequest).
+		Filter(s.adminFilter).
+		Doc("update the boot entry of every server in a group").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON).
+		Param(ws.PathParameter("group", "the server group")).
+		Reads(BootEntry{}).
+		Writes(GroupUpdate{}).
+		Returns(http.StatusOK, "servers updated", GroupUpdate{}).
+		Returns(http.StatusNotFound, "no servers in the group", nil).
+		Returns(http.StatusUnauthorized, "missing or invalid admin token", nil).
+		Returns(http.StatusForbidden, "client outside the admin acl", nil))
+	logrus.Info(`group update endpoint created at "api/v1/groups/{group}".`)
+
+	ws.Route(ws.GET("audit").To(s.handleAuditRequest).
+		Filter(s.adminFilter).
+		Doc("recent boot decisions, newest first").
+		Produces(restful.MIME_JSON).
+		Param(ws.QueryParameter("limit", "the maximum number of decisions returned").DataType("integer")).
+		Writes([]AuditEntry{}).
+		Returns(http.StatusOK, "boot decisions", []AuditEntry{}).
+		Returns(http.StatusBadRequest, "invalid limit", nil).
+		Returns(http.StatusUnauthorized, "missing or invalid admin token", nil).
+		Returns(http.StatusForbidden, "client outside the admin acl", nil))
+
+	ws.Route(ws.GET("unknown-macs").To(s.handleUnknownMACsRequest).
+		Filter(s.adminFilter).
+		Doc("MACs booting without a server of their own, and malformed MACs").
+		Produces(restful.MIME_JSON).
+		Writes(UnknownMACsSnapshot{}).
+		Returns(http.StatusOK, "unknown macs", UnknownMACsSnapshot{}).
+		Returns(http.StatusUnauthorized, "missing or invalid admin token", nil).
+		Returns(http.StatusForbidden, "client outside the admin acl", nil))
+	ws.Route(ws.GET("stats").To(s.handleStatsRequest).
+		Filter(s.adminFilter).
+		Doc("boot stats").
+		Produces(restful.MIME_JSON).
+		Writes(StatsSnapshot{}).
+		Returns(http.StatusOK, "boot stats", StatsSnapshot{}).
+		Returns(http.StatusUnauthorized, "missing or invalid admin token", nil).
+		Returns(http.StatusForbidden, "client outside the admin acl", nil))
+	ws.Route(ws.POST("stats/reset").To(s.handleStatsResetRequest).
+		Filter(s.adminFilter).
+		Doc("clear the boot stats").
+		Produces(restful.MIME_JSON).
+		Writes(StatsSnapshot{}).
+		Returns(http.StatusOK, "boot stats before the reset", StatsSnapshot{}).
+		Returns(http.StatusUnauthorized, "missing or invalid admin token", nil).
+		Returns(http.StatusForbidden, "client outside the admin acl", nil))
+	logrus.Info(`stats endpoints created at "api/v1/stats" and "api/v1/stats/reset".`)
+
+	ws.Route(ws.POST("pin/{mac-addr}").To(s.handlePinRequest).
+		Filter(s.adminFilter).
+		Doc("boot a mac address with a boot entry until the ttl passes").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON).
+		Param(ws.PathParameter("mac-addr", "the mac address")).
+		Reads(PinRequest{}).
+		Writes(Pin{}).
+		Returns(http.StatusOK, "pinned", Pin{}).
+		Returns(http.StatusBadRequest, "malformed mac address, missing kernel or invalid ttl", nil).
+		Returns(http.StatusUnauthorized, "missing or invalid admin token", nil).
+		Returns(http.StatusForbidden, "client outside the admin acl", nil))
+	ws.Route(ws.DELETE("pin/{mac-addr}").To(s.handleUnpinRequest).
+		Filter(s.adminFilter).
+		Doc("remove the pin of a mac address").
+		Produces(restful.MIME_JSON).
+		Param(ws.PathParameter("mac-addr", "the mac address")).
+		Writes(Pin{}).
+		Returns(http.StatusOK, "pin removed", Pin{}).
+		Returns(http.StatusNotFound, "mac address isn't pinned", nil).
+		Returns(http.StatusUnauthorized, "missing or invalid admin token", nil).
+		Returns(http.StatusForbidden, "client outside the admin acl", nil))
+	logrus.Info(`pin endpoint created at "api/v1/pin/{mac}".`)
+
+	ws.Route(ws.POST("validate").To(s.handleValidateRequest).
+		Filter(s.adminFilter).
+		Doc("validate a candidate config without applying it").
+		Produces(restful.MIME_JSON).
+		Reads(Spriteful{}).
+		Writes(ValidationReport{}).
+		Returns(http.StatusOK, "valid, with warnings", ValidationReport{}).
+		Returns(http.StatusUnprocessableEntity, "invalid", ValidationReport{}).
+		Returns(http.StatusUnauthorized, "missing or invalid admin token", nil).
+		Returns(http.StatusForbidden, "client outside the admin acl", nil))
+	logrus.Info(`validate endpoint created at "api/v1/validate".`)
+
+	ws.Route(ws.POST("drain").To(s.handleDrainRequest).
+		Filter(s.adminFilter).
+		Doc("refuse new boot requests and report not ready").
+		Produces(restful.MIME_JSON).
+		Writes(HealthStatus{}).
+		Returns(http.StatusOK, "drained", HealthStatus{}).
+		Returns(http.StatusUnauthorized, "missing or invalid admin token", nil).
+		Returns(http.StatusForbidden, "client outside the admin acl", nil))
+	ws.Route(ws.POST("undrain").To(s.handleUndrainRequest).
+		Filter(s.adminFilter).
+		Doc("serve boot requests again").
+		Produces(restful.MIME_JSON).
+		Writes(HealthStatus{}).
+		Returns(http.StatusOK, "undrained", HealthStatus{}).
+		Returns(http.StatusUnauthorized, "missing or invalid admin token", nil).
+		Returns(http.StatusForbidden, "client outside the admin acl", nil))
+	logrus.Info(`drain endpoints created at "api/v1/drain" and "api/v1/undrain".`)
+
 	container.Add(ws)
+	s.registerHealth(container)
+	if s.docs {
+		s.registerDocs(container)
+	}
 }
 
 // Handles the http request for server boot configuration.
 func (s *Spriteful) handleBootRequest(req *restful.Request, res *restful.Response) {
 	logrus.Info("Received pixiecore request...")
+	s = s.withSnapshot()
 	macAddress := req.PathParameter("mac-addr")
-	server, err := s.findServerConfig(macAddress)
-	if err != nil {
-		res.WriteError(http.StatusNotFound, err)
+	if err := validMAC(macAddress); err != nil {
+		s.unknown.recordMalformed(macAddress, s.now())
+		writeBootError(res, http.StatusBadRequest, err)
 		return
 	}
+	s.bootMAC(req, res, macAddress, 0)
+}
 
-	str, err := json.Marshal(&PixieResponse{
-		Kernel:      server.Kernel,
-		Initrd:      server.Initrd,
-		CommandLine: server.CommandLine,
-	})
+// Writes the boot response for the MAC on the VLAN, 0 for none, see
+// lookupServer. With -debug, ?explain=true writes how it was resolved
+// instead.
+func (s *Spriteful) bootMAC(req *restful.Request, res *restful.Response, macAddress string, vlan int) {
+	if s.debug && req.QueryParameter("explain") == "true" {
+		s.writeExplanation(req, res, macAddress, vlan)
+		return
+	}
+	server, err := s.lookupServer(req.Request.Context(), macAddress, vlan, clientIP(req))
+	if budgetExceeded(req.Request.Context(), err) {
+		writeBootError(res, http.StatusGatewayTimeout, errBudgetExceeded)
+		return
+	}
+	if errors.Is(err, ErrStoreUnavailable) {
+		writeBootError(res, http.StatusServiceUnavailable, err)
+		return
+	}
 	if err != nil {
-		res.WriteError(http.StatusBadRequest, err)
+		s.stats.miss(macAddress)
+		s.recordBoot(AuditEntry{Time: s.now(), MacAddress: macKey(macAddress), ClientIP: clientIP(req), Match: MatchNone, Status: http.StatusNotFound})
+		s.compareShadow(req, macAddress, nil)
+		writeBootError(res, http.StatusNotFound, err)
 		return
 	}
+	s.writeBootResponse(req, res, server)
+}
 
-	str = bytes.Replace(str, []byte("\\u003c"), []byte("<"), -1)
-	str = bytes.Replace(str, []byte("\\u003e"), []byte(">"), -1)
-	str = bytes.Replace(str, []byte("\\u0026"), []byte("&"), -1)
+// Returns the server to boot for the MAC on the VLAN, 0 for none, trying
+// each layer in turn: its pin, its config for the VLAN, its config from the
+// store, a matching wildcard server, the fallback entry and the discovery
+// server. When every layer misses, the store's error is returned and the
+// request 404s. Store lookups give up once the context is done. REST and
+// gRPC boot requests both resolve through here; group and global defaults
+// are applied later by resolveFor. With an explanation in the context, each
+// layer tried is recorded in it, and unknown machines are neither recorded
+// nor reported to the discovery webhook.
+func (s *Spriteful) lookupServer(ctx context.Context, macAddress string, vlan int, clientIP string) (*Server, error) {
+	explanation := explanationFrom(ctx)
+	if pin, ok := s.pins.lookup(macAddress, s.now()); ok {
+		explanation.step(MatchPin, StepMatched, pin.Kernel)
+		return pin.server(), nil
+	}
+	explanation.step(MatchPin, StepMissed, "")
+	if vlans, ok := s.serverStore().(VLANStore); ok && vlan > 0 {
+		if server, err := vlans.LookupVLAN(macAddress, vlan); err == nil {
+			explanation.step(MatchVLAN, StepMatched, fmt.Sprint(vlan))
+			return withMatch(server, MatchVLAN), nil
+		}
+		explanation.step(MatchVLAN, StepMissed, fmt.Sprint(vlan))
+	}
+	server, err := lookupContext(ctx, s.serverStore(), macAddress)
+	if errors.Is(err, ErrStoreUnavailable) || ctx.Err() != nil {
+		return nil, err
+	}
+	if err == nil {
+		explanation.step(MatchExact, StepMatched, "")
+		return withMatch(server, MatchExact), nil
+	}
+	explanation.step(MatchExact, StepMissed, err.Error())
+	if wildcards, ok := s.serverStore().(WildcardStore); ok {
+		if wildcard, wildcardErr := wildcards.LookupWildcard(macAddress, clientIP); wildcardErr == nil {
+			explanation.step(MatchWildcard, StepMatched, wildcard.MacAddress)
+			return withMatch(wildcard, MatchWildcard), nil
+		}
+		explanation.step(MatchWildcard, StepMissed, "")
+	}
+	if fallback := s.config().Fallback; fallback != nil {
+		explanation.step(MatchFallback, StepMatched, "")
+		if explanation == nil {
+			logrus.Infof(`booting unknown machine "%s" with the fallback entry.`, macAddress)
+			s.unknown.record(macAddress, MatchFallback, s.now())
+		}
+		return withMatch(fallback.under(&Server{MacAddress: macAddress}), MatchFallback), nil
+	}
+	explanation.step(MatchFallback, StepMissed, "")
+	var discovered *Server
+	if explanation != nil {
+		discovered = s.discovery.server(macAddress, "")
+	} else if discovered = s.discovery.boot(macAddress, "", s.now()); discovered != nil {
+		s.unknown.record(macAddress, MatchDiscovery, s.now())
+	}
+	if discovered != nil {
+		explanation.step(MatchDiscovery, StepMatched, discovered.Kernel)
+		return withMatch(discovered, MatchDiscovery), nil
+	}
+	explanation.step(MatchDiscovery, StepMissed, "")
+	if explanation == nil {
+		s.unknown.record(macAddress, MatchNone, s.now())
+	}
+	return nil, err
+}
 
-	value := string(str)
-	value, err = url.QueryUnescape(value)
+// Handles the http request for server boot configuration keyed on serial number.
+func (s *Spriteful) handleSerialBootRequest(req *restful.Request, res *restful.Response) {
+	logrus.Info("Received pixiecore serial request...")
+	s = s.withSnapshot()
+	serial := req.PathParameter("serial")
+	var server *Server
+	err := fmt.Errorf("serial lookups are not supported by the store.")
+	if store, ok := s.serverStore().(ContextStore); ok {
+		server, err = store.LookupSerialContext(req.Request.Context(), serial)
+	} else if store, ok := s.serverStore().(SerialStore); ok {
+		server, err = store.LookupSerial(serial)
+	}
+	if budgetExceeded(req.Request.Context(), err) {
+		writeBootError(res, http.StatusGatewayTimeout, errBudgetExceeded)
+		return
+	}
+	if errors.Is(err, ErrStoreUnavailable) {
+		writeBootError(res, http.StatusServiceUnavailable, err)
+		return
+	}
 	if err != nil {
-		res.WriteError(http.StatusBadRequest, err)
+		if server = s.discovery.boot("", serial, s.now()); server == nil {
+			s.recordBoot(AuditEntry{Time: s.now(), ClientIP: clientIP(req), Match: MatchNone, Status: http.StatusNotFound})
+			writeBootError(res, http.StatusNotFound, err)
+			return
+		}
+		server = withMatch(server, MatchDiscovery)
+	} else {
+		server = withMatch(server, MatchSerial)
+	}
+	s.writeBootResponse(req, res, server)
+}
+
+// Writes the pixiecore boot response for the server.
+func (s *Spriteful) writeBootResponse(req *restful.Request, res *restful.Response, server *Server) {
+	server = s.resolveServer(req, server)
+	s.compareShadow(req, server.MacAddress, server)
+	server = s.applyHeaderOverride(req, server)
+	server.Headers.set(res)
+	if server.DelegateURL != "" {
+		s.writeBootDelegate(req, res, server)
 		return
 	}
+	if status := server.responseStatus(); status != http.StatusOK {
+		s.writeBootStatus(req, res, server, status)
+		return
+	}
+	if server.Kernel == LocalBoot {
+		s.writeLocalBoot(req, res, server)
+		return
+	}
+	response := s.bootResponse(server, req.QueryParameter("arch"), clientIP(req))
+	if s.maxCmdline > 0 && len(response.CommandLine) > s.maxCmdline {
+		logrus.Warnf(`cmdline sent to "%s" is %d bytes, longer than the %d bootloaders may keep.`, server.MacAddress, len(response.CommandLine), s.maxCmdline)
+	}
+	if s.cmdlineSyntax != "" && s.cmdlineSyntax != CmdlineSyntaxOff {
+		if err := Cmdline(response.CommandLine).checkSyntax(); err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Warnf(`cmdline sent to "%s" is malformed.`, server.MacAddress)
+		}
+	}
+	if s.cache != nil {
+		response.Kernel = s.cache.rewrite(response.Kernel, req)
+		for i, initrd := range response.Initrd {
+			response.Initrd[i] = s.cache.rewrite(initrd, req)
+		}
+	}
+
+	if hash := s.config().configHash; hash != "" {
+		res.Header().Set(ConfigHashHeader, hash)
+	}
+	if server.sendsIPXE(req) && s.streamsIPXE() {
+		res.Header().Set("Content-Type", IPXEContentType)
+		passThrough(res)
+		if err := s.writeIPXE(req.Request.Context(), res.ResponseWriter, response, server); err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Warnf(`iPXE script to "%s" was cut short.`, server.MacAddress)
+			s.stats.cutShort(server.MacAddress)
+			return
+		}
+	} else {
+		var value string
+		if server.sendsIPXE(req) {
+			value = s.encodeIPXE(req.Request.Context(), response, server)
+			res.Header().Set("Content-Type", IPXEContentType)
+		} else if server.sendsExtended(req) {
+			var err error
+			if value, err = encodeExtendedResponse(response, server, s.rawURLs(server)); err != nil {
+				writeBootError(res, http.StatusBadRequest, err)
+				return
+			}
+			res.Header().Set("Content-Type", ExtendedContentType)
+		} else {
+			var err error
+			if value, err = s.encodePixieResponse(response, s.rawURLs(server)); err != nil {
+				writeBootError(res, http.StatusBadRequest, err)
+				return
+			}
+			res.Header().Set("Content-Type", server.responseContentType())
+		}
+
+		value = s.responseHook.transform(req.Request.Context(), value, server.MacAddress, res.Header().Get("Content-Type"))
+		logBootResponse(http.StatusOK, value)
+		if s.signingKey != nil {
+			res.Header().Set(SignatureHeader, signBody(s.signingKey, []byte(value)))
+		}
+		res.Header().Set("Content-Length", strconv.Itoa(len(value)))
+		passThrough(res)
+		if written, err := io.WriteString(res.ResponseWriter, value); err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Warnf(`boot response to "%s" was cut short after %d of %d bytes.`, server.MacAddress, written, len(value))
+			s.stats.cutShort(server.MacAddress)
+			return
+		}
+	}
+	s.stats.boot(server.MacAddress, s.now())
+	s.recordBoot(AuditEntry{Time: s.now(), MacAddress: macKey(server.MacAddress), Kernel: response.Kernel, ClientIP: clientIP(req), Match: server.match, Status: http.StatusOK})
+}
+
+// Answers a boot request with the server's configured status instead of a
+// boot response: a redirect to its redirect URL, or no content.
+func (s *Spriteful) writeBootStatus(req *restful.Request, res *restful.Response, server *Server, status int) {
+	logrus.Infof(`answering "%s" with status %d.`, server.MacAddress, status)
+	if isRedirect(status) {
+		res.Header().Set("Location", server.RedirectURL)
+	}
+	logBootResponse(status, "")
+	res.WriteHeader(status)
+	s.recordBoot(AuditEntry{Time: s.now(), MacAddress: macKey(server.MacAddress), ClientIP: clientIP(req), Match: server.match, Status: status})
+}
+
+// Returns the boot response for the resolved server, with templates
+// rendered for the request, relative URLs resolved against the base URL and
+// the rewrite rules applied for the client.
+func (s *Spriteful) bootResponse(server *Server, arch, clientIP string) *PixieResponse {
+	cfg := s.config()
+	ctx := templateContext(server, arch, clientIP)
+	response := &PixieResponse{
+		Kernel:      resolveAsset(cfg.BaseURL, renderTemplate(server.Kernel, ctx)),
+		Initrd:      initrdURLs(server.Initrd),
+		CommandLine: renderTemplate(string(server.CommandLine), ctx),
+	}
+	for i, initrd := range response.Initrd {
+		response.Initrd[i] = resolveAsset(cfg.BaseURL, renderTemplate(initrd, ctx))
+	}
+	if rules := cfg.RewriteRules; len(rules) > 0 {
+		response.Kernel = rewriteURL(rules, response.Kernel, clientIP)
+		for i, initrd := range response.Initrd {
+			response.Initrd[i] = rewriteURL(rules, initrd, clientIP)
+		}
+	}
+	return response
+}
+
+// Returns the config to boot the server with for the request: its entry for
+// the request's firmware, under the User-Agent rule matching the client,
+// resolved as in resolveFor. Each step is recorded in the explanation in the
+// request's context, if any.
+func (s *Spriteful) resolveServer(req *restful.Request, server *Server) *Server {
+	explanation := explanationFrom(req.Request.Context())
+	firmware := requestFirmware(req)
+	server = explanation.resolved("firmware", server, server.forFirmware(firmware), firmware)
+	userAgent := req.HeaderParameter("User-Agent")
+	server = explanation.resolved("user-agent", server, s.forUserAgent(userAgent, server), userAgent)
+	return s.resolveExplained(explanation, req.QueryParameter("arch"), req.QueryParameter("override"), server)
+}
+
+// Returns the config to boot the server with: a valid override token applied
+// over its active maintenance window, over the server, over the defaults of
+// the arch, over the defaults of its group, over the default kernel and
+// initrd.
+func (s *Spriteful) resolveFor(arch, override string, server *Server) *Server {
+	return s.resolveExplained(nil, arch, override, server)
+}
+
+// Resolves the server as resolveFor, recording each step in the
+// explanation, which may be nil.
+func (s *Spriteful) resolveExplained(explanation *Explanation, arch, override string, server *Server) *Server {
+	cfg := s.config()
+	server = explanation.resolved("window", server, server.atTime(s.now()), "")
+	if defaults, ok := cfg.ArchDefaults[arch]; ok {
+		server = explanation.resolved("arch-defaults", server, defaults.under(server), arch)
+	} else {
+		explanation.step("arch-defaults", StepSkipped, arch)
+	}
+	if defaults, ok := cfg.GroupDefaults[server.Group]; ok && server.Group != "" {
+		server = explanation.resolved("group-defaults", server, defaults.under(server), server.Group)
+	} else {
+		explanation.step("group-defaults", StepSkipped, server.Group)
+	}
+	server = explanation.resolved("default-images", server, s.withDefaultImages(server), "")
+	return explanation.resolved("override", server, s.applyOverride(override, server), "")
+}
+
+// Returns the server with the default kernel and initrd filled in where it
+// has none, or the server itself when there's nothing to fill in.
+func (s *Spriteful) withDefaultImages(server *Server) *Server {
+	cfg := s.config()
+	if (server.Kernel == "" && cfg.DefaultKernel != "") || (server.Initrd == nil && cfg.DefaultInitrd != nil) {
+		resolved := *server
+		if resolved.Kernel == "" {
+			resolved.Kernel = cfg.DefaultKernel
+		}
+		if resolved.Initrd == nil {
+			resolved.Initrd = cfg.DefaultInitrd
+		}
+		return &resolved
+	}
+	return server
+}
+
+// Returns the current time from the injectable clock.
+func (s *Spriteful) now() time.Time {
+	if s.clock != nil {
+		return s.clock()
+	}
+	return time.Now()
+}
 
-	fmt.Fprint(res.ResponseWriter, value)
+// Reports whether the kernel and initrd URLs are sent to the server without
+// URL-unescaping, under either the raw-urls or the deprecated raw-cmdline
+// name.
+func (s *Spriteful) rawURLs(server *Server) bool {
+	cfg := s.config()
+	return cfg.RawURLs || cfg.RawCmdline || server.RawURLs || server.RawCmdline
+}
+
+// Encodes the boot response without HTML escaping. The cmdline is always
+// emitted verbatim so quoting and literal % or + survive. Unless raw is set,
+// the kernel and initrd URLs are URL-unescaped as they always have been.
+func encodeResponse(response *PixieResponse, raw bool) (string, error) {
+	if !raw {
+		var err error
+		if response, err = unescapeResponse(response); err != nil {
+			return "", err
+		}
+	}
+	return encodeJSON(response)
+}
+
+// Returns a copy of the response with the kernel and initrd URLs
+// URL-unescaped.
+func unescapeResponse(response *PixieResponse) (*PixieResponse, error) {
+	unescaped := *response
+	kernel, err := url.QueryUnescape(response.Kernel)
+	if err != nil {
+		return nil, err
+	}
+	unescaped.Kernel = kernel
+	if response.Initrd != nil {
+		unescaped.Initrd = make([]string, len(response.Initrd))
+		for i, initrd := range response.Initrd {
+			if unescaped.Initrd[i], err = url.QueryUnescape(initrd); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &unescaped, nil
+}
+
+// Encodes the value as JSON without HTML escaping or a trailing newline.
+func encodeJSON(value interface{}) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// Writes a boot error response.
+func writeBootError(res *restful.Response, status int, err error) {
+	logBootResponse(status, err.Error())
+	res.WriteError(status, err)
+}
+
+// Logs the exact boot response body and status at debug level.
+func logBootResponse(status int, body string) {
+	if !logrus.IsLevelEnabled(logrus.DebugLevel) {
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"status": status,
+		"body":   body,
+	}).Debug("boot response sent.")
 }
 
 // Returns the server config or an error for the requested MAC address.
 func (s *Spriteful) findServerConfig(macAddress string) (*Server, error) {
 	logrus.Infof(`requesting configuration for server "%s".`, macAddress)
-	for _, server := range s.Servers {
-		if strings.EqualFold(macAddress, server.MacAddress) {
+	key := macKey(macAddress)
+	for _, server := range s.config().Servers {
+		if !server.Disabled && server.VLAN == 0 && key == macKey(server.MacAddress) {
 			logrus.Info("configuration found.")
 			return &server, nil
 		}
@@ -152,3 +1344,109 @@ func (s *Spriteful) findServerConfig(macAddress string) (*Server, error) {
 	logrus.Warn("configuration not found.")
 	return nil, errors.New(fmt.Sprintf("no configuration defined for %s.", macAddress))
 }
+
+// Handles the http request listing the configured MAC addresses.
+func (s *Spriteful) handleMacsRequest(req *restful.Request, res *restful.Response) {
+	includeDisabled := req.QueryParameter("include-disabled") == "true"
+	var macs []string
+	entries := []MacEntry{}
+	for _, server := range sortedServers(s.serverStore().List(), s.sortServers) {
+		if server.Disabled && !includeDisabled {
+			continue
+		}
+		mac := formatMAC(macKey(server.MacAddress), s.macFormat)
+		macs = append(macs, mac)
+		entries = append(entries, MacEntry{
+			MacAddress: mac,
+			Hostname:   server.Hostname,
+			Disabled:   server.Disabled,
+		})
+	}
+	if req.QueryParameter("hostnames") == "true" {
+		res.WriteAsJson(entries)
+		return
+	}
+	if macs == nil {
+		macs = []string{}
+	}
+	res.WriteAsJson(macs)
+}
+
+// Handles the http request for a static asset.
+func (s *Spriteful) handleStaticRequest(req *restful.Request, res *restful.Response) {
+	if s.assetsDir == "" {
+		res.WriteErrorString(http.StatusNotFound, "static assets are disabled.")
+		return
+	}
+	path, err := s.findResource(req.PathParameter("resource"))
+	if err != nil {
+		res.WriteError(http.StatusNotFound, err)
+		return
+	}
+	http.ServeFile(res.ResponseWriter, req.Request, path)
+}
+
+// Returns the path of the requested asset within the assets directory or an
+// error if it doesn't exist.
+func (s *Spriteful) findResource(resource string) (string, error) {
+	path := filepath.Join(s.assetsDir, filepath.Clean("/"+resource))
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("no resource %s.", resource)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("resource %s is a directory.", resource)
+	}
+	return path, nil
+}
+
+// Returns the server config or an error for the requested serial number.
+func (s *Spriteful) findServerBySerial(serial string) (*Server, error) {
+	logrus.Infof(`requesting configuration for serial "%s".`, serial)
+	cfg := s.config()
+	if i, ok := cfg.serials[serialKey(serial)]; ok {
+		logrus.Info("configuration found.")
+		server := cfg.Servers[i]
+		return &server, nil
+	}
+	logrus.Warn("configuration not found.")
+	return nil, fmt.Errorf("no configuration defined for serial %s.", serial)
+}
+
+// Builds the lookup indexes for the configured servers.
+func (s *Spriteful) buildIndex() {
+	s.serials = make(map[string]int)
+	s.vlans = make(map[string]int)
+	for i := range s.Servers {
+		s.indexServer(i)
+	}
+}
+
+// Adds the server at index i to the lookup indexes.
+func (s *Spriteful) indexServer(i int) {
+	server := &s.Servers[i]
+	if server.Disabled {
+		return
+	}
+	if server.VLAN > 0 {
+		if _, ok := s.vlans[server.key()]; ok {
+			logrus.Warnf(`duplicate server for "%s" on vlan %d ignored.`, server.MacAddress, server.VLAN)
+		} else {
+			s.vlans[server.key()] = i
+		}
+	}
+	if server.Serial == "" {
+		return
+	}
+	key := serialKey(server.Serial)
+	if _, ok := s.serials[key]; ok {
+		logrus.Warnf(`duplicate serial "%s" for "%s" ignored.`, server.Serial, server.MacAddress)
+		return
+	}
+	s.serials[key] = i
+}
+
+// Returns the index key for a serial number.
+func serialKey(serial string) string {
+	return strings.ToLower(strings.TrimSpace(serial))
+}
@@ -1,8 +1,16 @@
 package main
 
 import (
+	"errors"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 
 	"github.com/emicklei/go-restful"
 )
@@ -10,11 +18,28 @@ import (
 var (
 	validRoutes = []string{
 		"/api/v1/boot/{mac-addr}",
+		"/api/v1/boot/{mac-addr}/vlan/{vlan}",
+		"/api/v1/boot/serial/{serial}",
+		"/api/v1/boot/ip/{ip}",
 		"/api/v1/static/{resource:*}",
+		"/api/v1/cache/{key}",
+		"/api/v1/macs",
+		"/api/v1/servers/bulk",
+		"/api/v1/servers",
+		"/api/v1/groups/{group}",
+		"/api/v1/audit",
+		"/api/v1/stats",
+		"/api/v1/stats/reset",
+		"/api/v1/drain",
+		"/api/v1/undrain",
+		"/api/v1/pin/{mac-addr}",
+		"/api/v1/validate",
+		"/api/v1/unknown-macs",
 		"/api/v1/template/{template:*}",
 	}
 	validMac    = "00:00:00:00:00:00"
 	invalidMac  = "00:00:00:00:00:01"
+	validSerial = "SN-0001"
 	testFile    = "/tmp/test"
 	invalidFile = "someweirdfile"
 )
@@ -24,13 +49,13 @@ func TestRouteRegister(t *testing.T) {
 	c := restful.NewContainer()
 	s.register(c)
 	services := c.RegisteredWebServices()
-	if serviceCount := len(services); serviceCount != 1 {
-		t.Errorf("only one service is expected, services: %d", serviceCount)
+	if serviceCount := len(services); serviceCount != 2 {
+		t.Errorf("only two services are expected, services: %d", serviceCount)
 	}
 	service := services[0]
 	routes := service.Routes()
-	if routeCount := len(routes); routeCount != 4 {
-		t.Errorf("only four routes are expected. routes: %d", routeCount)
+	if routeCount := len(routes); routeCount != 19 {
+		t.Errorf("only nineteen routes are expected. routes: %d", routeCount)
 	}
 
 	for _, route := range routes {
@@ -63,6 +88,28 @@ func TestFindServer(t *testing.T) {
 	}
 }
 
+func TestFindServerBySerial(t *testing.T) {
+	s := &Spriteful{
+		Servers: []Server{
+			{
+				MacAddress: validMac,
+				Serial:     validSerial,
+			},
+		},
+	}
+	s.buildIndex()
+	server, err := s.findServerBySerial("sn-0001")
+	if err != nil {
+		t.Fatalf("%s config should be found, but it's not", validSerial)
+	}
+	if server.MacAddress != validMac {
+		t.Errorf("%s should resolve to %s, got %s", validSerial, validMac, server.MacAddress)
+	}
+	if _, err := s.findServerBySerial("SN-0002"); err == nil {
+		t.Errorf("SN-0002 config should not be found, but it is")
+	}
+}
+
 func TestFindResource(t *testing.T) {
 	s := &Spriteful{}
 	os.Create(testFile)
@@ -74,3 +121,292 @@ func TestFindResource(t *testing.T) {
 		t.Errorf("%s should not be found, but it is", invalidFile)
 	}
 }
+
+func TestServerMetaRoundTrip(t *testing.T) {
+	config := []byte(`{"servers":[{"mac":"00:00:00:00:00:00","meta":{"owner":"infra","ticket":"OPS-1"}}]}`)
+	var s Spriteful
+	if err := json.Unmarshal(config, &s); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(&s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved Spriteful
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"owner": "infra", "ticket": "OPS-1"}
+	if !reflect.DeepEqual(saved.Servers[0].Meta, want) {
+		t.Errorf("meta should round-trip, got %v", saved.Servers[0].Meta)
+	}
+}
+
+func TestMacsRequest(t *testing.T) {
+	s := &Spriteful{
+		Servers: []Server{
+			{MacAddress: "AA-BB-CC-DD-EE-FF", Hostname: "node1"},
+			{MacAddress: invalidMac, Disabled: true},
+		},
+		adminToken: "secret",
+	}
+	if res := serve(s, "GET", "/api/v1/macs", nil); res.Code != http.StatusUnauthorized {
+		t.Errorf("macs without a token should be unauthorized, status: %d", res.Code)
+	}
+
+	var macs []string
+	res := serve(s, "GET", "/api/v1/macs", http.Header{"Authorization": {"Bearer secret"}})
+	json.Unmarshal(res.Body.Bytes(), &macs)
+	if !reflect.DeepEqual(macs, []string{"aa:bb:cc:dd:ee:ff"}) {
+		t.Errorf("only the enabled normalized mac is expected, macs: %v", macs)
+	}
+
+	var entries []MacEntry
+	res = serve(s, "GET", "/api/v1/macs?include-disabled=true&hostnames=true", http.Header{"Authorization": {"Bearer secret"}})
+	json.Unmarshal(res.Body.Bytes(), &entries)
+	if len(entries) != 2 || entries[0].Hostname != "node1" || !entries[1].Disabled {
+		t.Errorf("both macs with hostnames are expected, entries: %+v", entries)
+	}
+}
+
+func TestPrettyJSON(t *testing.T) {
+	s := &Spriteful{Servers: []Server{{MacAddress: validMac, Kernel: "vmlinuz"}}}
+	if res := serve(s, "GET", "/api/v1/macs", nil); strings.Contains(res.Body.String(), "\n ") {
+		t.Errorf("API responses should be compact by default, body: %s", res.Body)
+	}
+	if res := serve(s, "GET", "/api/v1/macs?pretty=true", nil); !strings.Contains(res.Body.String(), "\n ") {
+		t.Errorf("pretty=true should indent API responses, body: %s", res.Body)
+	}
+	s.prettyJSON = true
+	if res := serve(s, "GET", "/api/v1/macs", nil); !strings.Contains(res.Body.String(), "\n ") {
+		t.Errorf("-pretty should indent API responses, body: %s", res.Body)
+	}
+	if res := serve(s, "GET", "/api/v1/boot/"+validMac+"?pretty=true", nil); strings.Contains(res.Body.String(), "\n") {
+		t.Errorf("boot responses should stay compact, body: %s", res.Body)
+	}
+}
+
+// Serves a request against the registered API and returns the recorded response.
+func serve(s *Spriteful, method, path string, header http.Header) *httptest.ResponseRecorder {
+	c := restful.NewContainer()
+	s.register(c)
+	req := httptest.NewRequest(method, path, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	res := httptest.NewRecorder()
+	normalizePath(c).ServeHTTP(res, req)
+	return res
+}
+
+func TestBootPathNormalization(t *testing.T) {
+	s := &Spriteful{Servers: []Server{{MacAddress: "aa:bb:cc:dd:ee:ff", Kernel: "http://images/vmlinuz"}}}
+	paths := []string{
+		"/api/v1/boot/aa:bb:cc:dd:ee:ff/",
+		"/api/v1/boot/aa:bb:cc:dd:ee:ff//",
+		"//api/v1/boot/aa:bb:cc:dd:ee:ff",
+		"/api/v1/boot/AA-BB-CC-DD-EE-FF/",
+	}
+	for _, path := range paths {
+		if res := serve(s, "GET", path, nil); res.Code != http.StatusOK {
+			t.Errorf("%s should resolve the boot route, status: %d", path, res.Code)
+		}
+	}
+	if res := serve(s, "GET", "/API/v1/boot/aa:bb:cc:dd:ee:ff/", nil); res.Code != http.StatusNotFound {
+		t.Errorf("literal path segments should be case-sensitive, status: %d", res.Code)
+	}
+}
+
+func TestBootMalformedMAC(t *testing.T) {
+	s := &Spriteful{Servers: []Server{{MacAddress: validMac, Kernel: "vmlinuz"}}}
+	if res := serve(s, "GET", "/api/v1/boot/not-a-mac", nil); res.Code != http.StatusBadRequest || !strings.Contains(res.Body.String(), "malformed mac") {
+		t.Errorf("a malformed mac should be a bad request, status: %d body: %s", res.Code, res.Body)
+	}
+	if res := serve(s, "GET", "/api/v1/boot/"+invalidMac, nil); res.Code != http.StatusNotFound {
+		t.Errorf("a valid mac without config should not be found, status: %d", res.Code)
+	}
+}
+
+func TestResponseContentType(t *testing.T) {
+	s := &Spriteful{Servers: []Server{
+		{MacAddress: validMac, Kernel: "vmlinuz"},
+		{MacAddress: invalidMac, Kernel: "vmlinuz", ContentType: "text/plain"},
+	}}
+	cases := map[string]string{validMac: restful.MIME_JSON, invalidMac: "text/plain"}
+	for mac, want := range cases {
+		res := serve(s, "GET", "/api/v1/boot/"+mac, http.Header{"Accept": {"text/plain"}})
+		if got := res.Header().Get("Content-Type"); res.Code != http.StatusOK || got != want {
+			t.Errorf("%s should be served as %s, status: %d content type: %s", mac, want, res.Code, got)
+		}
+	}
+	if err := (&Server{MacAddress: validMac, Kernel: "vmlinuz", ContentType: "text/html"}).validate(""); err == nil {
+		t.Errorf("content types outside the allowlist should be invalid")
+	}
+}
+
+func TestResponseStatus(t *testing.T) {
+	s := &Spriteful{Servers: []Server{
+		{MacAddress: "00:00:00:00:00:01", ResponseStatus: http.StatusNotFound},
+		{MacAddress: "00:00:00:00:00:02", ResponseStatus: http.StatusFound, RedirectURL: "http://other/api/v1/boot/00:00:00:00:00:02"},
+		{MacAddress: "00:00:00:00:00:03", Kernel: "vmlinuz", ResponseStatus: http.StatusTeapot},
+	}}
+	if res := serve(s, "GET", "/api/v1/boot/00:00:00:00:00:01", nil); res.Code != http.StatusNotFound || res.Body.Len() != 0 {
+		t.Errorf("a local boot server should get a bare 404, status: %d body: %s", res.Code, res.Body)
+	}
+	res := serve(s, "GET", "/api/v1/boot/00:00:00:00:00:02", nil)
+	if res.Code != http.StatusFound || res.Header().Get("Location") != s.Servers[1].RedirectURL {
+		t.Errorf("a redirected server should be redirected, status: %d location: %s", res.Code, res.Header().Get("Location"))
+	}
+	if res := serve(s, "GET", "/api/v1/boot/00:00:00:00:00:03", nil); res.Code != http.StatusOK {
+		t.Errorf("an invalid status should boot normally, status: %d", res.Code)
+	}
+
+	invalid := []Server{
+		s.Servers[2],
+		{MacAddress: validMac, ResponseStatus: http.StatusFound},
+		{MacAddress: validMac, ResponseStatus: http.StatusNoContent, RedirectURL: "http://other"},
+		{MacAddress: validMac, Kernel: "vmlinuz", RedirectURL: "http://other"},
+	}
+	for _, server := range invalid {
+		if err := server.validate(""); err == nil {
+			t.Errorf("%d with redirect url %q should be invalid", server.ResponseStatus, server.RedirectURL)
+		}
+	}
+	if err := s.Servers[0].validate(""); err != nil {
+		t.Errorf("a server answered with a status shouldn't need a kernel: %v", err)
+	}
+}
+
+func TestEncodeResponseRaw(t *testing.T) {
+	response := &PixieResponse{Kernel: "http://images/a%2Bb", CommandLine: "a=50%25 b=<x>&y c=1+1"}
+	legacy, err := encodeResponse(response, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"kernel":"http://images/a+b","initrd":null,"cmdline":"a=50%25 b=<x>&y c=1+1"}`; legacy != want {
+		t.Errorf("only the kernel should be unescaped, got %s", legacy)
+	}
+	raw, err := encodeResponse(response, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"kernel":"http://images/a%2Bb","initrd":null,"cmdline":"a=50%25 b=<x>&y c=1+1"}`; raw != want {
+		t.Errorf("raw response should be verbatim, got %s", raw)
+	}
+
+	s := &Spriteful{}
+	for _, server := range []*Server{{RawURLs: true}, {RawCmdline: true}} {
+		if !s.rawURLs(server) {
+			t.Errorf("raw-urls and its deprecated raw-cmdline name should both send verbatim urls")
+		}
+	}
+	if s.rawURLs(&Server{}) {
+		t.Errorf("urls should be unescaped by default")
+	}
+}
+
+func TestEncodeResponseCmdline(t *testing.T) {
+	cmdlines := []string{
+		`custom="a b c" quiet`,
+		`root=LABEL=root opts="x=1,y=2"`,
+		`url=http://host/path%20with%20spaces?a=1&b=2 plus=1+1`,
+		`msg="say "hi"" trailing\`,
+	}
+	for _, cmdline := range cmdlines {
+		value, err := encodeResponse(&PixieResponse{CommandLine: cmdline}, false)
+		if err != nil {
+			t.Errorf("%s should encode, but it didn't: %v", cmdline, err)
+			continue
+		}
+		var decoded PixieResponse
+		if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+			t.Errorf("%s should encode to valid json, got %s", cmdline, value)
+			continue
+		}
+		if decoded.CommandLine != cmdline {
+			t.Errorf("cmdline should be preserved, want %s got %s", cmdline, decoded.CommandLine)
+		}
+	}
+}
+
+func TestReadyRequest(t *testing.T) {
+	s := &Spriteful{}
+	if res := serve(s, "GET", "/healthz", nil); res.Code != http.StatusOK {
+		t.Errorf("healthz should be ok, status: %d", res.Code)
+	}
+	if res := serve(s, "GET", "/readyz", nil); res.Code != http.StatusServiceUnavailable {
+		t.Errorf("an empty config should be degraded, status: %d", res.Code)
+	}
+	s.allowEmpty = true
+	if res := serve(s, "GET", "/readyz", nil); res.Code != http.StatusOK {
+		t.Errorf("an allowed empty config should be ready, status: %d", res.Code)
+	}
+	s = &Spriteful{Servers: []Server{{MacAddress: validMac}}}
+	if res := serve(s, "GET", "/readyz", nil); res.Code != http.StatusOK {
+		t.Errorf("a config with servers should be ready, status: %d", res.Code)
+	}
+}
+
+func TestRootRequest(t *testing.T) {
+	s := &Spriteful{}
+	res := serve(s, "GET", "/", nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("root should be ok, status: %d", res.Code)
+	}
+	var info ServiceInfo
+	if err := json.Unmarshal(res.Body.Bytes(), &info); err != nil {
+		t.Fatalf("root should answer json, got %s", res.Body.String())
+	}
+	if info.Service != "spriteful" || info.Version != version {
+		t.Errorf("root should name the service and version, got %+v", info)
+	}
+	if res := serve(s, "GET", "/favicon.ico", nil); res.Code != http.StatusNoContent {
+		t.Errorf("favicon should be empty, status: %d", res.Code)
+	}
+}
+
+// hangupRecorder records a response, failing every write after the first n
+// bytes as if the client closed the connection.
+type hangupRecorder struct {
+	*httptest.ResponseRecorder
+	n int
+}
+
+func (r *hangupRecorder) Write(p []byte) (int, error) {
+	if left := r.n - r.Body.Len(); len(p) > left {
+		written, _ := r.ResponseRecorder.Write(p[:left])
+		return written, errors.New("connection reset by peer")
+	}
+	return r.ResponseRecorder.Write(p)
+}
+
+func (r *hangupRecorder) WriteString(str string) (int, error) {
+	return r.Write([]byte(str))
+}
+
+func TestBootResponseCutShort(t *testing.T) {
+	s := &Spriteful{
+		Servers: []Server{{MacAddress: validMac, Kernel: "http://images/vmlinuz", CommandLine: "quiet"}},
+		stats:   newStats(time.Now()),
+	}
+	// Without and with the request timeout, whose buffering must not hide
+	// the failed writes.
+	for _, timeout := range []time.Duration{0, time.Minute} {
+		s.requestTimeout = timeout
+		c := restful.NewContainer()
+		s.register(c)
+		for _, format := range []string{"json", "ipxe"} {
+			res := &hangupRecorder{ResponseRecorder: httptest.NewRecorder(), n: 10}
+			normalizePath(c).ServeHTTP(res, httptest.NewRequest("GET", "/api/v1/boot/"+validMac+"?format="+format, nil))
+			if res.Body.Len() != 10 {
+				t.Errorf("%s response should be written up to the hangup with a %s timeout, got %q", format, timeout, res.Body.String())
+			}
+		}
+	}
+	serve(s, "GET", "/api/v1/boot/"+validMac, nil)
+
+	counters := s.stats.snapshot(false, time.Now()).Macs[validMac]
+	if counters.CutShort != 4 || counters.Boots != 1 {
+		t.Errorf("cut short responses should be counted apart from boots, stats: %+v", counters)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// stats counts boot requests in memory. A nil stats records nothing.
+	stats struct {
+		mu       sync.Mutex
+		since    time.Time
+		inFlight int
+		macs     map[string]*MacStats
+	}
+
+	// MacStats are the boot counters of a single MAC.
+	MacStats struct {
+		Boots    int       `json:"boots"`
+		Misses   int       `json:"misses"`
+		CutShort int       `json:"cut-short"`
+		LastBoot time.Time `json:"last-boot,omitempty"`
+	}
+
+	// StatsSnapshot is a point in time copy of the stats.
+	StatsSnapshot struct {
+		Since    time.Time           `json:"since"`
+		InFlight int                 `json:"in-flight"`
+		Macs     map[string]MacStats `json:"macs"`
+	}
+)
+
+// Creates empty stats counting from now.
+func newStats(now time.Time) *stats {
+	return &stats{since: now.UTC(), macs: make(map[string]*MacStats)}
+}
+
+// Returns the counters of the MAC, creating them. Must be called with the
+// lock held.
+func (st *stats) mac(macAddress string) *MacStats {
+	key := macKey(macAddress)
+	counters, ok := st.macs[key]
+	if !ok {
+		counters = &MacStats{}
+		st.macs[key] = counters
+	}
+	return counters
+}
+
+// Records a boot response sent for the MAC.
+func (st *stats) boot(macAddress string, at time.Time) {
+	if st == nil || macAddress == "" {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	counters := st.mac(macAddress)
+	counters.Boots++
+	counters.LastBoot = at.UTC()
+}
+
+// Records a boot response for the MAC that failed to be written in full,
+// e.g. because the client hung up.
+func (st *stats) cutShort(macAddress string) {
+	if st == nil || macAddress == "" {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.mac(macAddress).CutShort++
+}
+
+// Records a boot request for a MAC without a configuration.
+func (st *stats) miss(macAddress string) {
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.mac(macAddress).Misses++
+}
+
+// Returns a copy of the stats, clearing them as of now if reset is set.
+// Requests in flight are still counted after a reset.
+func (st *stats) snapshot(reset bool, now time.Time) StatsSnapshot {
+	snapshot := StatsSnapshot{Macs: make(map[string]MacStats)}
+	if st == nil {
+		return snapshot
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	snapshot.Since = st.since
+	snapshot.InFlight = st.inFlight
+	for key, counters := range st.macs {
+		snapshot.Macs[key] = *counters
+	}
+	if reset {
+		st.since = now.UTC()
+		st.macs = make(map[string]*MacStats)
+	}
+	return snapshot
+}
+
+// Counts the boot requests in flight, also tracking them for the idle
+// timeout.
+func (s *Spriteful) statsFilter(req *restful.Request, res *restful.Response, chain *restful.FilterChain) {
+	s.idle.begin()
+	defer func() { s.idle.end(s.now()) }()
+	if s.stats == nil {
+		chain.ProcessFilter(req, res)
+		return
+	}
+	s.stats.mu.Lock()
+	s.stats.inFlight++
+	s.stats.mu.Unlock()
+	defer func() {
+		s.stats.mu.Lock()
+		s.stats.inFlight--
+		s.stats.mu.Unlock()
+	}()
+	chain.ProcessFilter(req, res)
+}
+
+// Handles the http request for the boot stats.
+func (s *Spriteful) handleStatsRequest(req *restful.Request, res *restful.Response) {
+	res.WriteAsJson(s.stats.snapshot(false, s.now()))
+}
+
+// Handles the http request clearing the boot stats, returning them as they
+// were before the reset.
+func (s *Spriteful) handleStatsResetRequest(req *restful.Request, res *restful.Response) {
+	snapshot := s.stats.snapshot(true, s.now())
+	logrus.Info("boot stats reset.")
+	res.WriteAsJson(snapshot)
+}
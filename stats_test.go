@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"encoding/json"
+	"net/http"
+)
+
+func TestStatsReset(t *testing.T) {
+	s := &Spriteful{
+		Servers:    []Server{{MacAddress: validMac, Kernel: "vmlinuz"}},
+		adminToken: "secret",
+		stats:      newStats(time.Now()),
+	}
+	serve(s, "GET", "/api/v1/boot/00-00-00-00-00-00", nil)
+	serve(s, "GET", "/api/v1/boot/00:00:00:00:00:00", nil)
+	serve(s, "GET", "/api/v1/boot/"+invalidMac, nil)
+
+	auth := http.Header{"Authorization": {"Bearer secret"}}
+	if res := sendJSON(s, "POST", "/api/v1/stats/reset", ""); res.Code != http.StatusUnauthorized {
+		t.Errorf("reset without a token should be unauthorized, status: %d", res.Code)
+	}
+	var before StatsSnapshot
+	res := serve(s, "POST", "/api/v1/stats/reset", auth)
+	json.Unmarshal(res.Body.Bytes(), &before)
+	if before.Macs[validMac].Boots != 2 || before.Macs[invalidMac].Misses != 1 {
+		t.Errorf("reset should return the stats before the reset, stats: %+v", before.Macs)
+	}
+
+	var after StatsSnapshot
+	res = serve(s, "GET", "/api/v1/stats", auth)
+	json.Unmarshal(res.Body.Bytes(), &after)
+	if len(after.Macs) != 0 || after.Since.Before(before.Since) {
+		t.Errorf("stats should be cleared after a reset, stats: %+v", after)
+	}
+}
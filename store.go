@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+type (
+	// ServerStore resolves server boot configurations.
+	ServerStore interface {
+		// Lookup returns the server config for the MAC address.
+		Lookup(macAddress string) (*Server, error)
+		// List returns every configured server.
+		List() []Server
+	}
+
+	// SerialStore is implemented by stores that can also resolve servers by
+	// system serial number.
+	SerialStore interface {
+		LookupSerial(serial string) (*Server, error)
+	}
+
+	// ContextStore is implemented by stores whose lookups are outbound calls,
+	// so they can be cut short by the request's deadline.
+	ContextStore interface {
+		LookupContext(ctx context.Context, macAddress string) (*Server, error)
+		LookupSerialContext(ctx context.Context, serial string) (*Server, error)
+	}
+
+	// StoreHealth is implemented by stores with a backend that can become
+	// unreachable.
+	StoreHealth interface {
+		Health() StoreStatus
+	}
+
+	// StoreStatus is the health of a store backend, reported on /healthz.
+	StoreStatus struct {
+		OK    bool      `json:"ok"`
+		Error string    `json:"error,omitempty"`
+		Since time.Time `json:"since"`
+	}
+
+	// fileStore serves the servers from the loaded config file.
+	fileStore struct {
+		sprite *Spriteful
+	}
+)
+
+// Returns the store server lookups go through, defaulting to the config file.
+func (s *Spriteful) serverStore() ServerStore {
+	if s.store != nil {
+		return s.store
+	}
+	return &fileStore{sprite: s}
+}
+
+// Looks the MAC address up in the store within the context's deadline when
+// the store supports it.
+func lookupContext(ctx context.Context, store ServerStore, macAddress string) (*Server, error) {
+	if contextStore, ok := store.(ContextStore); ok {
+		return contextStore.LookupContext(ctx, macAddress)
+	}
+	return store.Lookup(macAddress)
+}
+
+// Lookup returns the server config for the MAC address.
+func (f *fileStore) Lookup(macAddress string) (*Server, error) {
+	return f.sprite.findServerConfig(macAddress)
+}
+
+// LookupSerial returns the server config for the serial number.
+func (f *fileStore) LookupSerial(serial string) (*Server, error) {
+	return f.sprite.findServerBySerial(serial)
+}
+
+// List returns the servers in the config file.
+func (f *fileStore) List() []Server {
+	return f.sprite.config().Servers
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"database/sql"
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+)
+
+var (
+	// ErrStoreUnavailable is returned by stores whose backend can't be reached.
+	ErrStoreUnavailable = errors.New("server store is unavailable")
+
+	// errInvalidRow reports a table row that can't be read as a server.
+	errInvalidRow = errors.New("invalid row")
+)
+
+type (
+	// sqlStore reads server boot configs from a database table with the
+	// columns mac, kernel, initrd (a JSON array), cmdline, serial, hostname
+	// and disabled. Lookups are cached for ttl. Failed queries are retried
+	// with backoff, and while the database is unreachable cached results are
+	// served for up to maxStale past their ttl, after which they are pruned.
+	// Once a query found the database unreachable, stale results are served
+	// at once and refreshed in the background instead of waiting out the
+	// retries on every lookup.
+	sqlStore struct {
+		db          *sql.DB
+		table       string
+		placeholder func(int) string
+		ttl         time.Duration
+		timeout     time.Duration
+		retries     int
+		backoff     time.Duration
+		maxStale    time.Duration
+		now         func() time.Time
+		sleep       func(time.Duration)
+
+		mu         sync.Mutex
+		cache      map[string]sqlLookup
+		refreshing map[string]bool
+		pruned     time.Time
+		list       []Server
+		listed     time.Time
+		lastErr    error
+		changed    time.Time
+	}
+
+	// sqlLookup is a cached lookup result.
+	sqlLookup struct {
+		server  *Server
+		err     error
+		expires time.Time
+	}
+)
+
+// Opens the SQL store for the driver (postgres or mysql) and DSN.
+func newSQLStore(driver, dsn, table string, ttl time.Duration) (*sqlStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	store := &sqlStore{
+		db:         db,
+		table:      table,
+		ttl:        ttl,
+		timeout:    5 * time.Second,
+		now:        time.Now,
+		sleep:      time.Sleep,
+		cache:      make(map[string]sqlLookup),
+		refreshing: make(map[string]bool),
+		placeholder: func(int) string {
+			return "?"
+		},
+	}
+	if driver == "postgres" {
+		store.placeholder = func(i int) string {
+			return fmt.Sprintf("$%d", i)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), store.timeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		logrus.WithField(logrus.ErrorKey, err).Warn("sql store is unreachable, lookups will fail until it recovers.")
+	}
+	return store, nil
+}
+
+// Returns the select statement for the store table.
+func (q *sqlStore) query(where string) string {
+	query := "SELECT mac, kernel, initrd, cmdline, serial, hostname, disabled FROM " + q.table
+	if where != "" {
+		query += " WHERE " + where
+	}
+	return query
+}
+
+// Lookup returns the server config for the MAC address.
+func (q *sqlStore) Lookup(macAddress string) (*Server, error) {
+	return q.LookupContext(context.Background(), macAddress)
+}
+
+// LookupContext returns the server config for the MAC address, giving up
+// once the context is done.
+func (q *sqlStore) LookupContext(ctx context.Context, macAddress string) (*Server, error) {
+	return q.cached(ctx, "mac:"+macKey(macAddress), "mac = "+q.placeholder(1), macKey(macAddress))
+}
+
+// LookupSerial returns the server config for the serial number.
+func (q *sqlStore) LookupSerial(serial string) (*Server, error) {
+	return q.LookupSerialContext(context.Background(), serial)
+}
+
+// LookupSerialContext returns the server config for the serial number,
+// giving up once the context is done.
+func (q *sqlStore) LookupSerialContext(ctx context.Context, serial string) (*Server, error) {
+	return q.cached(ctx, "serial:"+serialKey(serial), "LOWER(serial) = "+q.placeholder(1), serialKey(serial))
+}
+
+// Resolves a single server, serving recent results from the cache and
+// stale ones while the database is unreachable. A lookup cut short by the
+// context returns its error and leaves the cache as is.
+func (q *sqlStore) cached(ctx context.Context, key, where string, arg interface{}) (*Server, error) {
+	q.mu.Lock()
+	lookup, ok := q.cache[key]
+	down := q.lastErr != nil
+	q.mu.Unlock()
+	if ok && q.now().Before(lookup.expires) {
+		return lookup.server, lookup.err
+	}
+	if ok && down && q.now().Before(lookup.expires.Add(q.maxStale)) {
+		q.refresh(key, where, arg, lookup)
+		return lookup.server, lookup.err
+	}
+	return q.fetch(ctx, key, where, arg, lookup, ok)
+}
+
+// Queries a single server and caches the result, falling back to the
+// cached lookup, if any, while the database is unreachable or when the
+// context runs out first.
+func (q *sqlStore) fetch(ctx context.Context, key, where string, arg interface{}, lookup sqlLookup, ok bool) (*Server, error) {
+	var server *Server
+	err := q.retry(ctx, func(ctx context.Context) error {
+		var err error
+		server, err = scanServer(q.db.QueryRowContext(ctx, q.query(where), arg))
+		return err
+	})
+	switch {
+	case err == sql.ErrNoRows:
+		err = fmt.Errorf("no configuration defined for %v.", arg)
+	case err != nil && ok && q.now().Before(lookup.expires.Add(q.maxStale)):
+		logrus.WithField(logrus.ErrorKey, err).Warnf("sql store lookup failed, serving cached %v.", arg)
+		return lookup.server, lookup.err
+	case err != nil && ctx.Err() != nil:
+		return nil, ctx.Err()
+	case err != nil:
+		logrus.WithField(logrus.ErrorKey, err).Warn("sql store lookup failed.")
+		return nil, fmt.Errorf("%w: %v", ErrStoreUnavailable, err)
+	case server.Disabled:
+		server, err = nil, fmt.Errorf("no configuration defined for %v.", arg)
+	}
+
+	q.mu.Lock()
+	q.prune()
+	q.cache[key] = sqlLookup{server: server, err: err, expires: q.now().Add(q.ttl)}
+	q.mu.Unlock()
+	return server, err
+}
+
+// Fetches the lookup in the background, with retries, unless a refresh of
+// it is already running.
+func (q *sqlStore) refresh(key, where string, arg interface{}, lookup sqlLookup) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.refreshing[key] {
+		return
+	}
+	q.refreshing[key] = true
+	go func() {
+		q.fetch(context.Background(), key, where, arg, lookup, true)
+		q.mu.Lock()
+		delete(q.refreshing, key)
+		q.mu.Unlock()
+	}()
+}
+
+// Drops the cached lookups too old to be served even while the database is
+// unreachable, at most once per ttl so lookups don't each scan the cache.
+// The caller holds mu.
+func (q *sqlStore) prune() {
+	now := q.now()
+	if now.Before(q.pruned.Add(q.ttl)) {
+		return
+	}
+	q.pruned = now
+	for key, lookup := range q.cache {
+		if now.After(lookup.expires.Add(q.maxStale)) {
+			delete(q.cache, key)
+		}
+	}
+}
+
+// List returns every server in the table, or the last list while the
+// database is unreachable and the list isn't too stale.
+func (q *sqlStore) List() []Server {
+	var servers []Server
+	err := q.retry(context.Background(), func(ctx context.Context) error {
+		rows, err := q.db.QueryContext(ctx, q.query(""))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		servers = nil
+		for rows.Next() {
+			server, err := scanServer(rows)
+			if err != nil {
+				logrus.WithField(logrus.ErrorKey, err).Warn("unable to read sql store row.")
+				continue
+			}
+			servers = append(servers, *server)
+		}
+		return rows.Err()
+	})
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err != nil {
+		if q.list != nil && q.now().Before(q.listed.Add(q.ttl+q.maxStale)) {
+			logrus.WithField(logrus.ErrorKey, err).Warn("sql store list failed, serving the cached list.")
+			return q.list
+		}
+		logrus.WithField(logrus.ErrorKey, err).Warn("sql store list failed.")
+		return nil
+	}
+	q.list, q.listed = servers, q.now()
+	return servers
+}
+
+// Runs the query, retrying it with doubling backoff while it fails with a
+// transient error. Each attempt gets the store timeout within the context's
+// deadline, and no retry starts that the deadline would cut short. The
+// outcome is recorded as the store's health, unless the context ran out.
+func (q *sqlStore) retry(ctx context.Context, query func(ctx context.Context) error) error {
+	run := func() error {
+		ctx, cancel := context.WithTimeout(ctx, q.timeout)
+		defer cancel()
+		return query(ctx)
+	}
+	err := run()
+	backoff := q.backoff
+	for i := 0; i < q.retries && transient(err) && q.leaves(ctx, backoff); i++ {
+		q.sleep(backoff)
+		backoff *= 2
+		err = run()
+	}
+	if ctx.Err() != nil {
+		return err
+	}
+	if transient(err) {
+		q.recordHealth(err)
+	} else {
+		q.recordHealth(nil)
+	}
+	return err
+}
+
+// Reports whether the context is still live after waiting for backoff. The
+// deadline is wall-clock time, so it's compared against the real clock
+// rather than the store's.
+func (q *sqlStore) leaves(ctx context.Context, backoff time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) > backoff
+}
+
+// Reports whether the query error may go away when retried, as opposed to
+// no error, a missing row or an unreadable one.
+func transient(err error) bool {
+	return err != nil && err != sql.ErrNoRows && !errors.Is(err, errInvalidRow)
+}
+
+// Records whether the database was reachable.
+func (q *sqlStore) recordHealth(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if (err == nil) != (q.lastErr == nil) || q.changed.IsZero() {
+		q.changed = q.now()
+	}
+	q.lastErr = err
+}
+
+// Health returns whether the last query reached the database.
+func (q *sqlStore) Health() StoreStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	status := StoreStatus{OK: q.lastErr == nil, Since: q.changed}
+	if q.lastErr != nil {
+		status.Error = q.lastErr.Error()
+	}
+	return status
+}
+
+// Scans a table row into a server.
+func scanServer(row interface{ Scan(...interface{}) error }) (*Server, error) {
+	var (
+		server                        Server
+		initrd, cmdline, serial, host sql.NullString
+		disabled                      sql.NullBool
+	)
+	if err := row.Scan(&server.MacAddress, &server.Kernel, &initrd, &cmdline, &serial, &host, &disabled); err != nil {
+		return nil, err
+	}
+	if text := strings.TrimSpace(initrd.String); text != "" {
+		if err := json.Unmarshal([]byte(text), &server.Initrd); err != nil {
+			return nil, fmt.Errorf("%w: invalid initrd for %s: %v", errInvalidRow, server.MacAddress, err)
+		}
+	}
+	server.CommandLine = Cmdline(cmdline.String)
+	server.Serial = serial.String
+	server.Hostname = host.String
+	server.Disabled = disabled.Bool
+	return &server, nil
+}
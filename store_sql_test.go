@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+)
+
+// fakeDB is the table served by the fake sql driver.
+type fakeDB struct {
+	rows    [][]driver.Value
+	down    bool
+	queries int
+}
+
+var fakeDBs = map[string]*fakeDB{}
+
+func init() {
+	sql.Register("fakesql", fakeDriver{})
+}
+
+type (
+	fakeDriver struct{}
+	fakeConn   struct{ db *fakeDB }
+	fakeStmt   struct {
+		db    *fakeDB
+		query string
+	}
+	fakeRows struct {
+		rows [][]driver.Value
+	}
+)
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return &fakeConn{db: fakeDBs[name]}, nil }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.queries++
+	if s.db.down {
+		return nil, errors.New("connection refused")
+	}
+	var rows [][]driver.Value
+	for _, row := range s.db.rows {
+		switch {
+		case len(args) == 0,
+			strings.Contains(s.query, "mac =") && row[0] == args[0],
+			strings.Contains(s.query, "serial) =") && strings.ToLower(row[4].(string)) == args[0]:
+			rows = append(rows, row)
+		}
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"mac", "kernel", "initrd", "cmdline", "serial", "hostname", "disabled"}
+}
+func (r *fakeRows) Close() error { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestSQLStore(t *testing.T) {
+	db := &fakeDB{rows: [][]driver.Value{
+		{validMac, "vmlinuz", `["initrd"]`, "quiet", validSerial, "node1", false},
+		{invalidMac, "vmlinuz", nil, nil, "", "", true},
+	}}
+	fakeDBs["test"] = db
+	store, err := newSQLStore("fakesql", "test", "servers", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := store.Lookup(strings.ToUpper(validMac))
+	if err != nil || server.Kernel != "vmlinuz" || server.Initrd[0].URL != "initrd" || server.CommandLine != "quiet" {
+		t.Fatalf("%s config should be found, got %+v (%v)", validMac, server, err)
+	}
+	if _, err := store.Lookup(validMac); err != nil || db.queries != 1 {
+		t.Errorf("repeated lookups should be cached, queries: %d", db.queries)
+	}
+	if _, err := store.LookupSerial("sn-0001"); err != nil {
+		t.Errorf("%s config should be found by serial: %v", validSerial, err)
+	}
+	if _, err := store.Lookup(invalidMac); err == nil || errors.Is(err, ErrStoreUnavailable) {
+		t.Errorf("disabled %s config should not be found, got %v", invalidMac, err)
+	}
+	if servers := store.List(); len(servers) != 2 {
+		t.Errorf("two servers are expected, servers: %d", len(servers))
+	}
+
+	db.down = true
+	s := &Spriteful{store: store}
+	if res := serve(s, "GET", "/api/v1/boot/00:00:00:00:00:02", nil); res.Code != http.StatusServiceUnavailable {
+		t.Errorf("lookups against an unreachable store should 503, status: %d", res.Code)
+	}
+}
+
+func TestSQLStoreOutage(t *testing.T) {
+	db := &fakeDB{rows: [][]driver.Value{{validMac, "vmlinuz", nil, nil, "", "", false}}}
+	fakeDBs["outage"] = db
+	store, err := newSQLStore("fakesql", "outage", "servers", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var slept []time.Duration
+	store.now = func() time.Time { return now }
+	store.sleep = func(d time.Duration) { slept = append(slept, d) }
+	store.retries, store.backoff, store.maxStale = 2, 100*time.Millisecond, 5*time.Minute
+
+	if _, err := store.Lookup(validMac); err != nil {
+		t.Fatal(err)
+	}
+	if servers := store.List(); len(servers) != 1 {
+		t.Fatalf("one server is expected, servers: %d", len(servers))
+	}
+
+	db.down = true
+	db.queries = 0
+	now = now.Add(2 * time.Minute)
+	if server, err := store.Lookup(validMac); err != nil || server.Kernel != "vmlinuz" {
+		t.Errorf("stale lookups should be served while the store is down, got %+v (%v)", server, err)
+	}
+	if db.queries != 3 || len(slept) != 2 || slept[0] != 100*time.Millisecond || slept[1] != 200*time.Millisecond {
+		t.Errorf("failed queries should be retried with doubling backoff, queries: %d, slept: %v", db.queries, slept)
+	}
+	if servers := store.List(); len(servers) != 1 {
+		t.Errorf("the stale list should be served while the store is down, servers: %d", len(servers))
+	}
+	if health := store.Health(); health.OK || health.Error == "" || !health.Since.Equal(now) {
+		t.Errorf("the store should report it is down, got %+v", health)
+	}
+
+	s := &Spriteful{store: store}
+	res := serve(s, "GET", "/healthz", nil)
+	var status HealthStatus
+	json.Unmarshal(res.Body.Bytes(), &status)
+	if res.Code != http.StatusOK || status.Store == nil || status.Store.OK {
+		t.Errorf("healthz should report the store is down, status: %d, body: %s", res.Code, res.Body.String())
+	}
+	db.queries = 0
+	if res := serve(s, "GET", "/readyz", nil); res.Code != http.StatusServiceUnavailable || db.queries != 0 {
+		t.Errorf("readyz should report the store is down without querying it, status: %d, queries: %d", res.Code, db.queries)
+	}
+
+	release := make(chan struct{})
+	store.sleep = func(time.Duration) { <-release }
+	served := make(chan error, 1)
+	go func() {
+		_, err := store.Lookup(validMac)
+		served <- err
+	}()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("stale lookups should be served while the store is down, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("stale lookups should not wait out the retries once the store is known down")
+	}
+	close(release)
+	waitRefreshes(t, store)
+	store.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	now = now.Add(5 * time.Minute)
+	if _, err := store.Lookup(validMac); !errors.Is(err, ErrStoreUnavailable) {
+		t.Errorf("lookups past the staleness bound should fail, got %v", err)
+	}
+	if servers := store.List(); servers != nil {
+		t.Errorf("lists past the staleness bound should be empty, servers: %d", len(servers))
+	}
+
+	db.down = false
+	if _, err := store.Lookup(validMac); err != nil || !store.Health().OK {
+		t.Errorf("the store should recover, got %v", err)
+	}
+	if res := serve(s, "GET", "/readyz", nil); res.Code != http.StatusOK {
+		t.Errorf("readyz should be ready once the store recovers, status: %d", res.Code)
+	}
+}
+
+// Waits for the background refreshes of the store to finish.
+func waitRefreshes(t *testing.T, store *sqlStore) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		store.mu.Lock()
+		refreshing := len(store.refreshing)
+		store.mu.Unlock()
+		if refreshing == 0 {
+			return
+		}
+	}
+	t.Fatal("background refreshes should finish")
+}
+
+func TestSQLStorePrune(t *testing.T) {
+	db := &fakeDB{rows: [][]driver.Value{{validMac, "vmlinuz", nil, nil, "", "", false}}}
+	fakeDBs["prune"] = db
+	store, err := newSQLStore("fakesql", "prune", "servers", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	store.maxStale = 5 * time.Minute
+
+	store.Lookup(validMac)
+	store.Lookup("00:00:00:00:00:0a")
+	store.Lookup("00:00:00:00:00:0b")
+	now = now.Add(2 * time.Minute)
+	store.Lookup("00:00:00:00:00:0c")
+	if len(store.cache) != 4 {
+		t.Errorf("lookups within the staleness bound should be kept, cached: %d", len(store.cache))
+	}
+	now = now.Add(5 * time.Minute)
+	store.Lookup(validMac)
+	if len(store.cache) != 2 {
+		t.Errorf("lookups past the staleness bound should be pruned, cached: %d", len(store.cache))
+	}
+}
+
+func TestSQLStoreDeadline(t *testing.T) {
+	db := &fakeDB{down: true}
+	fakeDBs["deadline"] = db
+	store, err := newSQLStore("fakesql", "deadline", "servers", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The store's clock lags the deadline, which must still bound the retries.
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	var slept []time.Duration
+	store.sleep = func(d time.Duration) { slept = append(slept, d) }
+	store.retries, store.backoff, store.maxStale = 3, time.Hour, 5*time.Minute
+	db.queries = 0
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := store.LookupContext(ctx, validMac); !errors.Is(err, ErrStoreUnavailable) {
+		t.Errorf("failed lookups should still report the store unavailable, got %v", err)
+	}
+	if db.queries != 1 || len(slept) != 0 {
+		t.Errorf("retries past the deadline should not be attempted, queries: %d, slept: %v", db.queries, slept)
+	}
+
+	cancel()
+	if _, err := store.LookupContext(ctx, validMac); err != context.Canceled {
+		t.Errorf("lookups with a done context should return its error, got %v", err)
+	}
+
+	db.down = false
+	db.rows = [][]driver.Value{{validMac, "vmlinuz", nil, nil, "", "", false}}
+	if _, err := store.Lookup(validMac); err != nil {
+		t.Fatal(err)
+	}
+	db.down = true
+	now = now.Add(2 * time.Minute)
+	if server, err := store.LookupContext(ctx, validMac); err != nil || server == nil || server.Kernel != "vmlinuz" {
+		t.Errorf("lookups with a done context should serve a stale lookup, got %+v (%v)", server, err)
+	}
+}
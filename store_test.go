@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"encoding/json"
+	"net/http"
+)
+
+// fakeStore is a ServerStore backed by a map.
+type fakeStore map[string]Server
+
+func (f fakeStore) Lookup(macAddress string) (*Server, error) {
+	if server, ok := f[macAddress]; ok {
+		return &server, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (f fakeStore) List() []Server {
+	var servers []Server
+	for _, server := range f {
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+func TestBootUsesStore(t *testing.T) {
+	s := &Spriteful{
+		Servers: []Server{{MacAddress: validMac, Kernel: "file"}},
+		store:   fakeStore{validMac: {MacAddress: validMac, Kernel: "store"}},
+	}
+	res := serve(s, "GET", "/api/v1/boot/"+validMac, nil)
+	var response PixieResponse
+	json.Unmarshal(res.Body.Bytes(), &response)
+	if response.Kernel != "store" {
+		t.Errorf("boot should resolve through the store, kernel: %s", response.Kernel)
+	}
+	if res := serve(s, "GET", "/api/v1/boot/serial/"+validSerial, nil); res.Code != http.StatusNotFound {
+		t.Errorf("serial lookups should 404 when the store doesn't support them, status: %d", res.Code)
+	}
+
+	s.store = nil
+	if _, ok := s.serverStore().(*fileStore); !ok {
+		t.Errorf("the file store should be the default")
+	}
+}
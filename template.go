@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TemplateContext is the data kernel, initrd and cmdline templates are
+// rendered with.
+type TemplateContext struct {
+	MAC      string
+	Arch     string
+	ClientIP string
+	Hostname string
+	Serial   string
+	IP       string
+	Group    string
+	Meta     map[string]string
+}
+
+// templateFuncs are the functions available to templates, besides the
+// text/template built-ins such as index, printf and eq. None of them touch
+// the file system, environment or network.
+var templateFuncs = template.FuncMap{
+	// upper and lower change the case of a string.
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	// trim removes leading and trailing white space.
+	"trim": strings.TrimSpace,
+	// replace OLD NEW S replaces every OLD in S with NEW.
+	"replace": func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	// default DEFAULT S returns S, or DEFAULT when S is empty.
+	"default": func(def, s string) string {
+		if s == "" {
+			return def
+		}
+		return s
+	},
+	// mac FORMAT MAC renders the MAC in a -mac-format (colon, dash, cisco, bare).
+	"mac": func(format, mac string) string { return formatMAC(macKey(mac), format) },
+	// eui64 PREFIX MAC derives the SLAAC address of the MAC in an IPv6 /64.
+	"eui64": eui64,
+}
+
+// Returns the modified EUI-64 address of the MAC in the IPv6 prefix, which
+// must be at most 64 bits long.
+func eui64(prefix, mac string) (string, error) {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil || network.IP.To4() != nil {
+		return "", fmt.Errorf("%q isn't an ipv6 prefix", prefix)
+	}
+	if ones, _ := network.Mask.Size(); ones > 64 {
+		return "", fmt.Errorf("prefix %q is longer than 64 bits", prefix)
+	}
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return "", fmt.Errorf("%q isn't a 48-bit mac address", mac)
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, network.IP[:8])
+	ip[8], ip[9], ip[10] = hw[0]^0x02, hw[1], hw[2]
+	ip[11], ip[12] = 0xff, 0xfe
+	ip[13], ip[14], ip[15] = hw[3], hw[4], hw[5]
+	return ip.String(), nil
+}
+
+// Returns the template context for booting the server.
+func templateContext(server *Server, arch, clientIP string) *TemplateContext {
+	return &TemplateContext{
+		MAC:      macKey(server.MacAddress),
+		Arch:     arch,
+		ClientIP: clientIP,
+		Hostname: server.Hostname,
+		Serial:   server.Serial,
+		IP:       server.IP,
+		Group:    server.Group,
+		Meta:     server.Meta,
+	}
+}
+
+// Reports whether the value is a template. Templates depend on the request,
+// so they are left out of asset cache warming, verification and deep checks.
+func isTemplate(value string) bool {
+	return strings.Contains(value, "{{")
+}
+
+// Renders the value as a template with the context. Values without "{{"
+// are returned as is, and values that fail to render are returned as is
+// with a warning.
+func renderTemplate(value string, ctx *TemplateContext) string {
+	if !isTemplate(value) {
+		return value
+	}
+	tmpl, err := template.New("").Funcs(templateFuncs).Option("missingkey=zero").Parse(value)
+	if err == nil {
+		var rendered strings.Builder
+		if err = tmpl.Execute(&rendered, ctx); err == nil {
+			return rendered.String()
+		}
+	}
+	logrus.WithField(logrus.ErrorKey, err).Warnf(`unable to render template "%s" for "%s", sending it as is.`, value, ctx.MAC)
+	return value
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"encoding/json"
+	"net/http"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	ctx := &TemplateContext{MAC: "aa:bb:cc:dd:ee:ff", Arch: "arm64", Hostname: "node1", Meta: map[string]string{"rack": "r1"}}
+	tests := map[string]string{
+		"console=ttyS0":                         "console=ttyS0",
+		"hostname={{ .Hostname | upper }}":      "hostname=NODE1",
+		"id={{ mac \"bare\" .MAC }}":            "id=aabbccddeeff",
+		"id={{ .MAC | replace \":\" \"-\" }}":   "id=aa-bb-cc-dd-ee-ff",
+		"arch={{ .Arch | default \"amd64\" }}":  "arch=arm64",
+		"ip={{ .ClientIP | default \"dhcp\" }}": "ip=dhcp",
+		"rack={{ index .Meta \"rack\" }}":       "rack=r1",
+		"owner={{ .Meta.owner }}":               "owner=",
+		"ip6={{ eui64 \"fd00:1::/64\" .MAC }}":  "ip6=fd00:1::a8bb:ccff:fedd:eeff",
+		"broken={{ .Hostname":                   "broken={{ .Hostname",
+		"bad={{ eui64 \"10.0.0.0/8\" .MAC }}":   "bad={{ eui64 \"10.0.0.0/8\" .MAC }}",
+	}
+	for value, want := range tests {
+		if got := renderTemplate(value, ctx); got != want {
+			t.Errorf("%s should render %q, got %q", value, want, got)
+		}
+	}
+}
+
+func TestTemplateBoot(t *testing.T) {
+	s := &Spriteful{Servers: []Server{{
+		MacAddress:  validMac,
+		Hostname:    "node1",
+		Kernel:      "http://images/{{ .Arch | default \"amd64\" }}/vmlinuz",
+		Initrd:      []Initrd{{URL: "http://images/{{ .Hostname }}.img"}},
+		CommandLine: "hostname={{ .Hostname }}",
+	}}}
+	var response PixieResponse
+	json.Unmarshal(serve(s, "GET", "/api/v1/boot/"+validMac+"?arch=arm64", nil).Body.Bytes(), &response)
+	if response.Kernel != "http://images/arm64/vmlinuz" || response.Initrd[0] != "http://images/node1.img" || response.CommandLine != "hostname=node1" {
+		t.Errorf("kernel, initrd and cmdline templates should be rendered, got %+v", response)
+	}
+	if urls := remoteAssets(s.Servers); len(urls) != 0 {
+		t.Errorf("templated assets can't be cached ahead of a request, got %v", urls)
+	}
+	if res := serve(s, "GET", "/api/v1/boot/"+validMac, nil); res.Code != http.StatusOK {
+		t.Errorf("templated servers should boot, status: %d", res.Code)
+	}
+}
+
+func TestTemplateCmdlineMerge(t *testing.T) {
+	merged := Cmdline("console=tty0 hostname=x").merge(`hostname={{ .Hostname | default "node" }} quiet`)
+	if merged != `console=tty0 hostname={{ .Hostname | default "node" }} quiet` {
+		t.Errorf("template actions should merge as a single token, got %s", merged)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+// errBudgetExceeded is the boot error when the request timeout runs out
+// during an outbound call.
+var errBudgetExceeded = errors.New("request timed out waiting on an outbound call.")
+
+// Reports whether err comes from a call cut short by the request timeout,
+// which is the budget shared by every outbound call of the request.
+func budgetExceeded(ctx context.Context, err error) bool {
+	return err != nil && ctx.Err() == context.DeadlineExceeded
+}
+
+// Routes that stream their response and are exempt from the request timeout.
+var streamingPrefixes = []string{
+	"/api/v1/static/",
+	"/api/v1/cache/",
+}
+
+// timeoutWriter buffers a response until the handler finishes so that it can
+// be discarded when the request times out. Once switched to pass-through,
+// the response goes straight to out instead: it can no longer be replaced by
+// the 504, but it is streamed and write errors reach the handler.
+type timeoutWriter struct {
+	mu          sync.Mutex
+	out         http.ResponseWriter
+	header      http.Header
+	body        bytes.Buffer
+	status      int
+	timedOut    bool
+	passThrough bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.passThrough {
+		return w.out.Header()
+	}
+	return w.header
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.passThrough {
+		return w.out.Write(data)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return
+	}
+	if w.passThrough {
+		w.out.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Switches the writer to pass-through, moving the headers and anything
+// buffered so far to out. Reports false when the request already timed out.
+func (w *timeoutWriter) startPassThrough() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return false
+	}
+	if w.passThrough {
+		return true
+	}
+	w.passThrough = true
+	for key, values := range w.header {
+		w.out.Header()[key] = values
+	}
+	if w.status != 0 {
+		w.out.WriteHeader(w.status)
+	}
+	if w.body.Len() > 0 {
+		w.out.Write(w.body.Bytes())
+		w.body.Reset()
+	}
+	return true
+}
+
+// Takes the response out of the request timeout's buffering from here on,
+// for handlers that stream their response or need to see write errors. The
+// timeout keeps cancelling the request context, but can no longer answer
+// with a 504 once the response is passed through. A sampled request's
+// capture is looked through to reach the timeout's writer.
+func passThrough(res *restful.Response) {
+	w := res.ResponseWriter
+	if capture, ok := w.(*captureWriter); ok {
+		w = capture.ResponseWriter
+	}
+	if tw, ok := w.(*timeoutWriter); ok {
+		tw.startPassThrough()
+	}
+}
+
+// Cancels the request context after the configured timeout and answers with
+// 504 if the handler hasn't responded by then.
+func (s *Spriteful) timeoutFilter(req *restful.Request, res *restful.Response, chain *restful.FilterChain) {
+	for _, prefix := range streamingPrefixes {
+		if strings.HasPrefix(req.Request.URL.Path, prefix) {
+			chain.ProcessFilter(req, res)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(req.Request.Context(), s.requestTimeout)
+	defer cancel()
+	req.Request = req.Request.WithContext(ctx)
+
+	out := res.ResponseWriter
+	tw := &timeoutWriter{out: out, header: make(http.Header)}
+	res.ResponseWriter = tw
+	done := make(chan struct{})
+	panics := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panics <- p
+			}
+		}()
+		chain.ProcessFilter(req, res)
+		close(done)
+	}()
+
+	select {
+	case p := <-panics:
+		panic(p)
+	case <-done:
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		if tw.passThrough {
+			return
+		}
+		for key, values := range tw.header {
+			out.Header()[key] = values
+		}
+		if tw.status == 0 {
+			tw.status = http.StatusOK
+		}
+		out.WriteHeader(tw.status)
+		if written, err := out.Write(tw.body.Bytes()); err != nil {
+			logrus.WithField(logrus.ErrorKey, err).Warnf(`response for "%s" was cut short after %d of %d bytes.`, req.Request.URL.Path, written, tw.body.Len())
+		}
+	case <-ctx.Done():
+		tw.mu.Lock()
+		if tw.passThrough {
+			tw.mu.Unlock()
+			select {
+			case p := <-panics:
+				panic(p)
+			case <-done:
+			}
+			return
+		}
+		defer tw.mu.Unlock()
+		tw.timedOut = true
+		logrus.Warnf(`request for "%s" timed out after %s.`, req.Request.URL.Path, s.requestTimeout)
+		out.WriteHeader(http.StatusGatewayTimeout)
+		out.Write([]byte("request timed out."))
+	}
+}
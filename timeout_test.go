@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/emicklei/go-restful"
+)
+
+func TestTimeoutFilter(t *testing.T) {
+	s := &Spriteful{requestTimeout: 50 * time.Millisecond}
+	c := restful.NewContainer()
+	c.Filter(s.timeoutFilter)
+	ws := &restful.WebService{}
+	ws.Path("/api/v1")
+	ws.Route(ws.GET("slow").To(func(req *restful.Request, res *restful.Response) {
+		select {
+		case <-req.Request.Context().Done():
+		case <-time.After(time.Second):
+		}
+		res.Write([]byte("late"))
+	}))
+	ws.Route(ws.GET("fast").To(func(req *restful.Request, res *restful.Response) {
+		res.WriteHeader(http.StatusAccepted)
+		res.Write([]byte("quick"))
+	}))
+	streamed := httptest.NewRecorder()
+	ws.Route(ws.GET("stream").To(func(req *restful.Request, res *restful.Response) {
+		res.WriteHeader(http.StatusCreated)
+		res.Write([]byte("head "))
+		passThrough(res)
+		if streamed.Body.String() != "head " {
+			t.Errorf("passing through should write what was buffered, got %q", streamed.Body.String())
+		}
+		<-req.Request.Context().Done()
+		res.Write([]byte("tail"))
+	}))
+	c.Add(ws)
+
+	c.ServeHTTP(streamed, httptest.NewRequest("GET", "/api/v1/stream", nil))
+	if streamed.Code != http.StatusCreated || streamed.Body.String() != "head tail" {
+		t.Errorf("passed through responses should not be replaced on timeout, status: %d body: %q", streamed.Code, streamed.Body.String())
+	}
+
+	res := httptest.NewRecorder()
+	c.ServeHTTP(res, httptest.NewRequest("GET", "/api/v1/slow", nil))
+	if res.Code != http.StatusGatewayTimeout {
+		t.Errorf("slow request should time out with 504, status: %d", res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	c.ServeHTTP(res, httptest.NewRequest("GET", "/api/v1/fast", nil))
+	if res.Code != http.StatusAccepted || res.Body.String() != "quick" {
+		t.Errorf("fast request should pass through, status: %d body: %q", res.Code, res.Body.String())
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+)
+
+// Returns the TLS config serving the certificate and key, requiring and
+// verifying client certificates signed by the CA bundle at clientCAFile when
+// set. Returns nil without a certificate, the API then serves plain HTTP.
+func loadTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		if clientCAFile != "" {
+			return nil, errors.New("-tls-client-ca needs -tls-cert and -tls-key")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if clientCAFile == "" {
+		return config, nil
+	}
+	pem, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf(`no certificate found in client ca "%s"`, clientCAFile)
+	}
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
+
+// Reports whether the connection's verified client certificate names one of
+// the clients by its common name or a DNS name, always true when there are
+// none.
+func clientCertAllows(clients []string, state *tls.ConnectionState) bool {
+	if len(clients) == 0 {
+		return true
+	}
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return false
+	}
+	cert := state.VerifiedChains[0][0]
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	for _, client := range clients {
+		for _, name := range names {
+			if name != "" && strings.EqualFold(name, client) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+
+	"github.com/emicklei/go-restful"
+	"github.com/engineerang/spriteful/bootpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// testCert is a certificate and key issued for the TLS tests.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// Issues a certificate for the common name, signed by parent or self-signed
+// as a CA when parent is nil.
+func issueCert(t *testing.T, commonName string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// Writes the certificate and its key as PEM files in dir, returning their
+// paths.
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func TestClientCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spriteful")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := issueCert(t, "provisioning ca", nil)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := issueCert(t, "spriteful", ca).write(t, dir, "server")
+	client := issueCert(t, "node1", ca)
+
+	if _, err := loadTLSConfig("", "", caFile); err == nil {
+		t.Error("a client ca without a server certificate should fail")
+	}
+	if _, err := loadTLSConfig(certFile, keyFile, keyFile); err == nil {
+		t.Error("a client ca without certificates should fail")
+	}
+	config, err := loadTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Spriteful{Servers: []Server{{MacAddress: validMac, Kernel: "vmlinuz"}}}
+	c := restful.NewContainer()
+	s.register(c)
+	server := httptest.NewUnstartedServer(normalizePath(c))
+	server.TLS = config
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(certs ...tls.Certificate) (*http.Response, error) {
+		transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}
+		defer transport.CloseIdleConnections()
+		return (&http.Client{Transport: transport}).Get(server.URL + "/api/v1/boot/" + validMac)
+	}
+	if _, err := get(); err == nil {
+		t.Error("clients without a certificate should be rejected by the handshake")
+	}
+	withCert := tls.Certificate{Certificate: [][]byte{client.der}, PrivateKey: client.key}
+	if res, err := get(withCert); err != nil || res.StatusCode != http.StatusOK {
+		t.Errorf("clients with a provisioning certificate should boot, got %v", err)
+	}
+
+	s.ACL = &ACL{BootClients: []string{"node2"}}
+	if res, err := get(withCert); err != nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("certificates missing from boot-clients should be forbidden, got %v", err)
+	}
+	s.ACL.BootClients = []string{"NODE1"}
+	if res, err := get(withCert); err != nil || res.StatusCode != http.StatusOK {
+		t.Errorf("certificates listed in boot-clients should boot, got %v", err)
+	}
+}
+
+func TestGRPCClientCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spriteful")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := issueCert(t, "provisioning ca", nil)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := issueCert(t, "spriteful", ca).write(t, dir, "server")
+	client := issueCert(t, "node1", ca)
+	config, err := loadTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Spriteful{Servers: []Server{{MacAddress: validMac, Kernel: "vmlinuz"}}, tlsConfig: config}
+	s.live = &liveConfig{}
+	s.live.value.Store(s)
+	server, address, err := s.startGRPC("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	creds := credentials.NewTLS(&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{{Certificate: [][]byte{client.der}, PrivateKey: client.key}}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, address.String(), grpc.WithTransportCredentials(creds), grpc.WithBlock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	boot := func() error {
+		_, err := bootpb.NewBootClient(conn).Boot(ctx, &bootpb.BootRequest{Mac: validMac})
+		return err
+	}
+	watch := func() error {
+		stream, err := bootpb.NewBootClient(conn).WatchConfig(ctx, &bootpb.WatchConfigRequest{})
+		if err != nil {
+			return err
+		}
+		_, err = stream.Recv()
+		return err
+	}
+
+	s.ACL = &ACL{BootClients: []string{"node2"}, AdminClients: []string{"ops"}}
+	if err := boot(); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("certificates missing from boot-clients should be denied boots, got %v", err)
+	}
+	if err := watch(); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("certificates missing from admin-clients should be denied watches, got %v", err)
+	}
+	s.ACL = &ACL{BootClients: []string{"NODE1"}, AdminClients: []string{"node1"}}
+	if err := boot(); err != nil {
+		t.Errorf("certificates listed in boot-clients should boot, got %v", err)
+	}
+	if err := watch(); err != nil {
+		t.Errorf("certificates listed in admin-clients should watch, got %v", err)
+	}
+}
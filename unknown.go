@@ -0,0 +1,208 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxUnknownMACs bounds each set of unknown MACs, new MACs past it are
+	// dropped.
+	maxUnknownMACs = 10000
+
+	// maxMalformedLength truncates malformed MACs before they are recorded.
+	maxMalformedLength = 64
+
+	// unknownSaveInterval is how often changed unknown MACs are saved.
+	unknownSaveInterval = time.Minute
+)
+
+type (
+	// unknownMACs records the MACs booting without a server of their own,
+	// and the malformed MACs boot requests were made for, for inventory gap
+	// analysis. A nil unknownMACs records nothing.
+	unknownMACs struct {
+		mu        sync.Mutex
+		path      string
+		dirty     bool
+		unknown   map[string]*UnknownMAC
+		malformed map[string]*UnknownMAC
+	}
+
+	// UnknownMAC counts the boot requests of a MAC missing from the config.
+	// Match is the lookup layer of the last request: none, fallback or
+	// discovery.
+	UnknownMAC struct {
+		Count    int       `json:"count"`
+		LastSeen time.Time `json:"last-seen"`
+		Match    string    `json:"match,omitempty"`
+	}
+
+	// UnknownMACsSnapshot is a point in time copy of the unknown MACs, keyed
+	// on the normalized MAC, or on the MAC as requested when malformed.
+	UnknownMACsSnapshot struct {
+		Unknown   map[string]UnknownMAC `json:"unknown"`
+		Malformed map[string]UnknownMAC `json:"malformed"`
+	}
+)
+
+// Creates the unknown MAC sets, loaded from path when it is set and exists.
+func newUnknownMACs(path string) (*unknownMACs, error) {
+	u := &unknownMACs{path: path, unknown: make(map[string]*UnknownMAC), malformed: make(map[string]*UnknownMAC)}
+	if path == "" {
+		return u, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return u, nil
+	}
+	if err != nil {
+		return u, err
+	}
+	var saved UnknownMACsSnapshot
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return u, err
+	}
+	for key, mac := range saved.Unknown {
+		mac := mac
+		u.unknown[key] = &mac
+	}
+	for key, mac := range saved.Malformed {
+		mac := mac
+		u.malformed[key] = &mac
+	}
+	return u, nil
+}
+
+// Counts a request in the set. Must be called with the lock held.
+func (u *unknownMACs) add(set map[string]*UnknownMAC, key, match string, at time.Time) {
+	mac, ok := set[key]
+	if !ok {
+		if len(set) >= maxUnknownMACs {
+			return
+		}
+		mac = &UnknownMAC{}
+		set[key] = mac
+	}
+	mac.Count++
+	mac.LastSeen = at.UTC()
+	mac.Match = match
+	u.dirty = true
+}
+
+// Records a boot request for a valid MAC missing from the config, booted by
+// the match layer.
+func (u *unknownMACs) record(macAddress, match string, at time.Time) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.add(u.unknown, macKey(macAddress), match, at)
+}
+
+// Records a boot request for a malformed MAC.
+func (u *unknownMACs) recordMalformed(macAddress string, at time.Time) {
+	if u == nil {
+		return
+	}
+	if len(macAddress) > maxMalformedLength {
+		macAddress = macAddress[:maxMalformedLength]
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.add(u.malformed, macAddress, "", at)
+}
+
+// Returns a copy of the unknown MACs.
+func (u *unknownMACs) snapshot() UnknownMACsSnapshot {
+	snapshot := UnknownMACsSnapshot{Unknown: make(map[string]UnknownMAC), Malformed: make(map[string]UnknownMAC)}
+	if u == nil {
+		return snapshot
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for key, mac := range u.unknown {
+		snapshot.Unknown[key] = *mac
+	}
+	for key, mac := range u.malformed {
+		snapshot.Malformed[key] = *mac
+	}
+	return snapshot
+}
+
+// Writes the unknown MACs to their file if they changed since the last
+// save, replacing it atomically.
+func (u *unknownMACs) save() error {
+	if u == nil || u.path == "" {
+		return nil
+	}
+	u.mu.Lock()
+	dirty := u.dirty
+	u.dirty = false
+	u.mu.Unlock()
+	if !dirty {
+		return nil
+	}
+	if err := u.write(); err != nil {
+		u.mu.Lock()
+		u.dirty = true
+		u.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Writes a snapshot of the unknown MACs to their file.
+func (u *unknownMACs) write() error {
+	data, err := json.MarshalIndent(u.snapshot(), "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(u.path), filepath.Base(u.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), u.path)
+}
+
+// Saves the unknown MACs every interval until stop is closed. The last
+// changes are saved on shutdown.
+func (u *unknownMACs) run(interval time.Duration, stop <-chan struct{}) {
+	if u == nil || u.path == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := u.save(); err != nil {
+				logrus.WithField(logrus.ErrorKey, err).Warnf(`unable to save unknown macs to "%s".`, u.path)
+			}
+		}
+	}
+}
+
+// Handles the http request for the unknown MACs.
+func (s *Spriteful) handleUnknownMACsRequest(req *restful.Request, res *restful.Response) {
+	res.WriteAsJson(s.unknown.snapshot())
+}
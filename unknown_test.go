@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+)
+
+func TestUnknownMACs(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &Spriteful{
+		Servers:    []Server{{MacAddress: validMac, Kernel: "vmlinuz"}},
+		adminToken: "secret",
+		clock:      func() time.Time { return now },
+	}
+	s.unknown, _ = newUnknownMACs("")
+	serve(s, "GET", "/api/v1/boot/"+validMac, nil)
+	serve(s, "GET", "/api/v1/boot/"+invalidMac, nil)
+	serve(s, "GET", "/api/v1/boot/00-00-00-00-00-01", nil)
+	serve(s, "GET", "/api/v1/boot/not-a-mac", nil)
+
+	var snapshot UnknownMACsSnapshot
+	res := serve(s, "GET", "/api/v1/unknown-macs", http.Header{"Authorization": {"Bearer secret"}})
+	json.Unmarshal(res.Body.Bytes(), &snapshot)
+	if len(snapshot.Unknown) != 1 || snapshot.Unknown[invalidMac].Count != 2 || snapshot.Unknown[invalidMac].Match != MatchNone || !snapshot.Unknown[invalidMac].LastSeen.Equal(now) {
+		t.Errorf("unknown macs should be counted by normalized mac, got %+v", snapshot.Unknown)
+	}
+	if len(snapshot.Malformed) != 1 || snapshot.Malformed["not-a-mac"].Count != 1 {
+		t.Errorf("malformed macs should be kept apart, got %+v", snapshot.Malformed)
+	}
+
+	s.Fallback = &BootEntry{Kernel: "installer"}
+	serve(s, "GET", "/api/v1/boot/"+testMac(1), nil)
+	if mac := s.unknown.snapshot().Unknown[testMac(1)]; mac.Count != 1 || mac.Match != MatchFallback {
+		t.Errorf("macs booted by the fallback entry should be recorded, got %+v", mac)
+	}
+}
+
+func TestUnknownMACsPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "unknown.json")
+
+	unknown, err := newUnknownMACs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown.record(invalidMac, MatchNone, time.Now())
+	unknown.recordMalformed("not-a-mac", time.Now())
+	if err := unknown.save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := newUnknownMACs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot := loaded.snapshot(); snapshot.Unknown[invalidMac].Count != 1 || snapshot.Malformed["not-a-mac"].Count != 1 {
+		t.Errorf("unknown macs should be loaded from their file, got %+v", snapshot)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"encoding/json"
+
+	"github.com/emicklei/go-restful"
+)
+
+// These are the response formats User-Agent rules can select.
+const (
+	ResponseJSON     = "json"
+	ResponseExtended = "extended"
+	ResponseIPXE     = "ipxe"
+)
+
+// UserAgentRule boots clients whose User-Agent matches Match, a regular
+// expression, with its boot entry applied over the server. With Format set,
+// they are answered in that format unless the request asks for one with
+// ?format.
+type UserAgentRule struct {
+	BootEntry
+	Match  string `json:"match"`
+	Format string `json:"format,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// UnmarshalJSON decodes the rule and compiles its pattern, failing the
+// config load when it or the format is invalid.
+func (r *UserAgentRule) UnmarshalJSON(data []byte) error {
+	type rule UserAgentRule
+	var decoded rule
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*r = UserAgentRule(decoded)
+	switch r.Format {
+	case "", ResponseJSON, ResponseExtended, ResponseIPXE:
+	default:
+		return fmt.Errorf("unsupported user agent format %q", r.Format)
+	}
+	pattern, err := regexp.Compile(r.Match)
+	if err != nil {
+		return err
+	}
+	r.pattern = pattern
+	return nil
+}
+
+// Returns the first rule matching the User-Agent, or nil.
+func matchUserAgent(rules []UserAgentRule, userAgent string) *UserAgentRule {
+	for i := range rules {
+		if rules[i].pattern != nil && rules[i].pattern.MatchString(userAgent) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// Returns the server with the first User-Agent rule matching applied, the
+// server's own rules before the global ones, or the server itself when none
+// matches.
+func (s *Spriteful) forUserAgent(userAgent string, server *Server) *Server {
+	rule := matchUserAgent(server.UserAgents, userAgent)
+	if rule == nil {
+		rule = matchUserAgent(s.config().UserAgents, userAgent)
+	}
+	if rule == nil {
+		return server
+	}
+	resolved := rule.apply(server)
+	resolved.format = rule.Format
+	return resolved
+}
+
+// Reports whether the request is answered with an iPXE script: ?format
+// wins, then the format of the server's User-Agent rule, then the Accept
+// header.
+func (s *Server) sendsIPXE(req *restful.Request) bool {
+	if s.format != "" && req.QueryParameter("format") == "" {
+		return s.format == ResponseIPXE
+	}
+	return wantsIPXE(req)
+}
+
+// Reports whether the request is answered with the extended response, like
+// sendsIPXE.
+func (s *Server) sendsExtended(req *restful.Request) bool {
+	if s.format != "" && req.QueryParameter("format") == "" {
+		return s.format == ResponseExtended
+	}
+	return wantsExtended(req)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"encoding/json"
+	"net/http"
+)
+
+func TestUserAgentRules(t *testing.T) {
+	config := `{
+		"user-agents": [
+			{"match": "^iPXE/", "format": "ipxe"},
+			{"match": "GRUB", "kernel": "http://images/grub.vmlinuz"}
+		],
+		"servers": [{
+			"mac": "` + validMac + `",
+			"kernel": "http://images/vmlinuz",
+			"cmdline": "quiet",
+			"user-agents": [{"match": "GRUB 2\\.06", "kernel": "http://images/grub206.vmlinuz", "format": "extended"}]
+		}]
+	}`
+	s, err := decodeConfig(strings.NewReader(config), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := serve(s, "GET", "/api/v1/boot/"+validMac, http.Header{"User-Agent": {"iPXE/1.21.1"}})
+	if ct := res.Header().Get("Content-Type"); ct != IPXEContentType || !strings.Contains(res.Body.String(), "kernel http://images/vmlinuz") {
+		t.Errorf("iPXE clients should get a script, content type: %s, body: %s", ct, res.Body.String())
+	}
+	res = serve(s, "GET", "/api/v1/boot/"+validMac+"?format=json", http.Header{"User-Agent": {"iPXE/1.21.1"}})
+	if ct := res.Header().Get("Content-Type"); ct == IPXEContentType {
+		t.Errorf("?format should win over the rule's format, content type: %s", ct)
+	}
+
+	var response PixieResponse
+	res = serve(s, "GET", "/api/v1/boot/"+validMac, http.Header{"User-Agent": {"GRUB 2.04"}})
+	json.Unmarshal(res.Body.Bytes(), &response)
+	if response.Kernel != "http://images/grub.vmlinuz" || response.CommandLine != "quiet" {
+		t.Errorf("the global rule should apply its boot entry, got %+v", response)
+	}
+
+	res = serve(s, "GET", "/api/v1/boot/"+validMac, http.Header{"User-Agent": {"GRUB 2.06"}, "Accept": {IPXEContentType}})
+	json.Unmarshal(res.Body.Bytes(), &response)
+	if ct := res.Header().Get("Content-Type"); ct != ExtendedContentType || response.Kernel != "http://images/grub206.vmlinuz" {
+		t.Errorf("the server's rule should win over the global ones and the Accept header, content type: %s, got %+v", ct, response)
+	}
+
+	res = serve(s, "GET", "/api/v1/boot/"+validMac, http.Header{"User-Agent": {"curl/7.68.0"}})
+	json.Unmarshal(res.Body.Bytes(), &response)
+	if ct := res.Header().Get("Content-Type"); ct == IPXEContentType || response.Kernel != "http://images/vmlinuz" {
+		t.Errorf("clients matching no rule should get the default response, content type: %s, got %+v", ct, response)
+	}
+}
+
+func TestInvalidUserAgentRules(t *testing.T) {
+	for _, config := range []string{
+		`{"user-agents": [{"match": "("}]}`,
+		`{"user-agents": [{"match": "iPXE", "format": "grub"}]}`,
+	} {
+		if _, err := decodeConfig(strings.NewReader(config), false); err == nil {
+			t.Errorf("config %s should fail to load", config)
+		}
+	}
+}
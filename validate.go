@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+)
+
+// ValidationReport is the outcome of validating a candidate config. Errors
+// would fail loading it, warnings are values the running instance falls
+// back from at boot time.
+type ValidationReport struct {
+	Valid    bool     `json:"valid"`
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings"`
+}
+
+// Returns the report of loading the candidate config the way a reload does:
+// in the configured format and strictness, merged over the base config, and
+// with its cmdline lengths and syntax checked. Servers are also checked as bulk import
+// would, but only warned about since loading accepts them.
+func (s *Spriteful) validateConfig(candidate *Spriteful) *ValidationReport {
+	report := &ValidationReport{Valid: true, Warnings: []string{}}
+	fail := func(err error) *ValidationReport {
+		report.Valid = false
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+	if s.baseConfigPath != "" {
+		base, err := readConfigFile(s.baseConfigPath, 0, 0, s.maxConfigSize, s.configFormat, s.strictConfig)
+		if err != nil {
+			return fail(fmt.Errorf("base config: %v", err))
+		}
+		if candidate, err = mergeConfig(base, candidate); err != nil {
+			return fail(err)
+		}
+	}
+	for i, server := range candidate.Servers {
+		if err := server.validate(candidate.DefaultKernel); err != nil {
+			report.Warnings = append(report.Warnings, fmt.Sprintf(`server %d ("%s"): %v`, i, server.MacAddress, err))
+		}
+	}
+	for _, err := range candidate.longCmdlines(s.maxCmdline) {
+		if s.strictConfig {
+			fail(err)
+			continue
+		}
+		report.Warnings = append(report.Warnings, err.Error())
+	}
+	if s.cmdlineSyntax != "" && s.cmdlineSyntax != CmdlineSyntaxOff {
+		for _, err := range candidate.malformedCmdlines() {
+			if s.cmdlineSyntax == CmdlineSyntaxStrict {
+				fail(err)
+				continue
+			}
+			report.Warnings = append(report.Warnings, err.Error())
+		}
+	}
+	if len(candidate.Servers) == 0 && candidate.Fallback == nil && !s.allowEmpty {
+		report.Warnings = append(report.Warnings, "no servers configured and no fallback set")
+	}
+	return report
+}
+
+// Handles the http request validating a candidate config without applying
+// it.
+func (s *Spriteful) handleValidateRequest(req *restful.Request, res *restful.Response) {
+	r := limitConfig(req.Request.Body, "candidate", s.maxConfigSize)
+	candidate, err := readConfig(r, s.configFormat, s.strictConfig)
+	var report *ValidationReport
+	if err != nil {
+		report = &ValidationReport{Errors: []string{err.Error()}, Warnings: []string{}}
+	} else {
+		report = s.validateConfig(candidate)
+	}
+	if !report.Valid {
+		res.WriteHeaderAndJson(http.StatusUnprocessableEntity, report, restful.MIME_JSON)
+		return
+	}
+	res.WriteAsJson(report)
+}
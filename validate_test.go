@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/emicklei/go-restful"
+)
+
+// Posts the candidate config to the validate endpoint.
+func postCandidate(s *Spriteful, candidate string) (*httptest.ResponseRecorder, ValidationReport) {
+	c := restful.NewContainer()
+	s.register(c)
+	req := httptest.NewRequest("POST", "/api/v1/validate", strings.NewReader(candidate))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+	c.ServeHTTP(res, req)
+	var report ValidationReport
+	json.Unmarshal(res.Body.Bytes(), &report)
+	return res, report
+}
+
+func TestValidateRequest(t *testing.T) {
+	s := &Spriteful{Servers: []Server{{MacAddress: validMac, Kernel: "running"}}, maxCmdline: 16}
+
+	res, report := postCandidate(s, `{"servers": [{"mac": "`+validMac+`", "kernel": "vmlinuz", "cmdline": "quiet"}]}`)
+	if res.Code != http.StatusOK || !report.Valid || len(report.Warnings) != 0 {
+		t.Errorf("a valid config should pass, status: %d body: %s", res.Code, res.Body.String())
+	}
+	res, report = postCandidate(s, `{"servers": [{"mac": "`+validMac+`", "cmdline": "console=ttyS0,115200n8"}]}`)
+	if res.Code != http.StatusOK || !report.Valid || len(report.Warnings) != 2 {
+		t.Errorf("a config with fallbacks should pass with warnings, status: %d body: %s", res.Code, res.Body.String())
+	}
+	res, report = postCandidate(s, "servers:\n  - mac: "+validMac+"\n    kernel: vmlinuz\n")
+	if res.Code != http.StatusOK || !report.Valid {
+		t.Errorf("yaml configs should be validated, status: %d body: %s", res.Code, res.Body.String())
+	}
+
+	res, report = postCandidate(s, `{"servers": [`)
+	if res.Code != http.StatusUnprocessableEntity || report.Valid || len(report.Errors) != 1 {
+		t.Errorf("a broken config should fail, status: %d body: %s", res.Code, res.Body.String())
+	}
+	s.strictConfig = true
+	res, report = postCandidate(s, `{"servers": [{"mac": "`+validMac+`", "kernel": "vmlinuz", "cmdline": "console=ttyS0,115200n8"}]}`)
+	if res.Code != http.StatusUnprocessableEntity || report.Valid {
+		t.Errorf("long cmdlines should fail in strict mode, status: %d body: %s", res.Code, res.Body.String())
+	}
+	res, _ = postCandidate(s, `{"servers": [], "bogus": true}`)
+	if res.Code != http.StatusUnprocessableEntity {
+		t.Errorf("unknown fields should fail in strict mode, status: %d body: %s", res.Code, res.Body.String())
+	}
+
+	s = &Spriteful{cmdlineSyntax: CmdlineSyntaxWarn}
+	malformed := `{"servers": [{"mac": "` + validMac + `", "kernel": "vmlinuz", "cmdline": "custom=\"a b"}]}`
+	if res, report = postCandidate(s, malformed); res.Code != http.StatusOK || len(report.Warnings) != 1 {
+		t.Errorf("malformed cmdlines should be warned about, status: %d body: %s", res.Code, res.Body.String())
+	}
+	s.cmdlineSyntax = CmdlineSyntaxStrict
+	if res, report = postCandidate(s, malformed); res.Code != http.StatusUnprocessableEntity || report.Valid {
+		t.Errorf("malformed cmdlines should fail the strict syntax level, status: %d body: %s", res.Code, res.Body.String())
+	}
+
+	base := writeTestConfig(t, 1, "base")
+	defer os.Remove(base)
+	s = &Spriteful{baseConfigPath: base}
+	res, report = postCandidate(s, `{"servers": [{"mac": "`+testMac(0)+`", "kernel": "vmlinuz"}]}`)
+	if res.Code != http.StatusUnprocessableEntity || report.Valid {
+		t.Errorf("servers duplicating the base config should fail, status: %d body: %s", res.Code, res.Body.String())
+	}
+
+	if server, err := (&Spriteful{Servers: []Server{{MacAddress: validMac, Kernel: "running"}}}).findServerConfig(validMac); err != nil || server.Kernel != "running" {
+		t.Errorf("validating should never change the running config, got %+v", server)
+	}
+	if data, _ := ioutil.ReadFile(base); !strings.Contains(string(data), "base") {
+		t.Error("validating should never change the base config")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"net/http"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+)
+
+// VerifyReport summarizes a verification run of the configured assets.
+type VerifyReport struct {
+	Checked     int
+	Unreachable map[string]string
+}
+
+// Checks distinct asset URLs with a HEAD request using at most workers
+// concurrent checks, and at most perHost of them against any one origin.
+func verifyAssetURLs(client *http.Client, urls []string, workers, perHost int) *VerifyReport {
+	if workers < 1 {
+		workers = 1
+	}
+	if perHost < 1 {
+		perHost = 1
+	}
+	hosts := make(map[string]chan struct{})
+	for _, asset := range urls {
+		host := assetHost(asset)
+		if hosts[host] == nil {
+			hosts[host] = make(chan struct{}, perHost)
+		}
+	}
+
+	report := &VerifyReport{Unreachable: make(map[string]string)}
+	var mu sync.Mutex
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for asset := range jobs {
+				slot := hosts[assetHost(asset)]
+				slot <- struct{}{}
+				err := probeAsset(context.Background(), client, asset)
+				<-slot
+				mu.Lock()
+				report.Checked++
+				if err != nil {
+					report.Unreachable[asset] = err.Error()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, asset := range urls {
+		jobs <- asset
+	}
+	close(jobs)
+	wg.Wait()
+	return report
+}
+
+// Returns the host of an asset URL, or the URL itself if it doesn't parse.
+func assetHost(asset string) string {
+	if u, err := url.Parse(asset); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return asset
+}
+
+// Verifies every remote kernel and initrd of the servers and logs a summary,
+// with one warning per unreachable asset.
+func verifyConfiguredAssets(servers []Server, workers, perHost int) {
+	urls := remoteAssets(servers)
+	logrus.Infof("Verifying %d assets...", len(urls))
+	report := verifyAssetURLs(verifyClient, urls, workers, perHost)
+	unreachable := make([]string, 0, len(report.Unreachable))
+	for asset := range report.Unreachable {
+		unreachable = append(unreachable, asset)
+	}
+	sort.Strings(unreachable)
+	for _, asset := range unreachable {
+		logrus.Warnf(`asset "%s" is unreachable: %s.`, asset, report.Unreachable[asset])
+	}
+	logrus.Infof("Verified %d assets, %d reachable, %d unreachable.", report.Checked, report.Checked-len(unreachable), len(unreachable))
+}
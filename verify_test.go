@@ -0,0 +1,46 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"net/http"
+	"net/http/httptest"
+)
+
+func TestVerifyAssetURLs(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer origin.Close()
+
+	urls := []string{origin.URL + "/missing"}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		urls = append(urls, origin.URL+"/"+name)
+	}
+	report := verifyAssetURLs(origin.Client(), urls, 8, 2)
+	if report.Checked != len(urls) {
+		t.Errorf("every asset should be checked, checked: %d", report.Checked)
+	}
+	if _, ok := report.Unreachable[origin.URL+"/missing"]; !ok || len(report.Unreachable) != 1 {
+		t.Errorf("only the missing asset should be unreachable, got %v", report.Unreachable)
+	}
+	if peak > 2 {
+		t.Errorf("at most two checks should hit one host at a time, peak: %d", peak)
+	}
+}
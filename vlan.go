@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+// maxVLAN is the highest usable 802.1Q VLAN ID.
+const maxVLAN = 4094
+
+// VLANStore is implemented by stores that can also resolve servers keyed on
+// a MAC address and VLAN ID.
+type VLANStore interface {
+	LookupVLAN(macAddress string, vlan int) (*Server, error)
+}
+
+// LookupVLAN returns the server config for the MAC address on the VLAN.
+func (f *fileStore) LookupVLAN(macAddress string, vlan int) (*Server, error) {
+	return f.sprite.findServerByVLAN(macAddress, vlan)
+}
+
+// Returns the server config or an error for the MAC address on the VLAN.
+// Servers without a VLAN never match.
+func (s *Spriteful) findServerByVLAN(macAddress string, vlan int) (*Server, error) {
+	logrus.Infof(`requesting configuration for server "%s" on vlan %d.`, macAddress, vlan)
+	cfg := s.config()
+	if i, ok := cfg.vlans[vlanKey(macAddress, vlan)]; ok {
+		logrus.Info("configuration found.")
+		server := cfg.Servers[i]
+		return &server, nil
+	}
+	return nil, fmt.Errorf("no configuration defined for %s on vlan %d.", macAddress, vlan)
+}
+
+// Returns the index key for a MAC address on a VLAN.
+func vlanKey(macAddress string, vlan int) string {
+	return macKey(macAddress) + "/" + strconv.Itoa(vlan)
+}
+
+// Returns the key identifying the server: its MAC, with its VLAN if it has
+// one.
+func (s *Server) key() string {
+	if s.VLAN == 0 {
+		return macKey(s.MacAddress)
+	}
+	return vlanKey(s.MacAddress, s.VLAN)
+}
+
+// Returns the VLAN ID in the value, or an error unless it is between 1 and
+// maxVLAN.
+func parseVLAN(value string) (int, error) {
+	vlan, err := strconv.Atoi(value)
+	if err != nil || vlan < 1 || vlan > maxVLAN {
+		return 0, fmt.Errorf("malformed vlan %q, expected 1 to %d.", value, maxVLAN)
+	}
+	return vlan, nil
+}
+
+// Handles the http request for server boot configuration keyed on MAC
+// address and VLAN. MACs without a config for the VLAN boot as if no VLAN
+// was given.
+func (s *Spriteful) handleVLANBootRequest(req *restful.Request, res *restful.Response) {
+	logrus.Info("Received pixiecore request...")
+	s = s.withSnapshot()
+	macAddress := req.PathParameter("mac-addr")
+	if err := validMAC(macAddress); err != nil {
+		s.unknown.recordMalformed(macAddress, s.now())
+		writeBootError(res, http.StatusBadRequest, err)
+		return
+	}
+	vlan, err := parseVLAN(req.PathParameter("vlan"))
+	if err != nil {
+		writeBootError(res, http.StatusBadRequest, err)
+		return
+	}
+	s.bootMAC(req, res, macAddress, vlan)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"encoding/json"
+	"net/http"
+)
+
+func TestVLANBoot(t *testing.T) {
+	s := &Spriteful{Servers: []Server{
+		{MacAddress: validMac, Kernel: "http://images/default.vmlinuz"},
+		{MacAddress: validMac, VLAN: 10, Kernel: "http://images/storage.vmlinuz"},
+		{MacAddress: invalidMac, VLAN: 20, Kernel: "http://images/vlan-only.vmlinuz"},
+	}}
+	s.buildIndex()
+	tests := []struct {
+		path   string
+		status int
+		kernel string
+	}{
+		{"/api/v1/boot/" + validMac + "/vlan/10", http.StatusOK, "http://images/storage.vmlinuz"},
+		{"/api/v1/boot/" + validMac + "/vlan/30", http.StatusOK, "http://images/default.vmlinuz"},
+		{"/api/v1/boot/" + validMac, http.StatusOK, "http://images/default.vmlinuz"},
+		{"/api/v1/boot/" + invalidMac + "/vlan/20", http.StatusOK, "http://images/vlan-only.vmlinuz"},
+		{"/api/v1/boot/" + invalidMac, http.StatusNotFound, ""},
+		{"/api/v1/boot/" + validMac + "/vlan/5000", http.StatusBadRequest, ""},
+		{"/api/v1/boot/" + validMac + "/vlan/x", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		res := serve(s, "GET", test.path, nil)
+		if res.Code != test.status {
+			t.Errorf("%s should answer %d, status: %d", test.path, test.status, res.Code)
+			continue
+		}
+		var response PixieResponse
+		json.Unmarshal(res.Body.Bytes(), &response)
+		if response.Kernel != test.kernel {
+			t.Errorf("%s should boot %q, got %q", test.path, test.kernel, response.Kernel)
+		}
+	}
+}
+
+func TestVLANKeys(t *testing.T) {
+	base := &Spriteful{Servers: []Server{{MacAddress: validMac, Kernel: "vmlinuz"}}}
+	main := &Spriteful{Servers: []Server{{MacAddress: validMac, VLAN: 10, Kernel: "vmlinuz"}}}
+	if _, err := mergeConfig(base, main); err != nil {
+		t.Errorf("the same mac on another vlan isn't a duplicate, got %v", err)
+	}
+	if err := (&Server{MacAddress: validMac, Kernel: "vmlinuz", VLAN: 4095}).validate(""); err == nil {
+		t.Error("vlans above 4094 should be rejected")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"hash/fnv"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+// WildcardStore is implemented by stores that can resolve MACs missing from
+// the config against wildcard servers.
+type WildcardStore interface {
+	LookupWildcard(macAddress, clientIP string) (*Server, error)
+}
+
+// LookupWildcard returns the wildcard server for the MAC address.
+func (f *fileStore) LookupWildcard(macAddress, clientIP string) (*Server, error) {
+	return f.sprite.findWildcardServer(macAddress, clientIP)
+}
+
+// Reports whether the server's MAC is a wildcard pattern such as
+// "aa:bb:cc:*" or "*".
+func (s *Server) isWildcard() bool {
+	return strings.ContainsAny(s.MacAddress, "*?[")
+}
+
+// Returns a copy of the wildcard server for a MAC without an exact match,
+// carrying the MAC. When several wildcard servers match, the client IP picks
+// one by rendezvous hashing, so a client keeps its candidate as long as that
+// candidate is configured.
+func (s *Spriteful) findWildcardServer(macAddress, clientIP string) (*Server, error) {
+	key := macKey(macAddress)
+	var chosen *Server
+	var best uint64
+	for _, server := range s.config().Servers {
+		if server.Disabled || !server.isWildcard() {
+			continue
+		}
+		if ok, _ := path.Match(strings.ToLower(server.MacAddress), key); !ok {
+			continue
+		}
+		if weight := candidateWeight(clientIP, &server); chosen == nil || weight > best {
+			candidate := server
+			chosen, best = &candidate, weight
+		}
+	}
+	if chosen == nil {
+		return nil, errors.New(fmt.Sprintf("no configuration defined for %s.", macAddress))
+	}
+	logrus.Infof(`wildcard configuration "%s" found for "%s" from "%s".`, chosen.MacAddress, macAddress, clientIP)
+	chosen.MacAddress = macAddress
+	return chosen, nil
+}
+
+// Returns the rendezvous weight of the candidate for the client IP. A
+// candidate is identified by its pattern, kernel and cmdline, so changing one
+// of them moves its clients.
+func candidateWeight(clientIP string, server *Server) uint64 {
+	hash := fnv.New64a()
+	for _, part := range []string{clientIP, server.MacAddress, server.Kernel, string(server.CommandLine)} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hash.Sum64()
+}
+
+// Returns the IP address of the client that sent the request.
+func clientIP(req *restful.Request) string {
+	host, _, err := net.SplitHostPort(req.Request.RemoteAddr)
+	if err != nil {
+		return req.Request.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"encoding/json"
+	"net/http"
+)
+
+func TestWildcardConsistentHashing(t *testing.T) {
+	s := &Spriteful{Servers: []Server{
+		{MacAddress: validMac, Kernel: "exact"},
+		{MacAddress: "aa:bb:*", Kernel: "stable"},
+		{MacAddress: "aa:bb:*", Kernel: "canary"},
+	}}
+	if server, err := s.findWildcardServer("cc:00:00:00:00:00", "10.0.0.1"); err == nil {
+		t.Errorf("macs outside the pattern should not match, got %+v", server)
+	}
+
+	assigned := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		ip := fmt.Sprintf("10.0.%d.%d", i/250, i%250)
+		server, err := s.findWildcardServer("AA-BB-00-00-00-01", ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again, _ := s.findWildcardServer("aa:bb:00:00:00:01", ip); again.Kernel != server.Kernel {
+			t.Errorf("%s should consistently get %s, got %s", ip, server.Kernel, again.Kernel)
+		}
+		assigned[ip] = server.Kernel
+		counts[server.Kernel]++
+	}
+	if counts["stable"] == 0 || counts["canary"] == 0 {
+		t.Errorf("clients should spread over the candidates, counts: %v", counts)
+	}
+
+	s.Servers = append(s.Servers, Server{MacAddress: "aa:bb:*", Kernel: "next"})
+	for ip, kernel := range assigned {
+		if server, _ := s.findWildcardServer("aa:bb:00:00:00:01", ip); server.Kernel != kernel && server.Kernel != "next" {
+			t.Errorf("%s should only move to the new candidate, moved from %s to %s", ip, kernel, server.Kernel)
+		}
+	}
+
+	var response PixieResponse
+	res := serve(s, "GET", "/api/v1/boot/"+validMac, nil)
+	json.Unmarshal(res.Body.Bytes(), &response)
+	if response.Kernel != "exact" {
+		t.Errorf("exact matches should win over wildcards, kernel: %s", response.Kernel)
+	}
+	if res := serve(s, "GET", "/api/v1/boot/aa:bb:00:00:00:01", nil); res.Code != http.StatusOK {
+		t.Errorf("wildcard servers should be booted, status: %d", res.Code)
+	}
+}
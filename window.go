@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"time"
+
+	"encoding/json"
+)
+
+// Layout accepted for window times without a zone, which are taken as UTC.
+const windowTimeLayout = "2006-01-02T15:04:05"
+
+type (
+	// BootEntry is an alternate kernel, initrd and cmdline for a server.
+	BootEntry struct {
+		Kernel      string   `json:"kernel,omitempty"`
+		Initrd      []Initrd `json:"initrd,omitempty"`
+		CommandLine Cmdline  `json:"cmdline,omitempty"`
+	}
+
+	// WindowedEntry is a boot entry used instead of the server's own config
+	// while the current time is within [ActiveFrom, ActiveUntil). Either
+	// bound may be omitted.
+	WindowedEntry struct {
+		BootEntry
+		ActiveFrom  WindowTime `json:"active-from,omitempty"`
+		ActiveUntil WindowTime `json:"active-until,omitempty"`
+	}
+
+	// WindowTime is an RFC 3339 time. Times without a zone offset are UTC.
+	WindowTime struct {
+		time.Time
+	}
+)
+
+// UnmarshalJSON parses RFC 3339 times and zone-less times as UTC.
+func (w *WindowTime) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		if parsed, err = time.ParseInLocation(windowTimeLayout, value, time.UTC); err != nil {
+			return err
+		}
+	}
+	w.Time = parsed
+	return nil
+}
+
+// MarshalJSON writes RFC 3339 times and null for unset bounds.
+func (w WindowTime) MarshalJSON() ([]byte, error) {
+	if w.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(w.Time.Format(time.RFC3339))
+}
+
+// Returns whether the window is active at now.
+func (w *WindowedEntry) active(now time.Time) bool {
+	if !w.ActiveFrom.IsZero() && now.Before(w.ActiveFrom.Time) {
+		return false
+	}
+	if !w.ActiveUntil.IsZero() && !now.Before(w.ActiveUntil.Time) {
+		return false
+	}
+	return true
+}
+
+// Returns a copy of the server with the entry's non-empty values applied.
+// The checksums of a replaced kernel or initrds are dropped.
+func (e *BootEntry) apply(server *Server) *Server {
+	resolved := *server
+	if e.Kernel != "" && e.Kernel != server.Kernel {
+		resolved.Kernel = e.Kernel
+		resolved.KernelSHA256 = ""
+	}
+	if e.Initrd != nil {
+		resolved.Initrd = e.Initrd
+		resolved.InitrdSHA256 = nil
+	}
+	if e.CommandLine != "" {
+		resolved.CommandLine = e.CommandLine
+	}
+	return &resolved
+}
+
+// Returns a copy of the server with the entry filling in what the server
+// leaves empty. Cmdline tokens are merged by key, the server's tokens win.
+func (e *BootEntry) under(server *Server) *Server {
+	resolved := *server
+	if resolved.Kernel == "" {
+		resolved.Kernel = e.Kernel
+	}
+	if resolved.Initrd == nil {
+		resolved.Initrd = e.Initrd
+	}
+	resolved.CommandLine = e.CommandLine.merge(server.CommandLine)
+	return &resolved
+}
+
+// Returns the server with its first active windowed entry applied.
+func (s *Server) atTime(now time.Time) *Server {
+	for i := range s.Windows {
+		if s.Windows[i].active(now) {
+			return s.Windows[i].apply(s)
+		}
+	}
+	return s
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"encoding/json"
+)
+
+func TestWindowedBoot(t *testing.T) {
+	config := []byte(`{"servers": [{
+		"mac": "00:00:00:00:00:00",
+		"kernel": "normal",
+		"cmdline": "quiet",
+		"windows": [
+			{"kernel": "installer", "active-from": "2020-01-01T02:00:00", "active-until": "2020-01-01T04:00:00"},
+			{"cmdline": "rescue", "active-from": "2020-01-01T10:00:00+02:00"}
+		]
+	}]}`)
+	var s Spriteful
+	if err := json.Unmarshal(config, &s); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]PixieResponse{
+		"2020-01-01T01:59:59Z": {Kernel: "normal", CommandLine: "quiet"},
+		"2020-01-01T02:00:00Z": {Kernel: "installer", CommandLine: "quiet"},
+		"2020-01-01T04:00:00Z": {Kernel: "normal", CommandLine: "quiet"},
+		"2020-01-01T08:00:00Z": {Kernel: "normal", CommandLine: "rescue"},
+	}
+	for at, want := range cases {
+		now, _ := time.Parse(time.RFC3339, at)
+		s.clock = func() time.Time { return now }
+		var got PixieResponse
+		json.Unmarshal(serve(&s, "GET", "/api/v1/boot/"+validMac, nil).Body.Bytes(), &got)
+		if got.Kernel != want.Kernel || got.CommandLine != want.CommandLine {
+			t.Errorf("at %s %+v is expected, got %+v", at, want, got)
+		}
+	}
+}
+
+func TestArchDefaults(t *testing.T) {
+	config := []byte(`{
+		"arch-defaults": {
+			"arm64": {"kernel": "arm64.vmlinuz", "initrd": ["arm64.initrd"], "cmdline": "console=ttyAMA0 earlyprintk"}
+		},
+		"servers": [
+			{"mac": "00:00:00:00:00:00", "cmdline": "quiet"},
+			{"mac": "00:00:00:00:00:01", "kernel": "custom.vmlinuz", "initrd": [], "cmdline": "console=tty1 quiet"}
+		]
+	}`)
+	var s Spriteful
+	if err := json.Unmarshal(config, &s); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path string
+		want PixieResponse
+	}{
+		{"/api/v1/boot/00:00:00:00:00:00", PixieResponse{CommandLine: "quiet"}},
+		{"/api/v1/boot/00:00:00:00:00:00?arch=x86_64", PixieResponse{CommandLine: "quiet"}},
+		{"/api/v1/boot/00:00:00:00:00:00?arch=arm64", PixieResponse{Kernel: "arm64.vmlinuz", Initrd: []string{"arm64.initrd"}, CommandLine: "console=ttyAMA0 earlyprintk quiet"}},
+		{"/api/v1/boot/00:00:00:00:00:01?arch=arm64", PixieResponse{Kernel: "custom.vmlinuz", Initrd: []string{}, CommandLine: "earlyprintk console=tty1 quiet"}},
+	}
+	for _, c := range cases {
+		var got PixieResponse
+		json.Unmarshal(serve(&s, "GET", c.path, nil).Body.Bytes(), &got)
+		if got.Kernel != c.want.Kernel || got.CommandLine != c.want.CommandLine || len(got.Initrd) != len(c.want.Initrd) {
+			t.Errorf("%s should resolve to %+v, got %+v", c.path, c.want, got)
+		}
+	}
+}
+
+func TestDefaultKernel(t *testing.T) {
+	config := []byte(`{
+		"default-kernel": "shared.vmlinuz",
+		"default-initrd": ["shared.initrd"],
+		"arch-defaults": {"arm64": {"kernel": "arm64.vmlinuz"}},
+		"servers": [
+			{"mac": "00:00:00:00:00:00", "cmdline": "quiet"},
+			{"mac": "00:00:00:00:00:01", "kernel": "custom.vmlinuz", "initrd": [], "cmdline": "quiet"}
+		]
+	}`)
+	var s Spriteful
+	if err := json.Unmarshal(config, &s); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path string
+		want PixieResponse
+	}{
+		{"/api/v1/boot/00:00:00:00:00:00", PixieResponse{Kernel: "shared.vmlinuz", Initrd: []string{"shared.initrd"}}},
+		{"/api/v1/boot/00:00:00:00:00:00?arch=arm64", PixieResponse{Kernel: "arm64.vmlinuz", Initrd: []string{"shared.initrd"}}},
+		{"/api/v1/boot/00:00:00:00:00:01", PixieResponse{Kernel: "custom.vmlinuz", Initrd: []string{}}},
+	}
+	for _, c := range cases {
+		var got PixieResponse
+		json.Unmarshal(serve(&s, "GET", c.path, nil).Body.Bytes(), &got)
+		if got.Kernel != c.want.Kernel || len(got.Initrd) != len(c.want.Initrd) {
+			t.Errorf("%s should resolve to %+v, got %+v", c.path, c.want, got)
+		}
+	}
+	if err := (&Server{MacAddress: validMac}).validate(s.DefaultKernel); err != nil {
+		t.Errorf("a server without a kernel should be valid with a default kernel: %v", err)
+	}
+	if err := (&Server{MacAddress: validMac}).validate(""); err == nil {
+		t.Errorf("a server without any kernel should be invalid")
+	}
+}